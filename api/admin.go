@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"awesomeProject/internal/cash"
+	"awesomeProject/internal/token"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+type DeletedUser struct {
+	Nickname  string    `json:"nickname"`
+	Email     string    `json:"email"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type DeletedUsersPage struct {
+	Page  int64         `json:"page"`
+	Users []DeletedUser `json:"users"`
+}
+
+// UnlockUser lifts a login lockout of an account.
+func UnlockUser(c echo.Context, repo users.UserRepository, guard *token.LoginGuard) error {
+	user, err := repo.FindByNickname(c.Request().Context(), c.Param(nickname))
+	if err != nil {
+		return userLookupError(c, err)
+	}
+	if err := guard.Unlock(c.Request().Context(), user.Nickname); err != nil {
+		return internalError(c, "unlock user", err)
+	}
+	return c.JSON(http.StatusOK, Message{Message: "user unlocked"})
+}
+
+// CacheStats serves GET /admin/cache/stats/ with the counters of the
+// response cache, so that Redis trouble shows even though requests succeed.
+func CacheStats(c echo.Context, cache *cash.Cache) error {
+	return c.JSON(http.StatusOK, cache.Stats())
+}
+
+// ListDeletedUsers serves GET /admin/users/deleted/?page=N.
+func ListDeletedUsers(c echo.Context, repo users.UserRepository, pageSize int64) error {
+	page, apiErr := queryInt(c, "page", 0, 1<<31)
+	if apiErr != nil {
+		return respondError(c, apiErr)
+	}
+	list, err := repo.ListDeleted(c.Request().Context(), page*pageSize, pageSize)
+	if err != nil {
+		return internalError(c, "list deleted users", err)
+	}
+	result := DeletedUsersPage{Page: page, Users: make([]DeletedUser, 0, len(list))}
+	for _, u := range list {
+		result.Users = append(result.Users, DeletedUser{Nickname: u.Nickname, Email: u.Email, DeletedAt: u.DeletedAt})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// RestoreUser undoes a soft delete. The account has to log in again, since
+// its sessions were revoked on deletion.
+func RestoreUser(c echo.Context, repo users.UserRepository, leaderboard *cash.Leaderboard, cache *cash.Cache) error {
+	ctx := c.Request().Context()
+	if err := repo.Restore(ctx, c.Param(nickname)); err != nil {
+		return userLookupError(c, err)
+	}
+	user, err := repo.FindByNickname(ctx, c.Param(nickname))
+	if err != nil {
+		return userLookupError(c, err)
+	}
+	updateLeaderboard(ctx, leaderboard, user.Nickname, user.Rating)
+	cache.UserAdded(ctx)
+	return c.JSON(http.StatusOK, newProfile(user))
+}
+
+// PurgeDeletedUsers removes accounts soft-deleted more than retention ago,
+// together with the votes they cast or received. It returns how many
+// accounts were removed.
+func PurgeDeletedUsers(ctx context.Context, repo users.UserRepository, votes users.VoteRepository, retention time.Duration) (int, error) {
+	purged, err := repo.PurgeDeleted(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	if err := votes.DeleteForUsers(ctx, purged); err != nil {
+		return len(purged), err
+	}
+	return len(purged), nil
+}
+
+// SchedulePurge runs PurgeDeletedUsers every interval until ctx is done.
+func SchedulePurge(ctx context.Context, repo users.UserRepository, votes users.VoteRepository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := PurgeDeletedUsers(ctx, repo, votes, retention)
+		if err != nil {
+			log.Errorf("purge deleted users: %s", err)
+		} else if n > 0 {
+			log.Infof("purged %d deleted users", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/cash"
+	"awesomeProject/users"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestDeleteRestoreAndPurge(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	votes := users.NewMemoryVoteRepository(repo)
+	store := newTestStore(t)
+	leaderboard, cache := newTestLeaderboard(t), newTestCache(t)
+	owner := seedUser(t, repo, "owner", "Qwerty1123@#")
+	admin := seedUser(t, repo, "admin", "Qwerty1123@#")
+	admin.Role = users.RoleAdmin
+	ctx := context.Background()
+
+	login := func() int {
+		c, rec := newFormContext(http.MethodPost, "/log-in/", url.Values{nickname: {"owner"}, password: {"Qwerty1123@#"}})
+		if err := Login(c, repo, testHasher, testTokens, store, UnverifiedAllow, newTestTwoFactor(t), newTestGuard(t)); err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		return rec.Code
+	}
+	if code := login(); code != http.StatusOK {
+		t.Fatalf("Login() before delete = %d", code)
+	}
+	if _, err := votes.Vote(ctx, "owner", "admin", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	c, rec := newFormContext(http.MethodPost, "/profile/delete/owner/", url.Values{})
+	c.SetParamNames(nickname)
+	c.SetParamValues("owner")
+	authenticate(c, owner)
+	if err := Delete(c, repo, leaderboard, cache, store); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Delete() = %d, %v", rec.Code, err)
+	}
+	if code := login(); code != http.StatusUnauthorized {
+		t.Errorf("Login() of a deleted user = %d, expected %d", code, http.StatusUnauthorized)
+	}
+
+	c, rec = newFormContext(http.MethodGet, "/rating/owner/", nil)
+	c.SetParamNames(nickname)
+	c.SetParamValues("owner")
+	if err := GetRating(c, repo, cache); err != nil || rec.Code != http.StatusNotFound {
+		t.Errorf("GetRating() of a deleted user = %d, %v", rec.Code, err)
+	}
+
+	c, rec = newFormContext(http.MethodGet, "/admin/users/deleted/", nil)
+	authenticate(c, admin)
+	if err := ListDeletedUsers(c, repo, 10); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("ListDeletedUsers() = %d, %v", rec.Code, err)
+	}
+	var deleted DeletedUsersPage
+	_ = json.Unmarshal(rec.Body.Bytes(), &deleted)
+	if len(deleted.Users) != 1 || deleted.Users[0].Nickname != "owner" || deleted.Users[0].DeletedAt.IsZero() {
+		t.Errorf("ListDeletedUsers() = %+v, expected owner", deleted)
+	}
+
+	c, rec = newFormContext(http.MethodPost, "/admin/users/owner/restore/", nil)
+	c.SetParamNames(nickname)
+	c.SetParamValues("owner")
+	authenticate(c, admin)
+	if err := RestoreUser(c, repo, leaderboard, cache); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("RestoreUser() = %d, %v", rec.Code, err)
+	}
+	if code := login(); code != http.StatusOK {
+		t.Errorf("Login() after restore = %d, expected %d", code, http.StatusOK)
+	}
+
+	c, rec = newFormContext(http.MethodPost, "/admin/users/admin/restore/", nil)
+	c.SetParamNames(nickname)
+	c.SetParamValues("admin")
+	if err := RestoreUser(c, repo, leaderboard, cache); err != nil || rec.Code != http.StatusNotFound {
+		t.Errorf("RestoreUser() of an active user = %d, %v", rec.Code, err)
+	}
+
+	if n, err := PurgeDeletedUsers(ctx, repo, votes, 0); err != nil || n != 0 {
+		t.Errorf("PurgeDeletedUsers() without deleted users = %d, %v", n, err)
+	}
+	_ = repo.SoftDelete(ctx, "owner")
+	if n, err := PurgeDeletedUsers(ctx, repo, votes, time.Hour); err != nil || n != 0 {
+		t.Errorf("PurgeDeletedUsers() within retention = %d, %v", n, err)
+	}
+	if n, err := PurgeDeletedUsers(ctx, repo, votes, -time.Second); err != nil || n != 1 {
+		t.Errorf("PurgeDeletedUsers() past retention = %d, %v", n, err)
+	}
+	if _, err := votes.FindVote(ctx, "owner", "admin"); !errors.Is(err, users.ErrVoteNotFound) {
+		t.Errorf("FindVote() of a purged voter = %v, expected %v", err, users.ErrVoteNotFound)
+	}
+}
+
+func TestCacheStatsCountRedisFailures(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	seedUser(t, repo, "owner", "Qwerty1123@#")
+	mr := miniredis.RunT(t)
+	cache := cash.NewCache(redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1}), cash.DefaultTTL)
+	mr.Close()
+
+	c, rec := newFormContext(http.MethodGet, "/users/owner/", nil)
+	c.SetParamNames(nickname)
+	c.SetParamValues("owner")
+	if err := UserProfile(c, repo, cache); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("UserProfile() with Redis down = %d, %v", rec.Code, err)
+	}
+
+	c, rec = newFormContext(http.MethodGet, "/admin/cache/stats/", nil)
+	if err := CacheStats(c, cache); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("CacheStats() = %d, %v", rec.Code, err)
+	}
+	var stats cash.Stats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	// Without the generation from the failed get, nothing is set.
+	if stats.Errors != 1 || stats.Misses != 1 {
+		t.Errorf("CacheStats() = %+v, expected the failed get as 1 error and 1 miss", stats)
+	}
+}
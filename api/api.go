@@ -6,421 +6,521 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"awesomeProject/internal/cash"
 	"awesomeProject/internal/hash"
 	"awesomeProject/internal/token"
 	"awesomeProject/internal/validation"
 	"awesomeProject/users"
 
-	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
-	"github.com/redis/go-redis/v9"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const (
-	nickname        = "nickname"
-	firstname       = "firstname"
-	lastname        = "lastname"
-	email           = "email"
-	password        = "password"
-	information     = "information"
-	role            = "role"
-	databaseName    = "api_users"
-	tableName       = "users"
-	usersLimit      = 3
-	cacheExpiration = time.Minute
+	nickname    = "nickname"
+	firstname   = "firstname"
+	lastname    = "lastname"
+	email       = "email"
+	password    = "password"
+	information = "information"
+
+	refreshTokenParam = "refresh_token"
+
+	invalidLoginMessage = "invalid nickname or password"
+
+	voteCooldown = time.Hour
 )
 
 func Hello(c echo.Context) error {
-	return c.String(http.StatusOK, "Hello, World!")
+	return c.JSON(http.StatusOK, Message{Message: "Hello, World!"})
 }
 
-func GetAllUsers(c echo.Context, client *mongo.Client, redisClient *redis.Client) error {
+// JWKS publishes the public keys of access tokens so other services can
+// verify them without a shared secret. Caches may keep the answer for a
+// while; a rotated key stays listed until its tokens have expired.
+func JWKS(c echo.Context, tokens *token.Manager) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, tokens.Keys().JWKS())
+}
+
+func GetAllUsers(c echo.Context, repo users.UserRepository, cache *cash.Cache, pageSize int64) error {
 	var usersPage int64
 	if c.Param("page") == "" {
 		usersPage = 0
 	} else {
 		page, err := strconv.ParseInt(c.Param("page"), 10, 64)
-		if err != nil {
-			return c.String(http.StatusBadRequest, fmt.Sprintf("bad request: %s", err))
+		if err != nil || page < 0 {
+			return fail(c, http.StatusBadRequest, CodeBadRequest, "page must be a non-negative integer")
 		}
 		usersPage = page
 	}
-	redisKey := fmt.Sprintf("/users/%d", usersPage)
-	cachedData, err := redisClient.Get(context.TODO(), redisKey).Result()
-	if err == nil {
-		return c.String(http.StatusOK, cachedData)
+	ctx := c.Request().Context()
+	cached, gen, ok := cache.Get(ctx, cash.ListingKey(usersPage))
+	if ok {
+		return c.JSONBlob(http.StatusOK, cached)
 	}
 
-	collection := client.Database(databaseName).Collection(tableName)
-	filter := bson.M{}
-	queryOptions := options.Find().SetSort(bson.D{{Key: "Rating", Value: -1}})
-	queryOptions.SetLimit(usersLimit)
-	queryOptions.SetSkip(usersPage * usersLimit)
-	cursor, err := collection.Find(context.TODO(), filter, queryOptions)
+	list, err := repo.ListByRating(ctx, usersPage*pageSize, pageSize)
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("users not found: %s", err))
+		return internalError(c, "list users", err)
 	}
-	var sb strings.Builder
-	for cursor.Next(context.TODO()) {
-		var user users.User
-		err := cursor.Decode(&user)
-		if err != nil {
-			return c.String(http.StatusBadRequest, fmt.Sprintf("user decode error: %s", err))
-		}
-		if len(sb.String()) > 0 {
-			sb.WriteString("\n")
-		}
-		sb.WriteString(fmt.Sprintf("%s - %s", user.Nickname, user.Email))
+	result := UsersPage{Page: usersPage, Users: make([]UserSummary, 0, len(list))}
+	for i := range list {
+		result.Users = append(result.Users, newUserSummary(&list[i]))
 	}
-	cursor.Close(context.TODO())
-	err = redisClient.Set(context.TODO(), redisKey, sb.String(), cacheExpiration).Err()
+	data, err := json.Marshal(result)
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("redis error: %s", err))
+		return internalError(c, "encode response", err)
 	}
-	return c.String(http.StatusOK, sb.String())
+	cache.SetListing(ctx, usersPage, gen, data)
+	return c.JSONBlob(http.StatusOK, data)
 }
 
-func UserRegister(c echo.Context, client *mongo.Client) error {
-	user, err := CreateUser(c, client)
+func UserRegister(c echo.Context, repo users.UserRepository, hasher *hash.PasswordHasher, leaderboard *cash.Leaderboard, cache *cash.Cache, verification *EmailVerification, rules *validation.Policy) error {
+	user, err := CreateUser(c, repo, hasher, rules)
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("create user error: %s", err))
+		return registrationError(c, err)
 	}
-	err = addUser(*user)
+	err = repo.Insert(c.Request().Context(), user)
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("add user error: %s", err))
+		return registrationError(c, err)
+	}
+	updateLeaderboard(c.Request().Context(), leaderboard, user.Nickname, user.Rating)
+	cache.UserAdded(c.Request().Context())
+	// The account exists either way; a lost email can be resent.
+	if _, err := verification.Tokens.Throttle(c.Request().Context(), token.PurposeVerifyEmail, user.Email, verification.ResendInterval); err != nil {
+		log.Errorf("throttle verification email for %s: %s", user.Nickname, err)
+	}
+	if err := verification.sendVerification(c.Request().Context(), user); err != nil {
+		log.Errorf("send verification email to %s: %s", user.Nickname, err)
+	}
+	return c.JSON(http.StatusCreated, newProfile(user))
+}
+
+func registrationError(c echo.Context, err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return respondError(c, apiErr)
+	}
+	if errors.Is(err, users.ErrUserExists) {
+		return fail(c, http.StatusConflict, CodeConflict, err.Error())
 	}
-	return c.String(http.StatusOK, "user added")
+	return internalError(c, "create user", err)
 }
 
-func CreateUser(c echo.Context, client *mongo.Client) (*users.User, error) {
+// CreateUser builds a new user from the registration form. Names are
+// stored normalized. Validation failures are returned as *APIError, a taken
+// email or a nickname looking like a taken one as users.ErrUserExists.
+func CreateUser(c echo.Context, repo users.UserRepository, hasher *hash.PasswordHasher, rules *validation.Policy) (*users.User, error) {
 	user := &users.User{
-		FirstName:   c.FormValue(firstname),
-		LastName:    c.FormValue(lastname),
-		Nickname:    c.FormValue(nickname),
+		FirstName:   validation.NormalizeName(c.FormValue(firstname)),
+		LastName:    validation.NormalizeName(c.FormValue(lastname)),
+		Nickname:    validation.NormalizeName(c.FormValue(nickname)),
 		Email:       strings.ToLower(c.FormValue(email)),
 		Password:    c.FormValue(password),
 		Information: c.FormValue(information),
-		Role:        c.FormValue(role),
+		Role:        users.RoleUser,
 		CreatedAt:   time.Now(),
 	}
 
-	var validationErr string
-
-	switch {
-	case !validation.NameValidation(user.FirstName):
-		validationErr = "incorrect first name"
-	case !validation.NameValidation(user.LastName):
-		validationErr = "incorrect last name"
-	case !validation.NicknameValidation(user.Nickname):
-		validationErr = "incorrect nickname"
-	case !validation.EmailValidation(user.Email):
-		validationErr = "incorrect email"
-	case !validation.PasswordValidation(user.Password):
-		validationErr = "incorrect password"
+	var v validation.Validator
+	v.Required(firstname, user.FirstName, rules.NameRules()...)
+	v.Required(lastname, user.LastName, rules.NameRules()...)
+	v.Required(nickname, user.Nickname, rules.NicknameRules()...)
+	v.Required(email, user.Email, validation.Email)
+	v.Required(password, user.Password, rules.Password.Rules(user.Nickname, user.Email)...)
+	if apiErr := invalidRequest(&v); apiErr != nil {
+		return nil, apiErr
 	}
 
-	if validationErr != "" {
-		return nil, errors.New(validationErr)
+	ctx := c.Request().Context()
+	if _, err := repo.FindLookalike(ctx, user.Nickname); err == nil {
+		return nil, users.ErrUserExists
 	}
-
-	res, _ := getUserByEmail(user.Nickname, client)
-	if res != nil {
-		return nil, errors.New("user with this email or username exists")
+	if _, err := repo.FindByEmail(ctx, user.Email); err == nil {
+		return nil, users.ErrUserExists
 	}
 
-	user.Password = hash.HashString(user.Password)
+	passwordHash, err := hasher.Hash(user.Password)
+	if err != nil {
+		return nil, fmt.Errorf("hash password: %w", err)
+	}
+	user.Password = passwordHash
 
 	return user, nil
 }
 
-func UserEdit(c echo.Context, client *mongo.Client) error {
-
-	if c.FormValue(nickname) == "" {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("nickname not found"))
+func UserEdit(c echo.Context, repo users.UserRepository, hasher *hash.PasswordHasher, cache *cash.Cache, rules *validation.Policy) error {
+	target := c.FormValue(nickname)
+	if target == "" {
+		if claims := token.ClaimsFromContext(c); claims != nil {
+			target = claims.UserNickname
+		}
 	}
-	user, err := getUserByEmail(c.FormValue(nickname), client)
-	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("get user by email: %s", err))
+	if apiErr := authorizeTarget(c, target, users.PermProfileEditSelf, users.PermProfileEditAny); apiErr != nil {
+		return respondError(c, apiErr)
 	}
-	if c.FormValue(firstname) != "" {
-		if !validation.NameValidation(c.FormValue(firstname)) {
-			return c.String(http.StatusBadRequest, fmt.Sprintf("incorrect First name: %s", err))
+	user, err := repo.FindByNickname(c.Request().Context(), target)
+	if err != nil {
+		return userLookupError(c, err)
+	}
+	if claims := token.ClaimsFromContext(c); claims.UserNickname != user.Nickname {
+		// Staff may fix other profiles, but not take them over: a new
+		// password can only be set by the owner, and accounts above the
+		// caller's role are off limits.
+		if users.Outranks(user.Role, claims.UserRole) {
+			return fail(c, http.StatusForbidden, CodeForbidden, "cannot edit an account with a higher role")
 		}
-		user.FirstName = c.FormValue(firstname)
-	}
-	if c.FormValue(lastname) != "" {
-		if !validation.NameValidation(c.FormValue(lastname)) {
-			return c.String(http.StatusBadRequest, fmt.Sprintf("incorrect Last name: %s", err))
+		if c.FormValue(password) != "" {
+			return fail(c, http.StatusForbidden, CodeForbidden, "only the owner can change the password")
 		}
-		user.LastName = c.FormValue(lastname)
+	}
+	newFirstName := validation.NormalizeName(c.FormValue(firstname))
+	newLastName := validation.NormalizeName(c.FormValue(lastname))
+	var v validation.Validator
+	v.Optional(firstname, newFirstName, rules.NameRules()...)
+	v.Optional(lastname, newLastName, rules.NameRules()...)
+	v.Optional(password, c.FormValue(password), rules.Password.Rules(user.Nickname, user.Email)...)
+	if apiErr := invalidRequest(&v); apiErr != nil {
+		return respondError(c, apiErr)
+	}
+	if newFirstName != "" {
+		user.FirstName = newFirstName
+	}
+	if newLastName != "" {
+		user.LastName = newLastName
 	}
 	if c.FormValue(password) != "" {
-		if !validation.PasswordValidation(c.FormValue(password)) {
-			return c.String(http.StatusBadRequest, fmt.Sprintf("incorrect password: %s", err))
+		user.Password, err = hasher.Hash(c.FormValue(password))
+		if err != nil {
+			return internalError(c, "hash password", err)
 		}
-		user.Password = hash.HashString(c.FormValue(password))
 	}
 	if c.FormValue(information) != "" {
 		user.Information = c.FormValue(information)
 	}
 	user.UpdatedAt = time.Now()
 
-	filter := bson.M{nickname: user.Nickname}
-
-	update := bson.M{
-		"$set": bson.M{
-			firstname:    user.FirstName,
-			lastname:     user.LastName,
-			information:  user.Information,
-			"updated_at": user.UpdatedAt,
-			password:     user.Password,
-		},
+	err = repo.Update(c.Request().Context(), user)
+	if errors.Is(err, users.ErrUserExists) {
+		return fail(c, http.StatusConflict, CodeConflict, err.Error())
 	}
-	collection := client.Database(databaseName).Collection(tableName)
-	_, err = collection.UpdateOne(context.TODO(), filter, update)
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("error update user: %s", err))
+		return userLookupError(c, err)
 	}
-	return c.String(http.StatusOK, "user edited")
+	cache.ProfileChanged(c.Request().Context(), user.Nickname)
+	return c.JSON(http.StatusOK, newProfile(user))
 }
 
-func UserProfile(c echo.Context, client *mongo.Client, redisClient *redis.Client) error {
+func UserProfile(c echo.Context, repo users.UserRepository, cache *cash.Cache) error {
 	paramNickname := c.Param(nickname)
 
-	redisKey := fmt.Sprintf("user-profile-%s", paramNickname)
-	cachedData, err := redisClient.Get(context.TODO(), redisKey).Result()
-	if err == nil {
-		return c.String(http.StatusOK, cachedData)
-	}
-
-	user, err := getUserByNickname(paramNickname, client)
-	if err != nil {
-		return c.String(http.StatusNotFound, "user not found")
+	cacheKey := cash.ProfileKey(paramNickname)
+	cached, gen, ok := cache.Get(c.Request().Context(), cacheKey)
+	if ok {
+		return c.JSONBlob(http.StatusOK, cached)
 	}
 
-	result := fmt.Sprintf("User name: %s\nUser lastname: %s\nUser id: %s", user.FirstName, user.LastName, user.ID)
-	err = redisClient.Set(context.TODO(), redisKey, result, cacheExpiration).Err()
+	user, err := repo.FindByNickname(c.Request().Context(), paramNickname)
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("redis error: %s", err))
+		return userLookupError(c, err)
 	}
 
-	return c.String(http.StatusOK, result)
+	return cacheJSON(c, cache, cacheKey, gen, newProfile(user))
 }
 
-func GetRating(c echo.Context, client *mongo.Client, redisClient *redis.Client) error {
+func GetRating(c echo.Context, repo users.UserRepository, cache *cash.Cache) error {
 	paramNickname := c.Param(nickname)
 
-	redisKey := fmt.Sprintf("user-rating-%s", paramNickname)
-	cachedData, err := redisClient.Get(context.TODO(), redisKey).Result()
-	if err == nil {
-		return c.String(http.StatusOK, cachedData)
+	cacheKey := cash.RatingKey(paramNickname)
+	cached, gen, ok := cache.Get(c.Request().Context(), cacheKey)
+	if ok {
+		return c.JSONBlob(http.StatusOK, cached)
 	}
 
-	user, err := getUserByNickname(paramNickname, client)
+	user, err := repo.FindByNickname(c.Request().Context(), paramNickname)
 	if err != nil {
-		return c.String(http.StatusNotFound, fmt.Sprintf("incorect user nickname: %s", err))
+		return userLookupError(c, err)
 	}
 
-	result := fmt.Sprintf("user has %d", user.Rating)
-	err = redisClient.Set(context.TODO(), redisKey, result, cacheExpiration).Err()
-	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("redis error: %s", err))
-	}
-
-	return c.String(http.StatusOK, result)
+	return cacheJSON(c, cache, cacheKey, gen, Rating{Nickname: user.Nickname, Rating: user.Rating})
 }
 
-func ChangeRating(c echo.Context, client *mongo.Client, ratingFlag bool) error {
+func ChangeRating(c echo.Context, repo users.UserRepository, votes users.VoteRepository, leaderboard *cash.Leaderboard, cache *cash.Cache, ratingFlag bool) error {
+	ctx := c.Request().Context()
 	paramNickname := c.Param(nickname)
-	tokenString := c.Request().Header["Authorization"][0]
-	var rating int
-	ratingDeleteFlag := false
-	ratingDeleteIndex := 0
+	rating := -1
 	if ratingFlag {
 		rating = 1
-	} else {
-		rating = -1
 	}
-	userNickname, err := token.GetUserNicknameFromToken(tokenString)
-	if err != nil {
-		fmt.Println(err)
+	claims := token.ClaimsFromContext(c)
+	if claims == nil {
+		return fail(c, http.StatusUnauthorized, CodeUnauthorized, "missing token")
 	}
-	senderUser, err := getUserByNickname(userNickname, client)
-	if err != nil {
-		return fmt.Errorf("can't find sender %s", err)
+	senderUser, err := repo.FindByNickname(ctx, claims.UserNickname)
+	if errors.Is(err, users.ErrUserNotFound) {
+		return fail(c, http.StatusUnauthorized, CodeUnauthorized, "voter not found")
 	}
-	if !senderUser.VotedAt.IsZero() && time.Now().Sub(senderUser.VotedAt) > 1 {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("you can voted once per 1 hour"))
+	if err != nil {
+		return internalError(c, "load voter", err)
 	}
-	senderUser.VotedAt = time.Now()
-	user, err := getUserByNickname(paramNickname, client)
+	user, err := repo.FindByNickname(ctx, paramNickname)
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("incorect user nickname: %s", err))
+		return userLookupError(c, err)
 	}
 	if user.Nickname == senderUser.Nickname {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("user can't vote by himselves"))
-	}
-	var ratingList []users.UserRatingList
-	if len(user.UserRatingList) > 0 {
-		err = json.Unmarshal([]byte(user.UserRatingList), &ratingList)
-		if err != nil {
-			return c.String(http.StatusBadRequest, fmt.Sprintf("cant find user vote list: %s", err))
-		}
-		for index, voteItem := range ratingList {
-			if voteItem.VotedNickname == senderUser.Nickname {
-				if rating == voteItem.VotedRating {
-					return c.String(http.StatusBadRequest, fmt.Sprintf("User can't vote twice for one person"))
-				} else {
-					ratingDeleteFlag = true
-					ratingDeleteIndex = index
-				}
-			}
-		}
+		return fail(c, http.StatusBadRequest, CodeBadRequest, "user can't vote for themselves")
 	}
 
-	user.Rating += rating
-	if ratingDeleteFlag {
-		ratingList = append(ratingList[:ratingDeleteIndex], ratingList[ratingDeleteIndex+1:]...)
-	} else {
-		ratingList = append(ratingList, users.UserRatingList{VotedRating: rating, VotedDate: time.Now(), VotedNickname: senderUser.Nickname})
+	newRating, err := votes.Vote(ctx, senderUser.Nickname, user.Nickname, rating, voteCooldown)
+	switch {
+	case errors.Is(err, users.ErrAlreadyVoted):
+		return fail(c, http.StatusConflict, CodeConflict, err.Error())
+	case errors.Is(err, users.ErrVoteCooldown):
+		return fail(c, http.StatusTooManyRequests, CodeTooManyRequests, "you can vote once per hour")
+	case errors.Is(err, users.ErrUserNotFound):
+		return userLookupError(c, err)
+	case err != nil:
+		return internalError(c, "vote", err)
+	}
+	updateLeaderboard(ctx, leaderboard, user.Nickname, newRating)
+	cache.RatingChanged(ctx, user.Nickname)
+
+	return c.JSON(http.StatusOK, Rating{Nickname: user.Nickname, Rating: newRating})
+}
+
+// Login checks nickname and password. Every failure gets the same answer,
+// whether the nickname exists or not, and counts towards the lockout of
+// both the nickname and the client address.
+func Login(c echo.Context, repo users.UserRepository, hasher *hash.PasswordHasher, tokens *token.Manager, store *token.Store, policy UnverifiedPolicy, twoFactor *TwoFactor, guard *token.LoginGuard) error {
+	currentNickname := validation.NormalizeName(c.FormValue(nickname))
+	currentPassword := c.FormValue(password)
+	if len(currentNickname) == 0 || len(currentPassword) == 0 {
+		return fail(c, http.StatusBadRequest, CodeBadRequest, "nickname or password not exist")
 	}
-	ratingListByte, err := json.Marshal(ratingList)
+	ctx := c.Request().Context()
+	wait, err := guard.Check(ctx, currentNickname, c.RealIP())
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("can't code user vote list: %s", err))
+		return internalError(c, "check login lock", err)
 	}
-	user.UserRatingList = string(ratingListByte)
-
-	err = updateRatedUser(user, client)
-	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("can't update user: %s", err))
+	if wait > 0 {
+		return lockedOut(c, wait)
 	}
 
-	err = updateRatedUser(senderUser, client)
+	currentUser, err := repo.FindByNickname(ctx, currentNickname)
+	if errors.Is(err, users.ErrUserNotFound) {
+		// Hash anyway so that unknown nicknames take as long as wrong
+		// passwords.
+		_, _ = hasher.Hash(currentPassword)
+		return loginFailed(c, guard, currentNickname, invalidLoginMessage)
+	}
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("can't update user: %s", err))
+		return internalError(c, "load user", err)
 	}
 
-	return c.String(http.StatusOK, fmt.Sprintf("user has %d", user.Rating))
-}
-
-func Login(c echo.Context, client *mongo.Client) error {
-	currentNickname := c.FormValue(nickname)
-	currentPassword := c.FormValue(password)
-	if len(currentNickname) == 0 || len(currentPassword) == 0 {
-		return c.String(http.StatusBadRequest, "email or password not exist")
+	ok, rehash, err := hasher.Verify(currentPassword, currentUser.Password)
+	if err != nil || !ok {
+		return loginFailed(c, guard, currentNickname, invalidLoginMessage)
 	}
-	currentUser, err := getUserByNickname(currentNickname, client)
-	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("user not found: %s", err))
+	if rehash {
+		upgradePassword(ctx, repo, hasher, currentUser, currentPassword)
 	}
-
-	if currentUser.Password != hash.HashString(currentPassword) {
-		return c.String(http.StatusBadRequest, "password incorect")
+	if policy == UnverifiedBlock && !currentUser.EmailVerified {
+		return respondError(c, errEmailNotVerified)
+	}
+	if currentUser.TOTPEnabled {
+		// The failure counter is only reset once the second factor passed.
+		challenge, err := twoFactor.Tokens.Issue(ctx, token.PurposeLoginChallenge, currentUser.ID.Hex(), twoFactor.ChallengeTTL)
+		if err != nil {
+			return internalError(c, "issue login challenge", err)
+		}
+		return c.JSON(http.StatusOK, Challenge{ChallengeToken: challenge, ExpiresIn: int64(twoFactor.ChallengeTTL.Seconds())})
 	}
+	loginSucceeded(ctx, guard, currentUser.Nickname)
 
-	token, err := token.GenerateToken(currentUser)
+	pair, err := issueTokens(c, currentUser, tokens, store)
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("token: %s", err))
+		return internalError(c, "issue token", err)
 	}
 
-	return c.String(http.StatusOK, token)
+	return c.JSON(http.StatusOK, pair)
 }
 
-func Delete(c echo.Context, client *mongo.Client) error {
-	user, err := getUserByNickname(c.Param("nickname"), client)
-	if err != nil {
-		return c.String(http.StatusOK, "user not found")
+// loginFailed counts a failed password or second factor for nick and the
+// client address. Wrong codes count like wrong passwords, so they cannot be
+// guessed by repeating logins with a stolen password.
+func loginFailed(c echo.Context, guard *token.LoginGuard, nick, message string) error {
+	if _, err := guard.Fail(c.Request().Context(), nick, c.RealIP()); err != nil {
+		log.Errorf("record failed login for %s: %s", nick, err)
+	}
+	return fail(c, http.StatusUnauthorized, CodeInvalidLogin, message)
+}
+
+func loginSucceeded(ctx context.Context, guard *token.LoginGuard, nick string) {
+	if err := guard.Succeed(ctx, nick); err != nil {
+		log.Errorf("reset failed logins for %s: %s", nick, err)
 	}
+}
 
-	filter := bson.M{nickname: user.Nickname}
+func lockedOut(c echo.Context, wait time.Duration) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())))
+	return fail(c, http.StatusTooManyRequests, CodeTooManyRequests, "too many failed login attempts, try again later")
+}
 
-	update := bson.M{
-		"$set": bson.M{
-			"deleted_ad": time.Now(),
-		},
+func RefreshToken(c echo.Context, repo users.UserRepository, tokens *token.Manager, store *token.Store) error {
+	refreshToken := c.FormValue(refreshTokenParam)
+	if refreshToken == "" {
+		return fail(c, http.StatusBadRequest, CodeBadRequest, "refresh token not exist")
 	}
-	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("error conncection wit mongodb: %s", err))
+	ctx := c.Request().Context()
+	session, next, err := store.Rotate(ctx, refreshToken)
+	if errors.Is(err, token.ErrInvalidRefreshToken) || errors.Is(err, token.ErrRefreshTokenReused) {
+		return fail(c, http.StatusUnauthorized, CodeUnauthorized, err.Error())
 	}
-	collection := client.Database(databaseName).Collection(tableName)
-	_, err = collection.UpdateOne(context.TODO(), filter, update)
 	if err != nil {
-		return c.String(http.StatusBadRequest, fmt.Sprintf("error delete user: %s", err))
+		return internalError(c, "rotate refresh token", err)
 	}
-	return c.String(http.StatusOK, "user deleted")
-}
-
-func addUser(user users.User) error {
-	err := godotenv.Load(".env")
+	if session.ClientID != "" {
+		// Refresh tokens of OAuth clients only work at the token endpoint,
+		// for the client they were issued to.
+		_ = store.Revoke(ctx, next)
+		return fail(c, http.StatusUnauthorized, CodeUnauthorized, token.ErrInvalidRefreshToken.Error())
+	}
+	id, err := primitive.ObjectIDFromHex(session.UserID)
 	if err != nil {
-		return errors.Unwrap(err)
+		return fail(c, http.StatusUnauthorized, CodeUnauthorized, token.ErrInvalidRefreshToken.Error())
 	}
-	var mongoUri = fmt.Sprintf("mongodb+srv://%s:%s@%s", os.Getenv("db_account"), os.Getenv("db_password"), os.Getenv("db_url"))
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(mongoUri))
+	user, err := repo.FindByID(ctx, id)
 	if err != nil {
-		return fmt.Errorf("mongo connect error: %s", err)
+		_ = store.Revoke(ctx, next)
+		return fail(c, http.StatusUnauthorized, CodeUnauthorized, "user not found")
 	}
-	collection := client.Database(databaseName).Collection(tableName)
-	_, err = collection.InsertOne(context.TODO(), user)
+	accessToken, err := tokens.GenerateToken(user, session.ID)
 	if err != nil {
-		return fmt.Errorf("insert error: %s", err)
+		return internalError(c, "issue token", err)
 	}
-	return nil
+	return c.JSON(http.StatusOK, token.Pair{
+		AccessToken:  accessToken,
+		RefreshToken: next,
+		ExpiresIn:    int64(tokens.AccessTTL().Seconds()),
+	})
+}
+
+// Logout revokes the refresh family of the given refresh token and puts the
+// access token from the Authorization header, if any, on the denylist and
+// ends its session.
+func Logout(c echo.Context, tokens *token.Manager, store *token.Store) error {
+	ctx := c.Request().Context()
+	if refreshToken := c.FormValue(refreshTokenParam); refreshToken != "" {
+		if err := store.Revoke(ctx, refreshToken); err != nil {
+			return internalError(c, "revoke refresh token", err)
+		}
+	}
+	if accessToken, _ := token.Credentials(c.Request()); accessToken != "" {
+		claims, err := tokens.ParseToken(accessToken)
+		if err == nil {
+			err = store.Deny(ctx, claims.ID, claims.ExpiresAt.Time)
+			if err != nil {
+				return internalError(c, "revoke access token", err)
+			}
+			if claims.SessionID != "" {
+				err = store.RevokeSession(ctx, claims.UserID(), claims.SessionID)
+				if err != nil && !errors.Is(err, token.ErrSessionNotFound) {
+					return internalError(c, "revoke session", err)
+				}
+			}
+		}
+	}
+	return c.JSON(http.StatusOK, Message{Message: "logged out"})
 }
 
-func getUserByEmail(s string, client *mongo.Client) (*users.User, error) {
-	collection := client.Database(databaseName).Collection(tableName)
-	filter := bson.M{
-		"$or": []bson.M{
-			{email: s},
-		},
+// Delete soft-deletes an account and signs it out everywhere. Admins can
+// restore it until the purge removes it for good.
+func Delete(c echo.Context, repo users.UserRepository, leaderboard *cash.Leaderboard, cache *cash.Cache, store *token.Store) error {
+	if apiErr := authorizeTarget(c, c.Param(nickname), users.PermProfileDeleteSelf, users.PermUserDelete); apiErr != nil {
+		return respondError(c, apiErr)
 	}
-	var userResult users.User
-	err := collection.FindOne(context.TODO(), filter).Decode(&userResult)
+	ctx := c.Request().Context()
+	user, err := repo.FindByNickname(ctx, c.Param(nickname))
 	if err != nil {
-		return nil, fmt.Errorf("find one error: %s", err)
+		return userLookupError(c, err)
+	}
+	if err := repo.SoftDelete(ctx, user.Nickname); err != nil {
+		return userLookupError(c, err)
+	}
+	if err := store.RevokeUser(ctx, user.ID.Hex()); err != nil {
+		log.Errorf("revoke sessions of deleted user %s: %s", user.Nickname, err)
 	}
-	return &userResult, nil
+	if err := leaderboard.Remove(c.Request().Context(), c.Param(nickname)); err != nil {
+		log.Errorf("remove %s from leaderboard: %s", c.Param(nickname), err)
+	}
+	cache.UserRemoved(c.Request().Context(), c.Param(nickname))
+	return c.JSON(http.StatusOK, Message{Message: "user deleted"})
 }
 
-func getUserByNickname(s string, client *mongo.Client) (*users.User, error) {
-	collection := client.Database(databaseName).Collection(tableName)
-	filter := bson.M{
-		"$or": []bson.M{
-			{nickname: s},
-		},
-	}
-	var userResult users.User
-	err := collection.FindOne(context.TODO(), filter).Decode(&userResult)
+// cacheJSON encodes v, stores it under key and writes it as the response.
+func cacheJSON(c echo.Context, cache *cash.Cache, key string, gen cash.Generation, v interface{}) error {
+	data, err := json.Marshal(v)
 	if err != nil {
-		return nil, fmt.Errorf("find one error: %s", err)
+		return internalError(c, "encode response", err)
 	}
-	return &userResult, nil
+	cache.Set(c.Request().Context(), key, gen, data)
+	return c.JSONBlob(http.StatusOK, data)
 }
 
-func updateRatedUser(user *users.User, client *mongo.Client) error {
-	filter := bson.M{nickname: user.Nickname}
+// upgradePassword replaces a legacy or outdated hash after a successful
+// login. Failures are only logged: the user is already authenticated.
+func upgradePassword(ctx context.Context, repo users.UserRepository, hasher *hash.PasswordHasher, user *users.User, plain string) {
+	encoded, err := hasher.Hash(plain)
+	if err != nil {
+		log.Errorf("rehash password for %s: %s", user.Nickname, err)
+		return
+	}
+	user.Password = encoded
+	if err := repo.Update(ctx, user); err != nil {
+		log.Errorf("store rehashed password for %s: %s", user.Nickname, err)
+	}
+}
 
-	update := bson.M{
-		"$set": bson.M{
-			"VotedAt":        user.VotedAt,
-			"Rating":         user.Rating,
-			"UserRatingList": user.UserRatingList,
-		},
+// authorizeTarget lets the caller act on their own account when their role
+// grants selfPerm, and on any other account when it grants anyPerm.
+func authorizeTarget(c echo.Context, target string, selfPerm, anyPerm users.Permission) *APIError {
+	claims := token.ClaimsFromContext(c)
+	if claims == nil {
+		return newError(http.StatusUnauthorized, CodeUnauthorized, "missing token")
 	}
-	collection := client.Database(databaseName).Collection(tableName)
-	_, err := collection.UpdateOne(context.TODO(), filter, update)
+	perm := anyPerm
+	if claims.UserNickname == target {
+		perm = selfPerm
+	}
+	if !claims.Can(perm) {
+		return newError(http.StatusForbidden, CodeForbidden, fmt.Sprintf("permission %s required", perm))
+	}
+	return nil
+}
 
+func issueTokens(c echo.Context, user *users.User, tokens *token.Manager, store *token.Store) (*token.Pair, error) {
+	session, refreshToken, err := store.Issue(c.Request().Context(), user.ID.Hex(), requestDevice(c))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	accessToken, err := tokens.GenerateToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &token.Pair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(tokens.AccessTTL().Seconds()),
+	}, nil
+}
+
+// requestDevice describes the client of the request for its session.
+func requestDevice(c echo.Context) token.Device {
+	return token.Device{UserAgent: c.Request().UserAgent(), IP: c.RealIP()}
 }
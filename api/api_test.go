@@ -0,0 +1,534 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/cash"
+	"awesomeProject/internal/hash"
+	"awesomeProject/internal/token"
+	"awesomeProject/internal/validation"
+	"awesomeProject/users"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+var testTokens = token.NewManager(token.HMACKeys([]byte("test-secret-that-is-long-enough!!")), "http://localhost", "users-api", 15*time.Minute)
+
+var testRules = validation.DefaultPolicy()
+
+// testHasher keeps argon2id cheap so the handler tests stay fast.
+var testHasher = hash.NewPasswordHasher(&hash.Argon2idHasher{
+	Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+}, hash.LegacyMD5{})
+
+// authenticate stores the claims JwtMiddleware would derive from u's token.
+func authenticate(c echo.Context, u *users.User) {
+	claims := &token.Claims{UserNickname: u.Nickname, UserRole: u.Role}
+	claims.Subject = u.ID.Hex()
+	token.SetClaims(c, claims)
+}
+
+func newFormContext(method, target string, form url.Values) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	return echo.New().NewContext(req, rec), rec
+}
+
+func seedUser(t *testing.T, repo users.UserRepository, nick, pass string) *users.User {
+	t.Helper()
+	u := &users.User{
+		FirstName: "Test",
+		LastName:  "User",
+		Nickname:  nick,
+		Email:     nick + "@example.com",
+		Password:  hash.HashString(pass),
+	}
+	if err := repo.Insert(context.Background(), u); err != nil {
+		t.Fatalf("seed %s: %v", nick, err)
+	}
+	return u
+}
+
+func TestUserRegister(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	seedUser(t, repo, "taken", "Qwerty1123@#")
+
+	tests := map[string]int{
+		"newuser": http.StatusCreated,
+		"taken":   http.StatusConflict,
+		"x":       http.StatusBadRequest,
+	}
+	for nick, expected := range tests {
+		c, rec := newFormContext(http.MethodPost, "/register/", url.Values{
+			firstname: {"Oleksii"},
+			lastname:  {"Pazyuk"},
+			nickname:  {nick},
+			email:     {nick + "@example.org"},
+			password:  {"Qwerty1123@#"},
+		})
+		if err := UserRegister(c, repo, testHasher, newTestLeaderboard(t), newTestCache(t), newTestVerification(t), testRules); err != nil {
+			t.Fatalf("UserRegister(%s) error = %v", nick, err)
+		}
+		if rec.Code != expected {
+			t.Errorf("UserRegister(%s) = %d, expected %d: %s", nick, rec.Code, expected, rec.Body)
+		}
+	}
+	if _, err := repo.FindByNickname(context.Background(), "newuser"); err != nil {
+		t.Errorf("registered user not stored: %v", err)
+	}
+}
+
+func TestUserRegisterNormalizesNames(t *testing.T) {
+	repo := users.NewMemoryRepository()
+
+	tests := []struct {
+		nick, email string
+		expected    int
+	}{
+		{" Олексій ", "oleksii@example.org", http.StatusCreated},
+		{"Admin", "admin@example.org", http.StatusBadRequest},
+		{"pаypal", "paypal@example.org", http.StatusBadRequest}, // Cyrillic а
+	}
+	for _, tt := range tests {
+		c, rec := newFormContext(http.MethodPost, "/register/", url.Values{
+			firstname: {"Jose\u0301"},
+			lastname:  {"O'Brien-Smith"},
+			nickname:  {tt.nick},
+			email:     {tt.email},
+			password:  {"Qwerty1123@#"},
+		})
+		if err := UserRegister(c, repo, testHasher, newTestLeaderboard(t), newTestCache(t), newTestVerification(t), testRules); err != nil {
+			t.Fatalf("UserRegister(%s) error = %v", tt.nick, err)
+		}
+		if rec.Code != tt.expected {
+			t.Errorf("UserRegister(%s) = %d, expected %d: %s", tt.nick, rec.Code, tt.expected, rec.Body)
+		}
+	}
+
+	user, err := repo.FindByNickname(context.Background(), "Олексій")
+	if err != nil {
+		t.Fatalf("FindByNickname(Олексій) = %v", err)
+	}
+	if user.FirstName != "José" {
+		t.Errorf("stored first name = %q, expected the NFC form %q", user.FirstName, "José")
+	}
+}
+
+func TestUserRegisterLookalikeNicknames(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	seedUser(t, repo, "coca", "Qwerty1123@#")
+
+	tests := []struct {
+		nick     string
+		expected int
+	}{
+		{"Coca", http.StatusConflict},
+		{"COCA", http.StatusConflict},
+		{"соса", http.StatusConflict}, // Cyrillic
+		{"cocoa", http.StatusCreated},
+	}
+	for i, tt := range tests {
+		c, rec := newFormContext(http.MethodPost, "/register/", url.Values{
+			firstname: {"Oleksii"},
+			lastname:  {"Pazyuk"},
+			nickname:  {tt.nick},
+			email:     {fmt.Sprintf("user%d@example.org", i)},
+			password:  {"Qwerty1123@#"},
+		})
+		if err := UserRegister(c, repo, testHasher, newTestLeaderboard(t), newTestCache(t), newTestVerification(t), testRules); err != nil {
+			t.Fatalf("UserRegister(%s) error = %v", tt.nick, err)
+		}
+		if rec.Code != tt.expected {
+			t.Errorf("UserRegister(%s) = %d, expected %d: %s", tt.nick, rec.Code, tt.expected, rec.Body)
+		}
+	}
+}
+
+func TestUserRegisterConcurrentDuplicates(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	leaderboard, cache, verification := newTestLeaderboard(t), newTestCache(t), newTestVerification(t)
+
+	const attempts = 8
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, rec := newFormContext(http.MethodPost, "/register/", url.Values{
+				firstname: {"Oleksii"},
+				lastname:  {"Pazyuk"},
+				nickname:  {"racer"},
+				email:     {"racer@example.org"},
+				password:  {"Qwerty1123@#"},
+			})
+			if err := UserRegister(c, repo, testHasher, leaderboard, cache, verification, testRules); err != nil {
+				t.Errorf("UserRegister() error = %v", err)
+			}
+			codes <- rec.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	count := map[int]int{}
+	for code := range codes {
+		count[code]++
+	}
+	if count[http.StatusCreated] != 1 || count[http.StatusConflict] != attempts-1 {
+		t.Errorf("concurrent registrations = %v, expected one 201 and %d 409", count, attempts-1)
+	}
+}
+
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+func newTestStore(t *testing.T) *token.Store {
+	return token.NewStore(newTestRedis(t), time.Hour)
+}
+
+func newTestLeaderboard(t *testing.T) *cash.Leaderboard {
+	return cash.NewLeaderboard(newTestRedis(t))
+}
+
+func newTestCache(t *testing.T) *cash.Cache {
+	return cash.NewCache(newTestRedis(t), cash.DefaultTTL)
+}
+
+func TestLogin(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	store := newTestStore(t)
+	seedUser(t, repo, "oleksii", "Qwerty1123@#")
+
+	tests := map[string]int{
+		"Qwerty1123@#": http.StatusOK,
+		"wrong":        http.StatusUnauthorized,
+	}
+	for pass, expected := range tests {
+		c, rec := newFormContext(http.MethodPost, "/log-in/", url.Values{
+			nickname: {"oleksii"},
+			password: {pass},
+		})
+		if err := Login(c, repo, testHasher, testTokens, store, UnverifiedAllow, newTestTwoFactor(t), newTestGuard(t)); err != nil {
+			t.Fatalf("Login(%s) error = %v", pass, err)
+		}
+		if rec.Code != expected {
+			t.Errorf("Login(%s) = %d, expected %d", pass, rec.Code, expected)
+		}
+	}
+
+	user, _ := repo.FindByNickname(context.Background(), "oleksii")
+	if user.Password == hash.HashString("Qwerty1123@#") {
+		t.Fatalf("legacy MD5 hash was not upgraded on login")
+	}
+	if ok, rehash, err := testHasher.Verify("Qwerty1123@#", user.Password); !ok || rehash || err != nil {
+		t.Errorf("Verify(upgraded) = %v, %v, %v", ok, rehash, err)
+	}
+
+	c, rec := newFormContext(http.MethodPost, "/log-in/", url.Values{
+		nickname: {"oleksii"},
+		password: {"Qwerty1123@#"},
+	})
+	if err := Login(c, repo, testHasher, testTokens, store, UnverifiedAllow, newTestTwoFactor(t), newTestGuard(t)); err != nil || rec.Code != http.StatusOK {
+		t.Errorf("Login after upgrade = %d, %v", rec.Code, err)
+	}
+}
+
+func newTestGuard(t *testing.T) *token.LoginGuard {
+	return token.NewLoginGuard(newTestRedis(t), token.GuardPolicy{
+		AccountThreshold: 5,
+		IPThreshold:      20,
+		Lockout:          time.Minute,
+		MaxLockout:       time.Hour,
+		Window:           time.Hour,
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	store, guard := newTestStore(t), newTestGuard(t)
+	admin := seedUser(t, repo, "admin", "Qwerty1123@#")
+	admin.Role = users.RoleAdmin
+	seedUser(t, repo, "oleksii", "Qwerty1123@#")
+
+	login := func(nick, pass string) (int, string) {
+		c, rec := newFormContext(http.MethodPost, "/log-in/", url.Values{nickname: {nick}, password: {pass}})
+		if err := Login(c, repo, testHasher, testTokens, store, UnverifiedAllow, newTestTwoFactor(t), guard); err != nil {
+			t.Fatalf("Login(%s) error = %v", nick, err)
+		}
+		return rec.Code, rec.Body.String()
+	}
+
+	_, unknown := login("nobody", "wrong")
+	_, wrong := login("oleksii", "wrong")
+	if unknown != wrong {
+		t.Errorf("unknown nickname and wrong password differ:\n%s\n%s", unknown, wrong)
+	}
+	for i := 0; i < 4; i++ {
+		login("oleksii", "wrong")
+	}
+	if code, _ := login("oleksii", "Qwerty1123@#"); code != http.StatusTooManyRequests {
+		t.Errorf("Login() with the right password while locked = %d, expected %d", code, http.StatusTooManyRequests)
+	}
+	for i := 0; i < 4; i++ {
+		login("nobody", "wrong")
+	}
+	if code, _ := login("nobody", "wrong"); code != http.StatusTooManyRequests {
+		t.Errorf("Login(unknown nickname) after the threshold = %d, expected %d", code, http.StatusTooManyRequests)
+	}
+
+	c, rec := newFormContext(http.MethodPost, "/admin/users/oleksii/unlock/", url.Values{})
+	authenticate(c, admin)
+	c.SetParamNames(nickname)
+	c.SetParamValues("oleksii")
+	if err := UnlockUser(c, repo, guard); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("UnlockUser() = %d, %v", rec.Code, err)
+	}
+	if code, _ := login("oleksii", "Qwerty1123@#"); code != http.StatusOK {
+		t.Errorf("Login() after unlock = %d, expected %d", code, http.StatusOK)
+	}
+}
+
+func TestChangeRating(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	votes := users.NewMemoryVoteRepository(repo)
+	leaderboard := newTestLeaderboard(t)
+	voter := seedUser(t, repo, "voter", "Qwerty1123@#")
+	self := seedUser(t, repo, "target", "Qwerty1123@#")
+
+	c, rec := newFormContext(http.MethodPost, "/profile/add-rating/target/", url.Values{})
+	authenticate(c, voter)
+	c.SetParamNames(nickname)
+	c.SetParamValues("target")
+	if err := ChangeRating(c, repo, votes, leaderboard, newTestCache(t), true); err != nil {
+		t.Fatalf("ChangeRating() error = %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ChangeRating() = %d, expected %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `"rating":1`) {
+		t.Errorf("ChangeRating() body = %s", rec.Body)
+	}
+	if entry, err := leaderboard.Rank(context.Background(), "target"); err != nil || entry.Rating != 1 || entry.Rank != 1 {
+		t.Errorf("leaderboard entry = %+v, %v", entry, err)
+	}
+
+	target, _ := repo.FindByNickname(context.Background(), "target")
+	if target.Rating != 1 {
+		t.Errorf("target rating = %d, expected 1", target.Rating)
+	}
+	sender, _ := repo.FindByNickname(context.Background(), "voter")
+	if sender.VotedAt.IsZero() {
+		t.Errorf("voter VotedAt was not updated")
+	}
+
+	c, rec = newFormContext(http.MethodPost, "/profile/add-rating/target/", url.Values{})
+	authenticate(c, self)
+	c.SetParamNames(nickname)
+	c.SetParamValues("target")
+	if err := ChangeRating(c, repo, votes, leaderboard, newTestCache(t), true); err != nil {
+		t.Fatalf("ChangeRating() error = %v", err)
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("self vote = %d, expected %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	store := newTestStore(t)
+	seedUser(t, repo, "oleksii", "Qwerty1123@#")
+
+	c, rec := newFormContext(http.MethodPost, "/log-in/", url.Values{
+		nickname: {"oleksii"},
+		password: {"Qwerty1123@#"},
+	})
+	if err := Login(c, repo, testHasher, testTokens, store, UnverifiedAllow, newTestTwoFactor(t), newTestGuard(t)); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Login() = %d, %v", rec.Code, err)
+	}
+	var pair token.Pair
+	if err := json.Unmarshal(rec.Body.Bytes(), &pair); err != nil || pair.RefreshToken == "" {
+		t.Fatalf("Login() body = %s, %v", rec.Body, err)
+	}
+
+	c, rec = newFormContext(http.MethodPost, "/token/refresh/", url.Values{refreshTokenParam: {pair.RefreshToken}})
+	if err := RefreshToken(c, repo, testTokens, store); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("RefreshToken() = %d, %v: %s", rec.Code, err, rec.Body)
+	}
+	var refreshed token.Pair
+	_ = json.Unmarshal(rec.Body.Bytes(), &refreshed)
+
+	c, rec = newFormContext(http.MethodPost, "/log-out/", url.Values{refreshTokenParam: {refreshed.RefreshToken}})
+	c.Request().Header.Set("Authorization", refreshed.AccessToken)
+	if err := Logout(c, testTokens, store); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Logout() = %d, %v", rec.Code, err)
+	}
+
+	c, rec = newFormContext(http.MethodPost, "/token/refresh/", url.Values{refreshTokenParam: {refreshed.RefreshToken}})
+	if err := RefreshToken(c, repo, testTokens, store); err != nil || rec.Code != http.StatusUnauthorized {
+		t.Errorf("RefreshToken() after logout = %d, %v", rec.Code, err)
+	}
+	claims, _ := testTokens.ParseToken(refreshed.AccessToken)
+	if denied, _ := store.IsDenied(context.Background(), claims.ID); !denied {
+		t.Errorf("access token was not denied on logout")
+	}
+}
+
+func TestUserEditOwnership(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	owner := seedUser(t, repo, "owner", "Qwerty1123@#")
+	other := seedUser(t, repo, "other", "Qwerty1123@#")
+	admin := seedUser(t, repo, "boss", "Qwerty1123@#")
+	admin.Role = users.RoleAdmin
+	if err := repo.Update(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		role, target, newPassword string
+		expected                  int
+	}{
+		{users.RoleUser, "owner", "", http.StatusOK},
+		{users.RoleUser, "owner", "Fresh-Passw0rd!x", http.StatusOK},
+		{users.RoleUser, "other", "", http.StatusForbidden},
+		{users.RoleModerator, "other", "", http.StatusOK},
+		{users.RoleModerator, "other", "Fresh-Passw0rd!x", http.StatusForbidden},
+		{users.RoleModerator, "boss", "", http.StatusForbidden},
+		{users.RoleAdmin, "other", "Fresh-Passw0rd!x", http.StatusForbidden},
+		{users.RoleAdmin, "boss", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		c, rec := newFormContext(http.MethodPost, "/profile/edit/", url.Values{
+			nickname:    {tt.target},
+			information: {"edited by " + tt.role},
+			password:    {tt.newPassword},
+		})
+		token.SetClaims(c, &token.Claims{UserNickname: owner.Nickname, UserRole: tt.role})
+		if err := UserEdit(c, repo, testHasher, newTestCache(t), testRules); err != nil {
+			t.Fatalf("UserEdit(%s, %s, %q) error = %v", tt.role, tt.target, tt.newPassword, err)
+		}
+		if rec.Code != tt.expected {
+			t.Errorf("UserEdit(%s, %s, %q) = %d, expected %d", tt.role, tt.target, tt.newPassword, rec.Code, tt.expected)
+		}
+	}
+
+	stored, _ := repo.FindByNickname(context.Background(), other.Nickname)
+	if stored.Password != other.Password {
+		t.Errorf("password of %s changed by another account", other.Nickname)
+	}
+}
+
+func TestUserRegisterIgnoresRole(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	c, rec := newFormContext(http.MethodPost, "/register/", url.Values{
+		firstname: {"Oleksii"},
+		lastname:  {"Pazyuk"},
+		nickname:  {"sneaky"},
+		email:     {"sneaky@example.org"},
+		password:  {"Qwerty1123@#"},
+		"role":    {users.RoleAdmin},
+	})
+	if err := UserRegister(c, repo, testHasher, newTestLeaderboard(t), newTestCache(t), newTestVerification(t), testRules); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("UserRegister() = %d, %v", rec.Code, err)
+	}
+	user, _ := repo.FindByNickname(context.Background(), "sneaky")
+	if user.Role != users.RoleUser {
+		t.Errorf("registered role = %q, expected %q", user.Role, users.RoleUser)
+	}
+}
+
+func TestUserRegisterPasswordBytes(t *testing.T) {
+	rules := validation.DefaultPolicy()
+	rules.Password.MaxBytes = hash.BcryptMaxBytes
+	// 40 characters are within MaxLength, but Cyrillic letters take two
+	// bytes each.
+	c, rec := newFormContext(http.MethodPost, "/register/", url.Values{
+		firstname: {"Oleksii"},
+		lastname:  {"Pazyuk"},
+		nickname:  {"oleksii"},
+		email:     {"oleksii@example.org"},
+		password:  {strings.Repeat("пароль-", 5) + "ключ!"},
+	})
+	if err := UserRegister(c, users.NewMemoryRepository(), testHasher, newTestLeaderboard(t), newTestCache(t), newTestVerification(t), rules); err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body %s: %v", rec.Body, err)
+	}
+	if rec.Code != http.StatusBadRequest || len(body.Error.Details) != 1 ||
+		body.Error.Details[0].Field != password || body.Error.Details[0].Rule != "password_bytes" {
+		t.Errorf("UserRegister() = %d %s, expected password_bytes on field %s", rec.Code, rec.Body, password)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	c, rec := newFormContext(http.MethodPost, "/register/", url.Values{
+		firstname: {"Oleksii"},
+		lastname:  {"Pazyuk"},
+		nickname:  {"x"},
+		email:     {"x@example.org"},
+		password:  {"Qwerty1123@#"},
+	})
+	if err := UserRegister(c, repo, testHasher, newTestLeaderboard(t), newTestCache(t), newTestVerification(t), testRules); err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body %s: %v", rec.Body, err)
+	}
+	if body.Error.Code != CodeValidationFailed || len(body.Error.Details) != 1 || body.Error.Details[0].Field != nickname {
+		t.Errorf("error body = %+v, expected %s on field %s", body.Error, CodeValidationFailed, nickname)
+	}
+
+	c, rec = newFormContext(http.MethodPost, "/register/", url.Values{
+		firstname: {"O"},
+		nickname:  {"x"},
+		email:     {"not-an-email"},
+		password:  {"Qwerty1123@#"},
+	})
+	if err := UserRegister(c, repo, testHasher, newTestLeaderboard(t), newTestCache(t), newTestVerification(t), testRules); err != nil {
+		t.Fatal(err)
+	}
+	body.Error = APIError{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	fields := make(map[string]string)
+	for _, d := range body.Error.Details {
+		fields[d.Field] = d.Rule
+	}
+	expected := map[string]string{firstname: "name_length", lastname: "required", nickname: "nickname_length", email: "email"}
+	if len(fields) != len(expected) {
+		t.Errorf("register details = %+v, expected one per field in %v", body.Error.Details, expected)
+	}
+	for field, rule := range expected {
+		if fields[field] != rule {
+			t.Errorf("register detail for %s = %q, expected rule %q", field, fields[field], rule)
+		}
+	}
+
+	c, rec = newFormContext(http.MethodGet, "/", nil)
+	ErrorHandler(echo.ErrForbidden, c)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), `"code":"forbidden"`) {
+		t.Errorf("ErrorHandler(ErrForbidden) = %d %s", rec.Code, rec.Body)
+	}
+}
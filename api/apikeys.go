@@ -0,0 +1,217 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"awesomeProject/internal/token"
+	"awesomeProject/internal/validation"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	apiKeyParam = "key"
+
+	// maxAPIKeys bounds the keys of one account.
+	maxAPIKeys = 20
+	// apiKeyPrefixLength is how much of a secret is kept to identify it.
+	apiKeyPrefixLength = len(token.APIKeyPrefix) + 6
+)
+
+var apiKeyRules = struct {
+	Name, Expiry validation.Rule
+}{
+	Name: validation.Rule{ID: "api_key_name", Message: "must be at most 64 characters", Check: func(s string) bool {
+		return utf8.RuneCountInString(s) <= 64
+	}},
+	Expiry: validation.Rule{ID: "api_key_expiry", Message: "must be a number of days between 1 and 3650", Check: func(s string) bool {
+		days, err := strconv.Atoi(s)
+		return err == nil && days >= 1 && days <= 3650
+	}},
+}
+
+// APIKeyInfo describes an API key without its secret.
+type APIKeyInfo struct {
+	ID         string             `json:"id"`
+	Name       string             `json:"name"`
+	Prefix     string             `json:"prefix"`
+	Scopes     []users.Permission `json:"scopes"`
+	CreatedAt  time.Time          `json:"created_at"`
+	ExpiresAt  *time.Time         `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty"`
+}
+
+// CreatedAPIKey is the answer to CreateAPIKey, the only one carrying the
+// secret.
+type CreatedAPIKey struct {
+	APIKeyInfo
+	Key string `json:"key"`
+}
+
+type APIKeys struct {
+	Keys []APIKeyInfo `json:"keys"`
+}
+
+func newAPIKeyInfo(k *users.APIKey) APIKeyInfo {
+	info := APIKeyInfo{ID: k.ID.Hex(), Name: k.Name, Prefix: k.Prefix, Scopes: k.Scopes, CreatedAt: k.CreatedAt}
+	if !k.ExpiresAt.IsZero() {
+		info.ExpiresAt = &k.ExpiresAt
+	}
+	if !k.LastUsedAt.IsZero() {
+		info.LastUsedAt = &k.LastUsedAt
+	}
+	return info
+}
+
+// CreateAPIKey makes an API key for the caller. The form has a name, the
+// scopes (permissions of the caller's role, separated by spaces or commas)
+// and optionally expires_in_days. The secret is in the answer and nowhere
+// else.
+func CreateAPIKey(c echo.Context, keys users.APIKeyRepository) error {
+	userID, apiErr := callerID(c)
+	if apiErr != nil {
+		return respondError(c, apiErr)
+	}
+	claims := token.ClaimsFromContext(c)
+	name := strings.TrimSpace(c.FormValue("name"))
+	rawScopes := c.FormValue("scopes")
+	expiresIn := c.FormValue("expires_in_days")
+
+	var v validation.Validator
+	v.Required("name", name, apiKeyRules.Name)
+	v.Required("scopes", rawScopes)
+	v.Optional("expires_in_days", expiresIn, apiKeyRules.Expiry)
+	scopes := parseScopes(rawScopes)
+	for _, scope := range scopes {
+		if !claims.Can(scope) {
+			v.Add("scopes", "api_key_scope", fmt.Sprintf("%s is not a permission of your role", scope))
+		}
+	}
+	if apiErr := invalidRequest(&v); apiErr != nil {
+		return respondError(c, apiErr)
+	}
+
+	ctx := c.Request().Context()
+	existing, err := keys.ListByUser(ctx, userID)
+	if err != nil {
+		return internalError(c, "list api keys", err)
+	}
+	if len(existing) >= maxAPIKeys {
+		return fail(c, http.StatusConflict, CodeConflict, fmt.Sprintf("at most %d api keys are allowed, revoke one first", maxAPIKeys))
+	}
+
+	secret, digest, err := token.NewAPIKey()
+	if err != nil {
+		return internalError(c, "generate api key", err)
+	}
+	now := time.Now().UTC()
+	key := &users.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    secret[:apiKeyPrefixLength],
+		Digest:    digest,
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if expiresIn != "" {
+		days, _ := strconv.Atoi(expiresIn)
+		key.ExpiresAt = now.AddDate(0, 0, days)
+	}
+	if err := keys.Insert(ctx, key); err != nil {
+		return internalError(c, "store api key", err)
+	}
+	return c.JSON(http.StatusCreated, CreatedAPIKey{APIKeyInfo: newAPIKeyInfo(key), Key: secret})
+}
+
+// parseScopes splits scopes at spaces and commas and drops duplicates.
+func parseScopes(s string) []users.Permission {
+	fields := splitList(s)
+	scopes := make([]users.Permission, len(fields))
+	for i, f := range fields {
+		scopes[i] = users.Permission(f)
+	}
+	return scopes
+}
+
+// ListAPIKeys serves GET /profile/api-keys/ with the caller's keys.
+func ListAPIKeys(c echo.Context, keys users.APIKeyRepository) error {
+	userID, apiErr := callerID(c)
+	if apiErr != nil {
+		return respondError(c, apiErr)
+	}
+	return listAPIKeys(c, keys, userID)
+}
+
+// RevokeAPIKey deletes one of the caller's keys.
+func RevokeAPIKey(c echo.Context, keys users.APIKeyRepository) error {
+	userID, apiErr := callerID(c)
+	if apiErr != nil {
+		return respondError(c, apiErr)
+	}
+	return revokeAPIKey(c, keys, userID)
+}
+
+// ListUserAPIKeys serves GET /admin/users/:nickname/api-keys/.
+func ListUserAPIKeys(c echo.Context, repo users.UserRepository, keys users.APIKeyRepository) error {
+	user, err := repo.FindByNickname(c.Request().Context(), c.Param(nickname))
+	if err != nil {
+		return userLookupError(c, err)
+	}
+	return listAPIKeys(c, keys, user.ID)
+}
+
+// RevokeUserAPIKey deletes a key of any account, such as a leaked one.
+func RevokeUserAPIKey(c echo.Context, repo users.UserRepository, keys users.APIKeyRepository) error {
+	user, err := repo.FindByNickname(c.Request().Context(), c.Param(nickname))
+	if err != nil {
+		return userLookupError(c, err)
+	}
+	return revokeAPIKey(c, keys, user.ID)
+}
+
+func callerID(c echo.Context) (primitive.ObjectID, *APIError) {
+	claims := token.ClaimsFromContext(c)
+	if claims == nil {
+		return primitive.NilObjectID, newError(http.StatusUnauthorized, CodeUnauthorized, "missing token")
+	}
+	id, err := primitive.ObjectIDFromHex(claims.UserID())
+	if err != nil {
+		return primitive.NilObjectID, newError(http.StatusUnauthorized, CodeUnauthorized, "invalid token")
+	}
+	return id, nil
+}
+
+func listAPIKeys(c echo.Context, keys users.APIKeyRepository, userID primitive.ObjectID) error {
+	list, err := keys.ListByUser(c.Request().Context(), userID)
+	if err != nil {
+		return internalError(c, "list api keys", err)
+	}
+	result := APIKeys{Keys: make([]APIKeyInfo, 0, len(list))}
+	for i := range list {
+		result.Keys = append(result.Keys, newAPIKeyInfo(&list[i]))
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func revokeAPIKey(c echo.Context, keys users.APIKeyRepository, userID primitive.ObjectID) error {
+	id, err := primitive.ObjectIDFromHex(c.Param(apiKeyParam))
+	if err != nil {
+		return fail(c, http.StatusNotFound, CodeNotFound, users.ErrAPIKeyNotFound.Error())
+	}
+	err = keys.Delete(c.Request().Context(), userID, id)
+	if errors.Is(err, users.ErrAPIKeyNotFound) {
+		return fail(c, http.StatusNotFound, CodeNotFound, err.Error())
+	}
+	if err != nil {
+		return internalError(c, "revoke api key", err)
+	}
+	return c.JSON(http.StatusOK, Message{Message: "api key revoked"})
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"awesomeProject/internal/token"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAPIKeys(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	keys := users.NewMemoryAPIKeyRepository()
+	owner := seedUser(t, repo, "oleksii", "Qwerty1123@#")
+	auth := testTokens.JwtMiddleware(newTestStore(t), token.NewAPIKeys(keys, repo))
+
+	create := func(form url.Values) (int, []byte) {
+		c, rec := newFormContext(http.MethodPost, "/profile/api-keys/", form)
+		authenticate(c, owner)
+		if err := CreateAPIKey(c, keys); err != nil {
+			t.Fatalf("CreateAPIKey() error = %v", err)
+		}
+		return rec.Code, rec.Body.Bytes()
+	}
+	code, body := create(url.Values{"name": {"ci"}, "scopes": {"rating:vote user:delete"}, "expires_in_days": {"0"}})
+	var envelope errorBody
+	_ = json.Unmarshal(body, &envelope)
+	if code != http.StatusBadRequest || envelope.Error == nil || len(envelope.Error.Details) != 2 {
+		t.Fatalf("CreateAPIKey(bad scope and expiry) = %d, %s", code, body)
+	}
+
+	code, body = create(url.Values{"name": {"ci"}, "scopes": {"rating:vote,profile:edit:self"}, "expires_in_days": {"30"}})
+	var created CreatedAPIKey
+	if err := json.Unmarshal(body, &created); err != nil || code != http.StatusCreated {
+		t.Fatalf("CreateAPIKey() = %d, %s", code, body)
+	}
+	if created.Key == "" || created.Prefix != created.Key[:apiKeyPrefixLength] || created.ExpiresAt == nil || len(created.Scopes) != 2 {
+		t.Errorf("CreateAPIKey() = %+v", created)
+	}
+	if stored, _ := keys.FindByDigest(context.Background(), token.APIKeyDigest(created.Key)); stored == nil || stored.Digest == created.Key {
+		t.Errorf("stored key = %+v, expected only the digest of the secret", stored)
+	}
+
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	call := func(header, value string, guards ...echo.MiddlewareFunc) error {
+		c, _ := newFormContext(http.MethodGet, "/profile/", nil)
+		c.Request().Header.Set(header, value)
+		h := ok
+		for i := len(guards) - 1; i >= 0; i-- {
+			h = guards[i](h)
+		}
+		return auth(h)(c)
+	}
+	tests := []struct {
+		name     string
+		header   string
+		guards   []echo.MiddlewareFunc
+		expected error
+	}{
+		{"X-API-Key", "X-API-Key", []echo.MiddlewareFunc{token.RequirePermission(users.PermRatingVote)}, nil},
+		{"bearer", "Authorization", []echo.MiddlewareFunc{token.RequirePermission(users.PermRatingVote)}, nil},
+		{"scope not granted", "X-API-Key", []echo.MiddlewareFunc{token.RequirePermission(users.PermProfileView)}, echo.ErrForbidden},
+		{"login token only", "X-API-Key", []echo.MiddlewareFunc{token.RequireLoginToken}, echo.ErrForbidden},
+	}
+	for _, tt := range tests {
+		value := created.Key
+		if tt.header == "Authorization" {
+			value = "Bearer " + created.Key
+		}
+		if err := call(tt.header, value, tt.guards...); !errors.Is(err, tt.expected) {
+			t.Errorf("request with %s error = %v, expected %v", tt.name, err, tt.expected)
+		}
+	}
+
+	c, rec := newFormContext(http.MethodGet, "/profile/api-keys/", nil)
+	authenticate(c, owner)
+	var list APIKeys
+	if err := ListAPIKeys(c, keys); err != nil || json.Unmarshal(rec.Body.Bytes(), &list) != nil || len(list.Keys) != 1 || list.Keys[0].LastUsedAt == nil {
+		t.Fatalf("ListAPIKeys() = %d %s, %v, expected one used key", rec.Code, rec.Body, err)
+	}
+
+	c, rec = newFormContext(http.MethodPost, "/profile/api-keys/"+created.ID+"/revoke/", nil)
+	c.SetParamNames(apiKeyParam)
+	c.SetParamValues(created.ID)
+	authenticate(c, owner)
+	if err := RevokeAPIKey(c, keys); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("RevokeAPIKey() = %d, %v", rec.Code, err)
+	}
+	if err := call("X-API-Key", created.Key); !errors.Is(err, echo.ErrUnauthorized) {
+		t.Errorf("request with a revoked key error = %v, expected %v", err, echo.ErrUnauthorized)
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"awesomeProject/internal/cash"
+	"awesomeProject/internal/token"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+// cacheFixture shares one Redis between the cache and the leaderboard so the
+// tests see exactly what the handlers would see in production.
+type cacheFixture struct {
+	repo        *users.MemoryRepository
+	votes       *users.MemoryVoteRepository
+	cache       *cash.Cache
+	leaderboard *cash.Leaderboard
+}
+
+func newCacheFixture(t *testing.T) *cacheFixture {
+	client := newTestRedis(t)
+	repo := users.NewMemoryRepository()
+	return &cacheFixture{
+		repo:        repo,
+		votes:       users.NewMemoryVoteRepository(repo),
+		cache:       cash.NewCache(client, cash.DefaultTTL),
+		leaderboard: cash.NewLeaderboard(client),
+	}
+}
+
+func (f *cacheFixture) get(t *testing.T, handler func(c echo.Context) error, target string, names ...string) []byte {
+	t.Helper()
+	c, rec := newFormContext(http.MethodGet, target, nil)
+	if len(names) == 2 {
+		c.SetParamNames(names[0])
+		c.SetParamValues(names[1])
+	}
+	if err := handler(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, %v: %s", target, rec.Code, err, rec.Body)
+	}
+	return rec.Body.Bytes()
+}
+
+func (f *cacheFixture) vote(t *testing.T, voter *users.User, target string) {
+	t.Helper()
+	c, rec := newFormContext(http.MethodPost, "/profile/add-rating/"+target+"/", url.Values{})
+	authenticate(c, voter)
+	c.SetParamNames(nickname)
+	c.SetParamValues(target)
+	if err := ChangeRating(c, f.repo, f.votes, f.leaderboard, f.cache, true); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("vote %s -> %s = %d, %v: %s", voter.Nickname, target, rec.Code, err, rec.Body)
+	}
+}
+
+func TestRatingFreshAfterVote(t *testing.T) {
+	f := newCacheFixture(t)
+	voter := seedUser(t, f.repo, "voter", "Qwerty1123@#")
+	seedUser(t, f.repo, "target", "Qwerty1123@#")
+
+	getRating := func(c echo.Context) error { return GetRating(c, f.repo, f.cache) }
+	var before, after Rating
+	_ = json.Unmarshal(f.get(t, getRating, "/rating/target/", nickname, "target"), &before)
+	f.vote(t, voter, "target")
+	_ = json.Unmarshal(f.get(t, getRating, "/rating/target/", nickname, "target"), &after)
+
+	if before.Rating != 0 || after.Rating != 1 {
+		t.Errorf("rating before/after vote = %d/%d, expected 0/1", before.Rating, after.Rating)
+	}
+}
+
+func TestListingFreshAfterVote(t *testing.T) {
+	f := newCacheFixture(t)
+	voter := seedUser(t, f.repo, "voter", "Qwerty1123@#")
+	for _, nick := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+		seedUser(t, f.repo, nick, "Qwerty1123@#")
+	}
+
+	list := func(c echo.Context) error { return GetAllUsers(c, f.repo, f.cache, 3) }
+	var page0, page1 UsersPage
+	f.get(t, list, "/users/")
+	_ = json.Unmarshal(f.get(t, list, "/users/1", "page", "1"), &page1)
+	promoted := page1.Users[0].Nickname
+
+	f.vote(t, voter, promoted)
+
+	_ = json.Unmarshal(f.get(t, list, "/users/", "page", "0"), &page0)
+	if page0.Users[0].Nickname != promoted || page0.Users[0].Rating != 1 {
+		t.Errorf("first user after vote = %+v, expected %s with rating 1", page0.Users[0], promoted)
+	}
+	_ = json.Unmarshal(f.get(t, list, "/users/1", "page", "1"), &page1)
+	for _, u := range page1.Users {
+		if u.Nickname == promoted {
+			t.Errorf("page 1 still lists %s after it moved to page 0", promoted)
+		}
+	}
+}
+
+func TestProfileFreshAfterEdit(t *testing.T) {
+	f := newCacheFixture(t)
+	owner := seedUser(t, f.repo, "owner", "Qwerty1123@#")
+
+	profile := func(c echo.Context) error { return UserProfile(c, f.repo, f.cache) }
+	f.get(t, profile, "/profile/owner/", nickname, "owner")
+
+	c, rec := newFormContext(http.MethodPost, "/profile/edit/", url.Values{firstname: {"Renamed"}})
+	token.SetClaims(c, &token.Claims{UserNickname: owner.Nickname, UserRole: users.RoleUser})
+	if err := UserEdit(c, f.repo, testHasher, f.cache, testRules); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("UserEdit() = %d, %v", rec.Code, err)
+	}
+
+	var p Profile
+	_ = json.Unmarshal(f.get(t, profile, "/profile/owner/", nickname, "owner"), &p)
+	if p.FirstName != "Renamed" {
+		t.Errorf("profile first name after edit = %s, expected Renamed", p.FirstName)
+	}
+}
+
+func TestProfileGoneAfterDelete(t *testing.T) {
+	f := newCacheFixture(t)
+	owner := seedUser(t, f.repo, "owner", "Qwerty1123@#")
+
+	profile := func(c echo.Context) error { return UserProfile(c, f.repo, f.cache) }
+	f.get(t, profile, "/profile/owner/", nickname, "owner")
+
+	c, rec := newFormContext(http.MethodPost, "/profile/delete/owner/", url.Values{})
+	c.SetParamNames(nickname)
+	c.SetParamValues("owner")
+	token.SetClaims(c, &token.Claims{UserNickname: owner.Nickname, UserRole: users.RoleUser})
+	if err := Delete(c, f.repo, f.leaderboard, f.cache, newTestStore(t)); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Delete() = %d, %v", rec.Code, err)
+	}
+	if _, _, ok := f.cache.Get(c.Request().Context(), cash.ProfileKey("owner")); ok {
+		t.Errorf("profile of a deleted user is still cached")
+	}
+}
+
+func TestReadsServedWithoutRedis(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	seedUser(t, repo, "target", "Qwerty1123@#")
+	cache := cash.NewCache(redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1}), cash.DefaultTTL)
+
+	c, rec := newFormContext(http.MethodGet, "/rating/target/", nil)
+	c.SetParamNames(nickname)
+	c.SetParamValues("target")
+	if err := GetRating(c, repo, cache); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GetRating() without Redis = %d, %v: %s", rec.Code, err, rec.Body)
+	}
+	c, rec = newFormContext(http.MethodGet, "/users/", nil)
+	if err := GetAllUsers(c, repo, cache, 3); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GetAllUsers() without Redis = %d, %v: %s", rec.Code, err, rec.Body)
+	}
+	if stats := cache.Stats(); stats.Errors == 0 {
+		t.Errorf("Redis errors were not counted: %+v", stats)
+	}
+}
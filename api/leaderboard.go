@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"awesomeProject/internal/cash"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	leaderboardDefaultLimit = 10
+	leaderboardMaxLimit     = 100
+	leaderboardBatch        = 500
+)
+
+type LeaderboardEntry struct {
+	Nickname string `json:"nickname"`
+	Rating   int    `json:"rating"`
+	Rank     int64  `json:"rank"`
+}
+
+func newLeaderboardEntries(list []cash.Entry) []LeaderboardEntry {
+	result := make([]LeaderboardEntry, 0, len(list))
+	for _, e := range list {
+		result = append(result, LeaderboardEntry{Nickname: e.Nickname, Rating: e.Rating, Rank: e.Rank})
+	}
+	return result
+}
+
+// TopUsers serves GET /leaderboard/?limit=N.
+func TopUsers(c echo.Context, leaderboard *cash.Leaderboard) error {
+	limit, apiErr := queryInt(c, "limit", leaderboardDefaultLimit, leaderboardMaxLimit)
+	if apiErr != nil {
+		return respondError(c, apiErr)
+	}
+	list, err := leaderboard.Top(c.Request().Context(), 0, limit)
+	if err != nil {
+		return internalError(c, "read leaderboard", err)
+	}
+	return c.JSON(http.StatusOK, newLeaderboardEntries(list))
+}
+
+// UserRank serves GET /leaderboard/:nickname/rank/.
+func UserRank(c echo.Context, leaderboard *cash.Leaderboard) error {
+	entry, err := leaderboard.Rank(c.Request().Context(), c.Param(nickname))
+	if errors.Is(err, cash.ErrNotRanked) {
+		return fail(c, http.StatusNotFound, CodeNotFound, err.Error())
+	}
+	if err != nil {
+		return internalError(c, "read leaderboard", err)
+	}
+	return c.JSON(http.StatusOK, LeaderboardEntry{Nickname: entry.Nickname, Rating: entry.Rating, Rank: entry.Rank})
+}
+
+// UsersAround serves GET /leaderboard/:nickname/around/?radius=N.
+func UsersAround(c echo.Context, leaderboard *cash.Leaderboard) error {
+	radius, apiErr := queryInt(c, "radius", 2, leaderboardMaxLimit/2)
+	if apiErr != nil {
+		return respondError(c, apiErr)
+	}
+	list, err := leaderboard.Around(c.Request().Context(), c.Param(nickname), radius)
+	if errors.Is(err, cash.ErrNotRanked) {
+		return fail(c, http.StatusNotFound, CodeNotFound, err.Error())
+	}
+	if err != nil {
+		return internalError(c, "read leaderboard", err)
+	}
+	return c.JSON(http.StatusOK, newLeaderboardEntries(list))
+}
+
+// RebuildLeaderboard repopulates the leaderboard from the user repository.
+func RebuildLeaderboard(ctx context.Context, repo users.UserRepository, leaderboard *cash.Leaderboard) (int, error) {
+	var skip int64
+	return leaderboard.Rebuild(ctx, func() (map[string]int, error) {
+		list, err := repo.ListByRating(ctx, skip, leaderboardBatch)
+		if err != nil {
+			return nil, err
+		}
+		skip += int64(len(list))
+		batch := make(map[string]int, len(list))
+		for _, u := range list {
+			batch[u.Nickname] = u.Rating
+		}
+		return batch, nil
+	})
+}
+
+// updateLeaderboard mirrors a rating change into the leaderboard. The rating
+// is already stored in the repository, so a failure here is only logged;
+// running the rebuild command fixes any drift.
+func updateLeaderboard(ctx context.Context, leaderboard *cash.Leaderboard, nickname string, rating int) {
+	if err := leaderboard.Set(ctx, nickname, rating); err != nil {
+		log.Errorf("update leaderboard for %s: %s", nickname, err)
+	}
+}
+
+func queryInt(c echo.Context, name string, def, max int64) (int64, *APIError) {
+	raw := c.QueryParam(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || v < 0 || v > max {
+		return 0, validationError(FieldError{Field: name, Message: "must be an integer between 0 and " + strconv.FormatInt(max, 10)})
+	}
+	return v, nil
+}
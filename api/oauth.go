@@ -0,0 +1,597 @@
+package api
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"awesomeProject/internal/hash"
+	"awesomeProject/internal/token"
+	"awesomeProject/internal/totp"
+	"awesomeProject/internal/validation"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	OAuthAuthorizePath = "/oauth/authorize"
+	OAuthTokenPath     = "/oauth/token"
+	OAuthUserInfoPath  = "/oauth/userinfo"
+	JWKSPath           = "/.well-known/jwks.json"
+)
+
+// Error codes of OAuth 2.0 (RFC 6749) and OpenID Connect. OAuth clients
+// expect these in the {"error": "...", "error_description": "..."} form
+// rather than in our envelope.
+const (
+	oauthInvalidRequest          = "invalid_request"
+	oauthInvalidClient           = "invalid_client"
+	oauthInvalidGrant            = "invalid_grant"
+	oauthInvalidScope            = "invalid_scope"
+	oauthUnsupportedGrantType    = "unsupported_grant_type"
+	oauthUnsupportedResponseType = "unsupported_response_type"
+	oauthAccessDenied            = "access_denied"
+	oauthInvalidToken            = "invalid_token"
+	oauthInsufficientScope       = "insufficient_scope"
+)
+
+// supportedScopes are the scopes clients can be registered for, in the
+// order the consent screen lists them.
+var supportedScopes = []string{token.ScopeOpenID, token.ScopeProfile, token.ScopeEmail, token.ScopeOfflineAccess}
+
+var scopeDescriptions = map[string]string{
+	token.ScopeOpenID:        "Sign you in with your account",
+	token.ScopeProfile:       "See your name and nickname",
+	token.ScopeEmail:         "See your email address and whether it is verified",
+	token.ScopeOfflineAccess: "Keep this access while you are not using the app",
+}
+
+// OAuthProvider holds what the OpenID Connect provider handlers need.
+type OAuthProvider struct {
+	Clients users.OAuthClientRepository
+	Codes   *token.AuthorizationCodes
+	// BaseURL is the public URL the endpoints are published under.
+	BaseURL string
+	// Policy decides whether unverified accounts may log in to clients, as
+	// for Login.
+	Policy UnverifiedPolicy
+}
+
+func (p *OAuthProvider) url(path string) string {
+	return strings.TrimSuffix(p.BaseURL, "/") + path
+}
+
+// audience is the aud claim of access tokens issued to clients. They are
+// meant for the userinfo endpoint only.
+func (p *OAuthProvider) audience() string {
+	return p.url(OAuthUserInfoPath)
+}
+
+// OAuthError is the error response of the token and authorization
+// endpoints.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func oauthFail(c echo.Context, status int, code, description string) error {
+	return c.JSON(status, OAuthError{Code: code, Description: description})
+}
+
+// Discovery is the OpenID Provider Metadata.
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	AuthorizationResponseIssParameter bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// OIDCDiscovery serves /.well-known/openid-configuration.
+func OIDCDiscovery(c echo.Context, tokens *token.Manager, provider *OAuthProvider) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, Discovery{
+		Issuer:                            tokens.Issuer(),
+		AuthorizationEndpoint:             provider.url(OAuthAuthorizePath),
+		TokenEndpoint:                     provider.url(OAuthTokenPath),
+		UserInfoEndpoint:                  provider.url(OAuthUserInfoPath),
+		JWKSURI:                           provider.url(JWKSPath),
+		ScopesSupported:                   supportedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{tokens.Keys().Signing().Method.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "azp",
+			"name", "given_name", "family_name", "preferred_username", "email", "email_verified"},
+		AuthorizationResponseIssParameter: true,
+	})
+}
+
+// authorizeRequest is a checked authorization request.
+type authorizeRequest struct {
+	client        *users.OAuthClient
+	redirectURI   string
+	scope         string
+	state         string
+	nonce         string
+	codeChallenge string
+}
+
+// params are the request parameters the consent form sends back.
+func (r *authorizeRequest) params() map[string]string {
+	return map[string]string{
+		"response_type":         "code",
+		"client_id":             r.client.ID,
+		"redirect_uri":          r.redirectURI,
+		"scope":                 r.scope,
+		"state":                 r.state,
+		"nonce":                 r.nonce,
+		"code_challenge":        r.codeChallenge,
+		"code_challenge_method": "S256",
+	}
+}
+
+// parseAuthorizeRequest checks the parameters of an authorization request.
+// Until client and redirect URI are known to be right, errors must not be
+// sent to the redirect URI; the request is nil then.
+func parseAuthorizeRequest(c echo.Context, provider *OAuthProvider) (*authorizeRequest, *OAuthError) {
+	client, err := provider.Clients.FindByID(c.Request().Context(), c.FormValue("client_id"))
+	if errors.Is(err, users.ErrClientNotFound) {
+		return nil, &OAuthError{Code: oauthInvalidClient, Description: "unknown client"}
+	}
+	if err != nil {
+		log.Errorf("load oauth client: %s", err)
+		return nil, &OAuthError{Code: "server_error", Description: "the client could not be loaded"}
+	}
+	req := &authorizeRequest{
+		client:        client,
+		redirectURI:   c.FormValue("redirect_uri"),
+		state:         c.FormValue("state"),
+		nonce:         c.FormValue("nonce"),
+		codeChallenge: c.FormValue("code_challenge"),
+	}
+	if !contains(client.RedirectURIs, req.redirectURI) {
+		return nil, &OAuthError{Code: oauthInvalidRequest, Description: "redirect_uri is not registered for the client"}
+	}
+
+	if c.FormValue("response_type") != "code" {
+		return req, &OAuthError{Code: oauthUnsupportedResponseType, Description: "only the code response type is supported"}
+	}
+	if req.codeChallenge == "" || c.FormValue("code_challenge_method") != "S256" {
+		return req, &OAuthError{Code: oauthInvalidRequest, Description: "PKCE with code_challenge_method S256 is required"}
+	}
+	scopes := strings.Fields(c.FormValue("scope"))
+	if len(scopes) == 0 {
+		return req, &OAuthError{Code: oauthInvalidScope, Description: "scope is required"}
+	}
+	for _, s := range scopes {
+		if !contains(client.Scopes, s) {
+			return req, &OAuthError{Code: oauthInvalidScope, Description: "the client may not request " + s}
+		}
+	}
+	req.scope = strings.Join(scopes, " ")
+	return req, nil
+}
+
+// redirect sends the browser back to the client with params, the state
+// and our issuer (RFC 9207).
+func (r *authorizeRequest) redirect(c echo.Context, issuer string, params url.Values) error {
+	u, err := url.Parse(r.redirectURI)
+	if err != nil {
+		return internalError(c, "parse redirect uri", err)
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if r.state != "" {
+		q.Set("state", r.state)
+	}
+	q.Set("iss", issuer)
+	u.RawQuery = q.Encode()
+	return c.Redirect(http.StatusFound, u.String())
+}
+
+func (r *authorizeRequest) redirectError(c echo.Context, issuer string, e *OAuthError) error {
+	return r.redirect(c, issuer, url.Values{"error": {e.Code}, "error_description": {e.Description}})
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .Client}}Sign in to {{.Client}}{{else}}Cannot sign in{{end}}</title>
+<style>body{font-family:sans-serif;max-width:24rem;margin:3rem auto;padding:0 1rem}label,input,button{display:block;width:100%;margin:.4rem 0}.error{color:#b00020}</style>
+</head>
+<body>
+{{if .Client}}
+<h1>{{.Client}} would like to</h1>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+{{with .Error}}<p class="error">{{.}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<label>Nickname <input name="nickname" value="{{.Nickname}}" autocomplete="username"></label>
+<label>Password <input name="password" type="password" autocomplete="current-password"></label>
+<label>Authenticator code, if you use one <input name="code" inputmode="numeric" autocomplete="one-time-code"></label>
+<button name="decision" value="allow">Allow</button>
+<button name="decision" value="deny">Deny</button>
+</form>
+{{else}}
+<h1>Cannot sign in</h1>
+<p class="error">{{.Error}}</p>
+{{end}}
+</body>
+</html>
+`))
+
+type consentPage struct {
+	Client   string
+	Scopes   []string
+	Params   map[string]string
+	Action   string
+	Nickname string
+	Error    string
+}
+
+func renderConsent(c echo.Context, status int, page consentPage) error {
+	h := c.Response().Header()
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	var b strings.Builder
+	if err := consentTemplate.Execute(&b, page); err != nil {
+		return internalError(c, "render consent", err)
+	}
+	return c.HTML(status, b.String())
+}
+
+// Authorize is the authorization endpoint. GET shows the consent screen,
+// where the user logs in and allows or denies the client; POST handles the
+// answer and sends the browser back to the client with an authorization
+// code. Failed logins count towards the lockout like those of Login.
+func Authorize(c echo.Context, repo users.UserRepository, hasher *hash.PasswordHasher, tokens *token.Manager, guard *token.LoginGuard, provider *OAuthProvider) error {
+	req, oauthErr := parseAuthorizeRequest(c, provider)
+	if req == nil {
+		return renderConsent(c, http.StatusBadRequest, consentPage{Error: oauthErr.Description})
+	}
+	if oauthErr != nil {
+		return req.redirectError(c, tokens.Issuer(), oauthErr)
+	}
+
+	page := consentPage{Client: req.client.Name, Params: req.params(), Action: c.Request().URL.Path}
+	for _, s := range strings.Fields(req.scope) {
+		page.Scopes = append(page.Scopes, scopeDescriptions[s])
+	}
+	if c.Request().Method != http.MethodPost {
+		return renderConsent(c, http.StatusOK, page)
+	}
+	if c.FormValue("decision") != "allow" {
+		return req.redirectError(c, tokens.Issuer(), &OAuthError{Code: oauthAccessDenied, Description: "the user denied the request"})
+	}
+
+	ctx := c.Request().Context()
+	page.Nickname = validation.NormalizeName(c.FormValue(nickname))
+	pass := c.FormValue(password)
+	retry := func(status int, message string) error {
+		page.Error = message
+		return renderConsent(c, status, page)
+	}
+	failed := func(message string) error {
+		if _, err := guard.Fail(ctx, page.Nickname, c.RealIP()); err != nil {
+			log.Errorf("record failed login for %s: %s", page.Nickname, err)
+		}
+		return retry(http.StatusUnauthorized, message)
+	}
+	if page.Nickname == "" || pass == "" {
+		return retry(http.StatusBadRequest, "Enter your nickname and password.")
+	}
+	wait, err := guard.Check(ctx, page.Nickname, c.RealIP())
+	if err != nil {
+		return internalError(c, "check login lock", err)
+	}
+	if wait > 0 {
+		return retry(http.StatusTooManyRequests, "Too many failed attempts, try again later.")
+	}
+
+	user, err := repo.FindByNickname(ctx, page.Nickname)
+	if errors.Is(err, users.ErrUserNotFound) {
+		_, _ = hasher.Hash(pass)
+		return failed("Invalid nickname or password.")
+	}
+	if err != nil {
+		return internalError(c, "load user", err)
+	}
+	ok, rehash, err := hasher.Verify(pass, user.Password)
+	if err != nil || !ok {
+		return failed("Invalid nickname or password.")
+	}
+	if rehash {
+		upgradePassword(ctx, repo, hasher, user, pass)
+	}
+	if provider.Policy == UnverifiedBlock && !user.EmailVerified {
+		return retry(http.StatusForbidden, "Verify your email address first.")
+	}
+	if user.TOTPEnabled {
+		code := c.FormValue(codeParam)
+		if code == "" {
+			return retry(http.StatusUnauthorized, "Enter the code from your authenticator app.")
+		}
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return failed("Incorrect code.")
+		}
+		if err := repo.UseTOTPStep(ctx, user.ID, step); errors.Is(err, users.ErrCodeUsed) {
+			return failed("Incorrect code.")
+		} else if err != nil {
+			return internalError(c, "record totp step", err)
+		}
+	}
+	loginSucceeded(ctx, guard, user.Nickname)
+
+	code, err := provider.Codes.Issue(ctx, &token.AuthorizationCode{
+		ClientID:      req.client.ID,
+		RedirectURI:   req.redirectURI,
+		UserID:        user.ID.Hex(),
+		Scope:         req.scope,
+		Nonce:         req.nonce,
+		CodeChallenge: req.codeChallenge,
+		AuthTime:      time.Now(),
+	})
+	if err != nil {
+		return internalError(c, "issue authorization code", err)
+	}
+	return req.redirect(c, tokens.Issuer(), url.Values{"code": {code}})
+}
+
+// OAuthTokens is the token endpoint response.
+type OAuthTokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// OAuthToken is the token endpoint. It exchanges authorization codes and
+// refresh tokens of the authenticated client. Refresh tokens are only
+// issued for the offline_access scope.
+func OAuthToken(c echo.Context, repo users.UserRepository, tokens *token.Manager, store *token.Store, provider *OAuthProvider) error {
+	h := c.Response().Header()
+	h.Set("Cache-Control", "no-store")
+	h.Set("Pragma", "no-cache")
+	client, oauthErr := authenticateClient(c, provider)
+	if oauthErr != nil {
+		if oauthErr.Code == oauthInvalidClient {
+			h.Set("WWW-Authenticate", `Basic realm="oauth"`)
+			return oauthFail(c, http.StatusUnauthorized, oauthErr.Code, oauthErr.Description)
+		}
+		return oauthFail(c, http.StatusBadRequest, oauthErr.Code, oauthErr.Description)
+	}
+
+	switch c.FormValue("grant_type") {
+	case "authorization_code":
+		return exchangeCode(c, repo, tokens, store, provider, client)
+	case "refresh_token":
+		return refreshClientToken(c, repo, tokens, store, provider, client)
+	default:
+		return oauthFail(c, http.StatusBadRequest, oauthUnsupportedGrantType, "grant_type must be authorization_code or refresh_token")
+	}
+}
+
+// authenticateClient checks the client credentials from the Basic
+// Authorization header or the form. Public clients only send client_id.
+func authenticateClient(c echo.Context, provider *OAuthProvider) (*users.OAuthClient, *OAuthError) {
+	id, secret, basic := c.Request().BasicAuth()
+	if basic {
+		// RFC 6749, section 2.3.1: both are form-urlencoded first.
+		var err1, err2 error
+		id, err1 = url.QueryUnescape(id)
+		secret, err2 = url.QueryUnescape(secret)
+		if err1 != nil || err2 != nil {
+			return nil, &OAuthError{Code: oauthInvalidClient, Description: "malformed client credentials"}
+		}
+	} else {
+		id, secret = c.FormValue("client_id"), c.FormValue("client_secret")
+	}
+	if id == "" {
+		return nil, &OAuthError{Code: oauthInvalidClient, Description: "client authentication is required"}
+	}
+	client, err := provider.Clients.FindByID(c.Request().Context(), id)
+	if errors.Is(err, users.ErrClientNotFound) {
+		return nil, &OAuthError{Code: oauthInvalidClient, Description: "unknown client"}
+	}
+	if err != nil {
+		log.Errorf("load oauth client: %s", err)
+		return nil, &OAuthError{Code: "server_error", Description: "the client could not be loaded"}
+	}
+	if client.Public() != (secret == "") || (!client.Public() && !token.CheckClientSecret(secret, client.SecretDigest)) {
+		return nil, &OAuthError{Code: oauthInvalidClient, Description: "client authentication failed"}
+	}
+	return client, nil
+}
+
+func exchangeCode(c echo.Context, repo users.UserRepository, tokens *token.Manager, store *token.Store, provider *OAuthProvider, client *users.OAuthClient) error {
+	ctx := c.Request().Context()
+	code := c.FormValue("code")
+	grant, err := provider.Codes.Exchange(ctx, code)
+	if errors.Is(err, token.ErrInvalidAuthorizationCode) {
+		return oauthFail(c, http.StatusBadRequest, oauthInvalidGrant, err.Error())
+	}
+	if err != nil {
+		return internalError(c, "exchange authorization code", err)
+	}
+	if grant.ClientID != client.ID || grant.RedirectURI != c.FormValue("redirect_uri") {
+		return oauthFail(c, http.StatusBadRequest, oauthInvalidGrant, "the code was issued to another client or redirect_uri")
+	}
+	if !token.VerifyPKCE(c.FormValue("code_verifier"), grant.CodeChallenge) {
+		return oauthFail(c, http.StatusBadRequest, oauthInvalidGrant, "code_verifier does not match the code_challenge")
+	}
+	user, err := findClientUser(c, repo, grant.UserID)
+	if err != nil {
+		return err
+	}
+
+	session, refreshToken, err := store.IssueClient(ctx, grant.UserID, client.ID, grant.Scope, requestDevice(c))
+	if err != nil {
+		return internalError(c, "start client session", err)
+	}
+	if err := provider.Codes.Redeemed(ctx, code, session); err != nil {
+		return internalError(c, "redeem authorization code", err)
+	}
+	result, err := clientTokens(tokens, provider, user, client, session, grant.Scope)
+	if err != nil {
+		return internalError(c, "issue client tokens", err)
+	}
+	if token.HasScope(grant.Scope, token.ScopeOfflineAccess) {
+		result.RefreshToken = refreshToken
+	}
+	if token.HasScope(grant.Scope, token.ScopeOpenID) {
+		result.IDToken, err = tokens.GenerateIDToken(user, client.ID, grant.Nonce, grant.Scope, grant.AuthTime)
+		if err != nil {
+			return internalError(c, "issue id token", err)
+		}
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func refreshClientToken(c echo.Context, repo users.UserRepository, tokens *token.Manager, store *token.Store, provider *OAuthProvider, client *users.OAuthClient) error {
+	ctx := c.Request().Context()
+	session, next, err := store.Rotate(ctx, c.FormValue("refresh_token"))
+	if errors.Is(err, token.ErrInvalidRefreshToken) || errors.Is(err, token.ErrRefreshTokenReused) {
+		return oauthFail(c, http.StatusBadRequest, oauthInvalidGrant, err.Error())
+	}
+	if err != nil {
+		return internalError(c, "rotate refresh token", err)
+	}
+	if session.ClientID != client.ID {
+		// Someone else's refresh token: treat it as leaked and end the
+		// session it belongs to.
+		_ = store.Revoke(ctx, next)
+		return oauthFail(c, http.StatusBadRequest, oauthInvalidGrant, token.ErrInvalidRefreshToken.Error())
+	}
+	scope := session.Scope
+	if requested := c.FormValue("scope"); requested != "" {
+		for _, s := range strings.Fields(requested) {
+			if !token.HasScope(session.Scope, s) {
+				return oauthFail(c, http.StatusBadRequest, oauthInvalidScope, "the refresh token does not grant "+s)
+			}
+		}
+		scope = strings.Join(strings.Fields(requested), " ")
+	}
+	user, err := findClientUser(c, repo, session.UserID)
+	if err != nil {
+		_ = store.Revoke(ctx, next)
+		return err
+	}
+	result, err := clientTokens(tokens, provider, user, client, session, scope)
+	if err != nil {
+		return internalError(c, "issue client tokens", err)
+	}
+	result.RefreshToken = next
+	return c.JSON(http.StatusOK, result)
+}
+
+func findClientUser(c echo.Context, repo users.UserRepository, userID string) (*users.User, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, oauthFail(c, http.StatusBadRequest, oauthInvalidGrant, "unknown user")
+	}
+	user, err := repo.FindByID(c.Request().Context(), id)
+	if errors.Is(err, users.ErrUserNotFound) {
+		return nil, oauthFail(c, http.StatusBadRequest, oauthInvalidGrant, "the user no longer exists")
+	}
+	if err != nil {
+		return nil, internalError(c, "load user", err)
+	}
+	return user, nil
+}
+
+func clientTokens(tokens *token.Manager, provider *OAuthProvider, user *users.User, client *users.OAuthClient, session *token.Session, scope string) (*OAuthTokens, error) {
+	accessToken, err := tokens.GenerateClientToken(user, session.ID, client.ID, scope, provider.audience())
+	if err != nil {
+		return nil, err
+	}
+	return &OAuthTokens{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(tokens.AccessTTL().Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+// UserInfo is the userinfo response: the subject and the claims its scope
+// releases.
+type UserInfo struct {
+	Subject string `json:"sub"`
+	token.Profile
+}
+
+// OAuthUserInfo serves the userinfo endpoint to clients holding an access
+// token with the openid scope. Tokens stop working with their session or
+// when the client is removed.
+func OAuthUserInfo(c echo.Context, repo users.UserRepository, tokens *token.Manager, store *token.Store, provider *OAuthProvider) error {
+	invalid := func(code, description string) error {
+		c.Response().Header().Set("WWW-Authenticate", `Bearer error="`+code+`", error_description="`+description+`"`)
+		status := http.StatusUnauthorized
+		if code == oauthInsufficientScope {
+			status = http.StatusForbidden
+		}
+		return oauthFail(c, status, code, description)
+	}
+	accessToken, _ := token.Credentials(c.Request())
+	claims, err := tokens.ParseClientToken(accessToken, provider.audience())
+	if err != nil {
+		return invalid(oauthInvalidToken, "the access token is invalid")
+	}
+	if !token.HasScope(claims.Scope, token.ScopeOpenID) {
+		return invalid(oauthInsufficientScope, "the openid scope is required")
+	}
+	ctx := c.Request().Context()
+	if err := store.Touch(ctx, claims.SessionID, claims.ID); errors.Is(err, token.ErrSessionNotFound) {
+		return invalid(oauthInvalidToken, "the session has ended")
+	} else if err != nil {
+		return internalError(c, "check session", err)
+	}
+	if _, err := provider.Clients.FindByID(ctx, claims.ClientID); errors.Is(err, users.ErrClientNotFound) {
+		return invalid(oauthInvalidToken, "the client was removed")
+	} else if err != nil {
+		return internalError(c, "load oauth client", err)
+	}
+	id, _ := primitive.ObjectIDFromHex(claims.UserID())
+	user, err := repo.FindByID(ctx, id)
+	if errors.Is(err, users.ErrUserNotFound) {
+		return invalid(oauthInvalidToken, "the user no longer exists")
+	}
+	if err != nil {
+		return internalError(c, "load user", err)
+	}
+	return c.JSON(http.StatusOK, UserInfo{Subject: user.ID.Hex(), Profile: token.NewProfile(user, claims.Scope)})
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
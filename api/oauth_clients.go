@@ -0,0 +1,186 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"awesomeProject/internal/token"
+	"awesomeProject/internal/validation"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	clientParam = "client"
+
+	// maxRedirectURIs bounds the redirect URIs of one client.
+	maxRedirectURIs = 10
+)
+
+var clientRules = struct {
+	Name validation.Rule
+}{
+	Name: validation.Rule{ID: "client_name", Message: "must be at most 64 characters", Check: func(s string) bool {
+		return utf8.RuneCountInString(s) <= 64
+	}},
+}
+
+// OAuthClientInfo describes a registered client without its secret.
+type OAuthClientInfo struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	Public       bool      `json:"public"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// RegisteredClient is the answer to RegisterClient, the only one carrying
+// the secret of a confidential client.
+type RegisteredClient struct {
+	OAuthClientInfo
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+type OAuthClients struct {
+	Clients []OAuthClientInfo `json:"clients"`
+}
+
+func newOAuthClientInfo(client *users.OAuthClient) OAuthClientInfo {
+	return OAuthClientInfo{
+		ClientID:     client.ID,
+		Name:         client.Name,
+		Public:       client.Public(),
+		RedirectURIs: client.RedirectURIs,
+		Scopes:       client.Scopes,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// RegisterClient registers an OAuth client. The form has a name, the
+// redirect_uris and scopes (separated by spaces or commas) and public=true
+// for clients that cannot keep a secret. Confidential clients get their
+// secret in the answer and nowhere else.
+func RegisterClient(c echo.Context, clients users.OAuthClientRepository) error {
+	name := strings.TrimSpace(c.FormValue("name"))
+	rawURIs := c.FormValue("redirect_uris")
+	rawScopes := c.FormValue("scopes")
+	public := c.FormValue("public") == "true"
+
+	var v validation.Validator
+	v.Required("name", name, clientRules.Name)
+	v.Required("redirect_uris", rawURIs)
+	v.Required("scopes", rawScopes)
+	redirectURIs := splitList(rawURIs)
+	if len(redirectURIs) > maxRedirectURIs {
+		v.Add("redirect_uris", "redirect_uri_count", fmt.Sprintf("at most %d redirect URIs are allowed", maxRedirectURIs))
+	}
+	for _, uri := range redirectURIs {
+		if problem := checkRedirectURI(uri); problem != "" {
+			v.Add("redirect_uris", "redirect_uri", fmt.Sprintf("%s %s", uri, problem))
+		}
+	}
+	scopes := splitList(rawScopes)
+	for _, scope := range scopes {
+		if !contains(supportedScopes, scope) {
+			v.Add("scopes", "client_scope", fmt.Sprintf("%s is not a supported scope", scope))
+		}
+	}
+	if apiErr := invalidRequest(&v); apiErr != nil {
+		return respondError(c, apiErr)
+	}
+
+	clientID, secret, secretDigest, err := token.NewClientCredentials()
+	if err != nil {
+		return internalError(c, "generate client credentials", err)
+	}
+	client := &users.OAuthClient{
+		ID:           clientID,
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		Scopes:       scopes,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if public {
+		secret = ""
+	} else {
+		client.SecretDigest = secretDigest
+	}
+	if err := clients.Insert(c.Request().Context(), client); err != nil {
+		return internalError(c, "store oauth client", err)
+	}
+	return c.JSON(http.StatusCreated, RegisteredClient{OAuthClientInfo: newOAuthClientInfo(client), ClientSecret: secret})
+}
+
+// checkRedirectURI returns what is wrong with a redirect URI, if anything.
+// Plain http is only accepted for loopback addresses, which native apps
+// listen on (RFC 8252, section 7.3).
+func checkRedirectURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return "is not an absolute URL"
+	}
+	if u.Fragment != "" || strings.Contains(uri, "#") {
+		return "must not have a fragment"
+	}
+	switch u.Scheme {
+	case "https":
+		return ""
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+			return ""
+		}
+		return "must use https"
+	default:
+		return "must use https"
+	}
+}
+
+// splitList splits s at spaces and commas and drops duplicates.
+func splitList(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' })
+	list := make([]string, 0, len(fields))
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		if !seen[f] {
+			seen[f] = true
+			list = append(list, f)
+		}
+	}
+	return list
+}
+
+// ListClients serves GET /admin/oauth/clients/.
+func ListClients(c echo.Context, clients users.OAuthClientRepository) error {
+	list, err := clients.List(c.Request().Context())
+	if err != nil {
+		return internalError(c, "list oauth clients", err)
+	}
+	result := OAuthClients{Clients: make([]OAuthClientInfo, 0, len(list))}
+	for i := range list {
+		result.Clients = append(result.Clients, newOAuthClientInfo(&list[i]))
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// DeleteClient removes a client. Its access tokens stop working at the
+// userinfo endpoint and its refresh tokens can no longer be used, since the
+// client cannot authenticate any more.
+func DeleteClient(c echo.Context, clients users.OAuthClientRepository) error {
+	err := clients.Delete(c.Request().Context(), c.Param(clientParam))
+	if errors.Is(err, users.ErrClientNotFound) {
+		return fail(c, http.StatusNotFound, CodeNotFound, err.Error())
+	}
+	if err != nil {
+		return internalError(c, "delete oauth client", err)
+	}
+	return c.JSON(http.StatusOK, Message{Message: "client deleted"})
+}
@@ -0,0 +1,424 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/token"
+	"awesomeProject/users"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const testRedirectURI = "http://127.0.0.1:8765/callback"
+
+// oauthFixture runs the provider endpoints on a test server, signing with an
+// Ed25519 key as clients can only verify ID tokens through the JWKS.
+type oauthFixture struct {
+	srv      *httptest.Server
+	repo     *users.MemoryRepository
+	clients  *users.MemoryOAuthClientRepository
+	tokens   *token.Manager
+	store    *token.Store
+	provider *OAuthProvider
+	// browser does not follow redirects, so the test sees where the
+	// provider sends the user.
+	browser *http.Client
+}
+
+func newOAuthFixture(t *testing.T) *oauthFixture {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := token.ParseKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := token.NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &oauthFixture{
+		repo:    users.NewMemoryRepository(),
+		clients: users.NewMemoryOAuthClientRepository(),
+		browser: &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}},
+	}
+	client := newTestRedis(t)
+	f.store = token.NewStore(client, time.Hour)
+	guard := newTestGuard(t)
+
+	e := echo.New()
+	f.srv = httptest.NewServer(e)
+	t.Cleanup(f.srv.Close)
+	f.tokens = token.NewManager(keys, f.srv.URL, "users-api", 15*time.Minute)
+	f.provider = &OAuthProvider{
+		Clients: f.clients,
+		Codes:   token.NewAuthorizationCodes(client, f.store, time.Minute),
+		BaseURL: f.srv.URL,
+		Policy:  UnverifiedAllow,
+	}
+
+	e.GET(JWKSPath, func(c echo.Context) error { return JWKS(c, f.tokens) })
+	e.GET("/.well-known/openid-configuration", func(c echo.Context) error {
+		return OIDCDiscovery(c, f.tokens, f.provider)
+	})
+	authorize := func(c echo.Context) error {
+		return Authorize(c, f.repo, testHasher, f.tokens, guard, f.provider)
+	}
+	e.GET(OAuthAuthorizePath, authorize)
+	e.POST(OAuthAuthorizePath, authorize)
+	e.POST(OAuthTokenPath, func(c echo.Context) error {
+		return OAuthToken(c, f.repo, f.tokens, f.store, f.provider)
+	})
+	e.GET(OAuthUserInfoPath, func(c echo.Context) error {
+		return OAuthUserInfo(c, f.repo, f.tokens, f.store, f.provider)
+	})
+	e.GET("/profile/sessions/", func(c echo.Context) error {
+		return ListSessions(c, f.store)
+	}, f.tokens.JwtMiddleware(f.store, nil))
+	return f
+}
+
+// register registers a client through the admin handler.
+func (f *oauthFixture) register(t *testing.T, form url.Values) RegisteredClient {
+	t.Helper()
+	c, rec := newFormContext(http.MethodPost, "/admin/oauth/clients/", form)
+	if err := RegisterClient(c, f.clients); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("RegisterClient() = %d, %v: %s", rec.Code, err, rec.Body)
+	}
+	var client RegisteredClient
+	if err := json.Unmarshal(rec.Body.Bytes(), &client); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func (f *oauthFixture) do(t *testing.T, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := f.browser.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func (f *oauthFixture) get(t *testing.T, path string, query url.Values) (*http.Response, []byte) {
+	req, _ := http.NewRequest(http.MethodGet, f.srv.URL+path+"?"+query.Encode(), nil)
+	return f.do(t, req)
+}
+
+func (f *oauthFixture) post(t *testing.T, path string, form url.Values, clientID, secret string) (*http.Response, []byte) {
+	req, _ := http.NewRequest(http.MethodPost, f.srv.URL+path, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	if secret != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(secret))
+	}
+	return f.do(t, req)
+}
+
+func (f *oauthFixture) tokenRequest(t *testing.T, form url.Values, client RegisteredClient) (int, OAuthTokens, OAuthError) {
+	t.Helper()
+	if client.Public {
+		// Public clients identify themselves in the form instead.
+		form.Set("client_id", client.ClientID)
+	}
+	resp, body := f.post(t, OAuthTokenPath, form, client.ClientID, client.ClientSecret)
+	var tokens OAuthTokens
+	var oauthErr OAuthError
+	if resp.StatusCode == http.StatusOK {
+		_ = json.Unmarshal(body, &tokens)
+	} else {
+		_ = json.Unmarshal(body, &oauthErr)
+	}
+	if cache := resp.Header.Get("Cache-Control"); cache != "no-store" {
+		t.Errorf("token endpoint Cache-Control = %q, expected no-store", cache)
+	}
+	return resp.StatusCode, tokens, oauthErr
+}
+
+// verifyIDToken checks an ID token the way a client would: with the key
+// published in the JWKS.
+func (f *oauthFixture) verifyIDToken(t *testing.T, raw, clientID string) *token.IDClaims {
+	t.Helper()
+	_, body := f.get(t, JWKSPath, nil)
+	var set token.JWKSet
+	if err := json.Unmarshal(body, &set); err != nil || len(set.Keys) == 0 {
+		t.Fatalf("JWKS = %s, %v", body, err)
+	}
+	var claims token.IDClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(tok *jwt.Token) (interface{}, error) {
+		for _, k := range set.Keys {
+			if k.Kid == tok.Header["kid"] && k.Crv == "Ed25519" {
+				x, err := base64.RawURLEncoding.DecodeString(k.X)
+				return ed25519.PublicKey(x), err
+			}
+		}
+		return nil, jwt.ErrTokenUnverifiable
+	}, jwt.WithValidMethods([]string{"EdDSA"}), jwt.WithIssuer(f.srv.URL), jwt.WithAudience(clientID), jwt.WithExpirationRequired())
+	if err != nil {
+		t.Fatalf("verify id token error = %v", err)
+	}
+	return &claims
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	f := newOAuthFixture(t)
+	user := seedUser(t, f.repo, "oleksii", "Qwerty1123@#")
+	client := f.register(t, url.Values{
+		"name":          {"Notes"},
+		"redirect_uris": {testRedirectURI + " https://notes.example/callback"},
+		"scopes":        {"openid profile email offline_access"},
+	})
+	if client.ClientSecret == "" || client.Public {
+		t.Fatalf("RegisterClient() = %+v, expected a confidential client", client)
+	}
+
+	_, body := f.get(t, "/.well-known/openid-configuration", nil)
+	var discovery Discovery
+	if err := json.Unmarshal(body, &discovery); err != nil {
+		t.Fatal(err)
+	}
+	if discovery.Issuer != f.srv.URL || discovery.TokenEndpoint != f.srv.URL+OAuthTokenPath || discovery.IDTokenSigningAlgValuesSupported[0] != "EdDSA" {
+		t.Errorf("discovery = %+v", discovery)
+	}
+
+	verifier := strings.Repeat("verifier-", 6)
+	request := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ClientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"openid email offline_access"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {token.PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	resp, body := f.get(t, OAuthAuthorizePath, request)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "Notes would like to") {
+		t.Fatalf("GET authorize = %d: %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Frame-Options") != "DENY" {
+		t.Errorf("consent page X-Frame-Options = %q, expected DENY", resp.Header.Get("X-Frame-Options"))
+	}
+
+	login := url.Values{nickname: {"oleksii"}, password: {"wrong"}, "decision": {"allow"}}
+	for k, v := range request {
+		login[k] = v
+	}
+	if resp, _ := f.post(t, OAuthAuthorizePath, login, "", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("POST authorize with a wrong password = %d, expected %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	login.Set(password, "Qwerty1123@#")
+	resp, _ = f.post(t, OAuthAuthorizePath, login, "", "")
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("POST authorize = %d, expected %d", resp.StatusCode, http.StatusFound)
+	}
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	code := callback.Query().Get("code")
+	if !strings.HasPrefix(callback.String(), testRedirectURI) || code == "" || callback.Query().Get("state") != "xyz" || callback.Query().Get("iss") != f.srv.URL {
+		t.Fatalf("authorize redirect = %s", callback)
+	}
+
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {verifier},
+	}
+	status, tokens, oauthErr := f.tokenRequest(t, exchange, client)
+	if status != http.StatusOK || tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.TokenType != "Bearer" {
+		t.Fatalf("exchange code = %d, %+v, %+v", status, tokens, oauthErr)
+	}
+	id := f.verifyIDToken(t, tokens.IDToken, client.ClientID)
+	if id.Subject != user.ID.Hex() || id.Nonce != "n-0S6_WzA2Mj" || id.Email != user.Email || id.PreferredUsername != "" {
+		t.Errorf("id token = %+v, expected the email claims of %s", id, user.Nickname)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, f.srv.URL+OAuthUserInfoPath, nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	resp, body = f.do(t, req)
+	var info UserInfo
+	_ = json.Unmarshal(body, &info)
+	if resp.StatusCode != http.StatusOK || info.Subject != user.ID.Hex() || info.Email != user.Email {
+		t.Errorf("userinfo = %d: %s", resp.StatusCode, body)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, f.srv.URL+"/profile/sessions/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	if resp, _ := f.do(t, req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("API call with a client token = %d, expected %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	refresh := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}, "scope": {"openid"}}
+	status, refreshed, oauthErr := f.tokenRequest(t, refresh, client)
+	if status != http.StatusOK || refreshed.RefreshToken == tokens.RefreshToken || refreshed.Scope != "openid" {
+		t.Fatalf("refresh = %d, %+v, %+v", status, refreshed, oauthErr)
+	}
+
+	if status, _, oauthErr := f.tokenRequest(t, exchange, client); status != http.StatusBadRequest || oauthErr.Code != oauthInvalidGrant {
+		t.Errorf("exchange used code = %d, %+v, expected invalid_grant", status, oauthErr)
+	}
+	refresh.Set("refresh_token", refreshed.RefreshToken)
+	if status, _, oauthErr := f.tokenRequest(t, refresh, client); status != http.StatusBadRequest || oauthErr.Code != oauthInvalidGrant {
+		t.Errorf("refresh after code reuse = %d, %+v, expected the session to be revoked", status, oauthErr)
+	}
+}
+
+func TestAuthorizeRejectsBadRequests(t *testing.T) {
+	f := newOAuthFixture(t)
+	client := f.register(t, url.Values{
+		"name":          {"Notes"},
+		"redirect_uris": {testRedirectURI},
+		"scopes":        {"openid"},
+	})
+	valid := func() url.Values {
+		return url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.ClientID},
+			"redirect_uri":          {testRedirectURI},
+			"scope":                 {"openid"},
+			"code_challenge":        {token.PKCEChallenge(strings.Repeat("v", 43))},
+			"code_challenge_method": {"S256"},
+		}
+	}
+
+	// Without a known client and redirect URI the user must not be sent
+	// anywhere.
+	pages := map[string]func(v url.Values){
+		"unknown client":       func(v url.Values) { v.Set("client_id", "unknown") },
+		"unregistered uri":     func(v url.Values) { v.Set("redirect_uri", "https://evil.example/callback") },
+		"uri prefix":           func(v url.Values) { v.Set("redirect_uri", testRedirectURI+"/more") },
+		"missing redirect uri": func(v url.Values) { v.Del("redirect_uri") },
+	}
+	for name, change := range pages {
+		query := valid()
+		change(query)
+		if resp, _ := f.get(t, OAuthAuthorizePath, query); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("authorize(%s) = %d, expected %d", name, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	redirects := map[string]struct {
+		change   func(v url.Values)
+		expected string
+	}{
+		"token response": {func(v url.Values) { v.Set("response_type", "token") }, oauthUnsupportedResponseType},
+		"no pkce":        {func(v url.Values) { v.Del("code_challenge") }, oauthInvalidRequest},
+		"plain pkce":     {func(v url.Values) { v.Set("code_challenge_method", "plain") }, oauthInvalidRequest},
+		"unknown scope":  {func(v url.Values) { v.Set("scope", "openid email") }, oauthInvalidScope},
+		"no scope":       {func(v url.Values) { v.Del("scope") }, oauthInvalidScope},
+	}
+	for name, tt := range redirects {
+		query := valid()
+		tt.change(query)
+		resp, _ := f.get(t, OAuthAuthorizePath, query)
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		if resp.StatusCode != http.StatusFound || location.Query().Get("error") != tt.expected {
+			t.Errorf("authorize(%s) = %d to %s, expected error %s", name, resp.StatusCode, location, tt.expected)
+		}
+	}
+
+	deny := valid()
+	deny.Set("decision", "deny")
+	resp, _ := f.post(t, OAuthAuthorizePath, deny, "", "")
+	if location, _ := url.Parse(resp.Header.Get("Location")); location.Query().Get("error") != oauthAccessDenied {
+		t.Errorf("authorize(deny) redirect = %s, expected error %s", location, oauthAccessDenied)
+	}
+}
+
+func TestOAuthTokenClientAuthentication(t *testing.T) {
+	f := newOAuthFixture(t)
+	user := seedUser(t, f.repo, "oleksii", "Qwerty1123@#")
+	confidential := f.register(t, url.Values{"name": {"Web"}, "redirect_uris": {testRedirectURI}, "scopes": {"openid"}})
+	public := f.register(t, url.Values{"name": {"CLI"}, "redirect_uris": {testRedirectURI}, "scopes": {"openid"}, "public": {"true"}})
+	if public.ClientSecret != "" || !public.Public {
+		t.Fatalf("RegisterClient(public) = %+v, expected no secret", public)
+	}
+
+	verifier := strings.Repeat("v", 43)
+	issue := func(clientID string) string {
+		code, err := f.provider.Codes.Issue(context.Background(), &token.AuthorizationCode{
+			ClientID:      clientID,
+			RedirectURI:   testRedirectURI,
+			UserID:        user.ID.Hex(),
+			Scope:         "openid",
+			CodeChallenge: token.PKCEChallenge(verifier),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	exchange := func(code string) url.Values {
+		return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {testRedirectURI}, "code_verifier": {verifier}}
+	}
+
+	wrongSecret := confidential
+	wrongSecret.ClientSecret = "wrong"
+	if status, _, oauthErr := f.tokenRequest(t, exchange(issue(confidential.ClientID)), wrongSecret); status != http.StatusUnauthorized || oauthErr.Code != oauthInvalidClient {
+		t.Errorf("token(wrong secret) = %d, %+v, expected invalid_client", status, oauthErr)
+	}
+	if status, _, oauthErr := f.tokenRequest(t, exchange(issue(confidential.ClientID)), public); status != http.StatusBadRequest || oauthErr.Code != oauthInvalidGrant {
+		t.Errorf("token(code of another client) = %d, %+v, expected invalid_grant", status, oauthErr)
+	}
+	form := exchange(issue(public.ClientID))
+	form.Set("code_verifier", strings.Repeat("w", 43))
+	if status, _, oauthErr := f.tokenRequest(t, form, public); status != http.StatusBadRequest || oauthErr.Code != oauthInvalidGrant {
+		t.Errorf("token(wrong verifier) = %d, %+v, expected invalid_grant", status, oauthErr)
+	}
+
+	status, tokens, oauthErr := f.tokenRequest(t, exchange(issue(public.ClientID)), public)
+	if status != http.StatusOK || tokens.IDToken == "" || tokens.RefreshToken != "" {
+		t.Errorf("token(public client) = %d, %+v, %+v, expected tokens without a refresh token", status, tokens, oauthErr)
+	}
+	if status, _, oauthErr := f.tokenRequest(t, url.Values{"grant_type": {"password"}}, confidential); status != http.StatusBadRequest || oauthErr.Code != oauthUnsupportedGrantType {
+		t.Errorf("token(password grant) = %d, %+v, expected unsupported_grant_type", status, oauthErr)
+	}
+}
+
+func TestRegisterClientValidation(t *testing.T) {
+	clients := users.NewMemoryOAuthClientRepository()
+	tests := map[string]url.Values{
+		"http redirect":    {"name": {"Web"}, "redirect_uris": {"http://notes.example/callback"}, "scopes": {"openid"}},
+		"fragment":         {"name": {"Web"}, "redirect_uris": {"https://notes.example/callback#x"}, "scopes": {"openid"}},
+		"relative":         {"name": {"Web"}, "redirect_uris": {"/callback"}, "scopes": {"openid"}},
+		"unknown scope":    {"name": {"Web"}, "redirect_uris": {testRedirectURI}, "scopes": {"openid admin"}},
+		"no redirect uris": {"name": {"Web"}, "scopes": {"openid"}},
+	}
+	for name, form := range tests {
+		c, rec := newFormContext(http.MethodPost, "/admin/oauth/clients/", form)
+		if err := RegisterClient(c, clients); err != nil || rec.Code != http.StatusBadRequest {
+			t.Errorf("RegisterClient(%s) = %d, %v, expected %d", name, rec.Code, err, http.StatusBadRequest)
+		}
+	}
+	if list, _ := clients.List(context.Background()); len(list) != 0 {
+		t.Errorf("clients after invalid registrations = %+v, expected none", list)
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesomeProject/internal/hash"
+	"awesomeProject/internal/mail"
+	"awesomeProject/internal/token"
+	"awesomeProject/internal/validation"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const resetTokenParam = "token"
+
+// PasswordReset holds what the password reset handlers need.
+type PasswordReset struct {
+	Tokens          *token.OneTimeTokens
+	Mailer          mail.Mailer
+	BaseURL         string
+	TokenTTL        time.Duration
+	RequestInterval time.Duration
+}
+
+func (r *PasswordReset) sendReset(ctx context.Context, user *users.User) error {
+	tok, err := r.Tokens.Issue(ctx, token.PurposeResetPassword, user.ID.Hex(), r.TokenTTL)
+	if err != nil {
+		return err
+	}
+	link := strings.TrimSuffix(r.BaseURL, "/") + "/password/reset/?" + url.Values{resetTokenParam: {tok}}.Encode()
+	return r.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nsomeone asked to reset the password of your account. If it was you, open this link:\n\n%s\n\n"+
+			"The link expires in %s. If you did not ask for it, ignore this email.\n", user.Nickname, link, r.TokenTTL),
+	})
+}
+
+// ForgotPassword mails a password reset link. Like ResendVerification it
+// answers the same way for known and unknown addresses.
+func ForgotPassword(c echo.Context, repo users.UserRepository, reset *PasswordReset) error {
+	ctx := c.Request().Context()
+	address := strings.ToLower(c.FormValue(email))
+	var v validation.Validator
+	v.Required(email, address)
+	if apiErr := invalidRequest(&v); apiErr != nil {
+		return respondError(c, apiErr)
+	}
+
+	wait, err := reset.Tokens.Throttle(ctx, token.PurposeResetPassword, address, reset.RequestInterval)
+	if err != nil {
+		return internalError(c, "throttle password reset", err)
+	}
+	if wait > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())))
+		return fail(c, http.StatusTooManyRequests, CodeTooManyRequests, "password reset was requested recently")
+	}
+
+	user, err := repo.FindByEmail(ctx, address)
+	if err != nil && !errors.Is(err, users.ErrUserNotFound) {
+		return internalError(c, "load user", err)
+	}
+	if err == nil {
+		if err := reset.sendReset(ctx, user); err != nil {
+			log.Errorf("send password reset email to %s: %s", user.Nickname, err)
+		}
+	}
+	return c.JSON(http.StatusAccepted, Message{Message: "if the address belongs to an account, a reset link was sent"})
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword and
+// signs the account out everywhere. Following the emailed link also proves
+// that the address works, so the account counts as verified afterwards.
+func ResetPassword(c echo.Context, repo users.UserRepository, hasher *hash.PasswordHasher, store *token.Store, reset *PasswordReset, rules *validation.Policy) error {
+	ctx := c.Request().Context()
+	resetToken, newPassword := c.FormValue(resetTokenParam), c.FormValue(password)
+	var v validation.Validator
+	v.Required(resetTokenParam, resetToken)
+	v.Required(password, newPassword)
+	if apiErr := invalidRequest(&v); apiErr != nil {
+		return respondError(c, apiErr)
+	}
+
+	// The token is only used up once the new password is accepted, so a
+	// rejected password can be corrected with the same link.
+	subject, err := reset.Tokens.Subject(token.PurposeResetPassword, resetToken)
+	if err != nil {
+		return fail(c, http.StatusBadRequest, CodeBadRequest, token.ErrInvalidOneTimeToken.Error())
+	}
+	id, err := primitive.ObjectIDFromHex(subject)
+	if err != nil {
+		return fail(c, http.StatusBadRequest, CodeBadRequest, token.ErrInvalidOneTimeToken.Error())
+	}
+	user, err := repo.FindByID(ctx, id)
+	if err != nil {
+		return userLookupError(c, err)
+	}
+	v.Required(password, newPassword, rules.Password.Rules(user.Nickname, user.Email)...)
+	if apiErr := invalidRequest(&v); apiErr != nil {
+		return respondError(c, apiErr)
+	}
+
+	_, err = reset.Tokens.Consume(ctx, token.PurposeResetPassword, resetToken)
+	if errors.Is(err, token.ErrInvalidOneTimeToken) {
+		return fail(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	if err != nil {
+		return internalError(c, "reset password", err)
+	}
+
+	user.Password, err = hasher.Hash(newPassword)
+	if err != nil {
+		return internalError(c, "hash password", err)
+	}
+	user.EmailVerified = true
+	user.UpdatedAt = time.Now()
+	if err := repo.Update(ctx, user); err != nil {
+		return userLookupError(c, err)
+	}
+	if err := store.RevokeUser(ctx, user.ID.Hex()); err != nil {
+		return internalError(c, "revoke sessions", err)
+	}
+	return c.JSON(http.StatusOK, Message{Message: "password changed, log in again"})
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/mail"
+	"awesomeProject/internal/token"
+	"awesomeProject/users"
+)
+
+func newTestPasswordReset(t *testing.T) *PasswordReset {
+	return &PasswordReset{
+		Tokens:          token.NewOneTimeTokens(newTestRedis(t), []byte("reset-secret")),
+		Mailer:          mail.NewMemoryMailer(),
+		BaseURL:         "http://api.example.org",
+		TokenTTL:        time.Hour,
+		RequestInterval: time.Minute,
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	store := newTestStore(t)
+	reset := newTestPasswordReset(t)
+	seedUser(t, repo, "oleksii", "Qwerty1123@#")
+
+	login := func(pass string, policy UnverifiedPolicy) (*token.Pair, int) {
+		c, rec := newFormContext(http.MethodPost, "/log-in/", url.Values{nickname: {"oleksii"}, password: {pass}})
+		if err := Login(c, repo, testHasher, testTokens, store, policy, newTestTwoFactor(t), newTestGuard(t)); err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		var pair token.Pair
+		_ = json.Unmarshal(rec.Body.Bytes(), &pair)
+		return &pair, rec.Code
+	}
+	resetWith := func(tok, pass string) int {
+		c, rec := newFormContext(http.MethodPost, "/password/reset/", url.Values{resetTokenParam: {tok}, password: {pass}})
+		if err := ResetPassword(c, repo, testHasher, store, reset, testRules); err != nil {
+			t.Fatalf("ResetPassword() error = %v", err)
+		}
+		return rec.Code
+	}
+
+	session, _ := login("Qwerty1123@#", UnverifiedAllow)
+
+	c, rec := newFormContext(http.MethodPost, "/password/forgot/", url.Values{email: {"Oleksii@example.com"}})
+	if err := ForgotPassword(c, repo, reset); err != nil || rec.Code != http.StatusAccepted {
+		t.Fatalf("ForgotPassword() = %d, %v", rec.Code, err)
+	}
+	msg, ok := reset.Mailer.(*mail.MemoryMailer).Last("oleksii@example.com")
+	if !ok {
+		t.Fatalf("no reset email sent")
+	}
+	link := msg.Body[strings.Index(msg.Body, "http://"):]
+	link, _, _ = strings.Cut(link, "\n")
+	u, _ := url.Parse(link)
+	tok := u.Query().Get(resetTokenParam)
+
+	if code := resetWith(tok, "weak"); code != http.StatusBadRequest {
+		t.Errorf("ResetPassword() with a weak password = %d, expected %d", code, http.StatusBadRequest)
+	}
+	if code := resetWith(tok, "my-oleksii-password"); code != http.StatusBadRequest {
+		t.Errorf("ResetPassword() with the nickname in the password = %d, expected %d", code, http.StatusBadRequest)
+	}
+	if code := resetWith(tok, "N3w-Passw0rd!"); code != http.StatusOK {
+		t.Fatalf("ResetPassword() = %d, expected %d", code, http.StatusOK)
+	}
+	if code := resetWith(tok, "An0ther-Passw0rd!"); code != http.StatusBadRequest {
+		t.Errorf("ResetPassword() with a used token = %d, expected %d", code, http.StatusBadRequest)
+	}
+
+	c, rec = newFormContext(http.MethodPost, "/token/refresh/", url.Values{refreshTokenParam: {session.RefreshToken}})
+	if err := RefreshToken(c, repo, testTokens, store); err != nil || rec.Code != http.StatusUnauthorized {
+		t.Errorf("RefreshToken() with a session from before the reset = %d, %v", rec.Code, err)
+	}
+	if _, code := login("Qwerty1123@#", UnverifiedAllow); code != http.StatusUnauthorized {
+		t.Errorf("Login() with the old password = %d, expected %d", code, http.StatusUnauthorized)
+	}
+	// Seeded users start unverified; the reset link proved the address.
+	if _, code := login("N3w-Passw0rd!", UnverifiedBlock); code != http.StatusOK {
+		t.Errorf("Login() with the new password = %d, expected %d", code, http.StatusOK)
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	reset := newTestPasswordReset(t)
+
+	c, rec := newFormContext(http.MethodPost, "/password/forgot/", url.Values{email: {"nobody@example.com"}})
+	if err := ForgotPassword(c, repo, reset); err != nil || rec.Code != http.StatusAccepted {
+		t.Fatalf("ForgotPassword(unknown) = %d, %v, expected %d", rec.Code, err, http.StatusAccepted)
+	}
+	if n := len(reset.Mailer.(*mail.MemoryMailer).Messages()); n != 0 {
+		t.Errorf("ForgotPassword(unknown) sent %d emails", n)
+	}
+	c, rec = newFormContext(http.MethodPost, "/password/forgot/", url.Values{email: {"nobody@example.com"}})
+	if err := ForgotPassword(c, repo, reset); err != nil || rec.Code != http.StatusTooManyRequests {
+		t.Errorf("ForgotPassword(unknown) again = %d, %v, expected %d", rec.Code, err, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"awesomeProject/internal/validation"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// Error codes are part of the API contract: clients switch on them, so
+// existing values must not change.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeValidationFailed = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeInvalidLogin     = "invalid_credentials"
+	CodeForbidden        = "forbidden"
+	CodeEmailNotVerified = "email_not_verified"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodeTooManyRequests  = "too_many_requests"
+	CodeInternal         = "internal_error"
+)
+
+// APIError is the body of every error response, wrapped as {"error": ...}.
+type APIError struct {
+	Status  int          `json:"-"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+type FieldError struct {
+	Field string `json:"field"`
+	// Rule identifies the failed check, such as required or password_digit.
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+type errorBody struct {
+	Error *APIError `json:"error"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func newError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func validationError(details ...FieldError) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Code: CodeValidationFailed, Message: "request validation failed", Details: details}
+}
+
+// invalidRequest turns the violations collected by v into a validation
+// error, or returns nil when the request is valid.
+func invalidRequest(v *validation.Validator) *APIError {
+	var violations validation.Errors
+	if !errors.As(v.Err(), &violations) {
+		return nil
+	}
+	details := make([]FieldError, len(violations))
+	for i, violation := range violations {
+		details[i] = FieldError{Field: violation.Field, Rule: violation.Rule, Message: violation.Message}
+	}
+	return validationError(details...)
+}
+
+// respondError writes err as the error envelope.
+func respondError(c echo.Context, err *APIError) error {
+	return c.JSON(err.Status, errorBody{Error: err})
+}
+
+func fail(c echo.Context, status int, code, message string) error {
+	return respondError(c, newError(status, code, message))
+}
+
+// internalError logs the cause and hides it from the client.
+func internalError(c echo.Context, what string, err error) error {
+	log.Errorf("%s %s: %s: %s", c.Request().Method, c.Request().URL.Path, what, err)
+	return fail(c, http.StatusInternalServerError, CodeInternal, what)
+}
+
+// userLookupError maps a repository lookup error to a 404 or a 500.
+func userLookupError(c echo.Context, err error) error {
+	if errors.Is(err, users.ErrUserNotFound) {
+		return fail(c, http.StatusNotFound, CodeNotFound, "user not found")
+	}
+	return internalError(c, "load user", err)
+}
+
+// ErrorHandler renders errors returned by handlers and middleware, including
+// echo's own HTTP errors, in the common envelope.
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		_ = respondError(c, apiErr)
+		return
+	}
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		message := http.StatusText(he.Code)
+		if m, ok := he.Message.(string); ok {
+			message = m
+		}
+		_ = fail(c, he.Code, codeForStatus(he.Code), message)
+		return
+	}
+	_ = internalError(c, "unexpected error", err)
+}
+
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+type Message struct {
+	Message string `json:"message"`
+}
+
+type UserSummary struct {
+	Nickname string `json:"nickname"`
+	Email    string `json:"email"`
+	Rating   int    `json:"rating"`
+}
+
+type UsersPage struct {
+	Page  int64         `json:"page"`
+	Users []UserSummary `json:"users"`
+}
+
+type Profile struct {
+	ID          string    `json:"id"`
+	Nickname    string    `json:"nickname"`
+	FirstName   string    `json:"first_name"`
+	LastName    string    `json:"last_name"`
+	Information string    `json:"information,omitempty"`
+	Rating      int       `json:"rating"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type Rating struct {
+	Nickname string `json:"nickname"`
+	Rating   int    `json:"rating"`
+}
+
+func newUserSummary(u *users.User) UserSummary {
+	return UserSummary{Nickname: u.Nickname, Email: u.Email, Rating: u.Rating}
+}
+
+func newProfile(u *users.User) Profile {
+	return Profile{
+		ID:          u.ID.Hex(),
+		Nickname:    u.Nickname,
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		Information: u.Information,
+		Rating:      u.Rating,
+		CreatedAt:   u.CreatedAt,
+	}
+}
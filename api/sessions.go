@@ -0,0 +1,128 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"awesomeProject/internal/token"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+)
+
+const sessionParam = "session"
+
+// SessionInfo is a login as shown to its user or an admin.
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Client is the client_id of the OAuth client the session belongs to.
+	Client string `json:"client,omitempty"`
+	// Current marks the session of the token the list was requested with.
+	Current bool `json:"current"`
+}
+
+type Sessions struct {
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// ListSessions serves GET /profile/sessions/ with the caller's logins.
+func ListSessions(c echo.Context, store *token.Store) error {
+	claims := token.ClaimsFromContext(c)
+	if claims == nil {
+		return fail(c, http.StatusUnauthorized, CodeUnauthorized, "missing token")
+	}
+	return listSessions(c, store, claims.UserID())
+}
+
+// RevokeSession ends one of the caller's logins.
+func RevokeSession(c echo.Context, store *token.Store) error {
+	claims := token.ClaimsFromContext(c)
+	if claims == nil {
+		return fail(c, http.StatusUnauthorized, CodeUnauthorized, "missing token")
+	}
+	return revokeSession(c, store, claims.UserID())
+}
+
+// RevokeSessions ends every login of the caller, the current one included.
+func RevokeSessions(c echo.Context, store *token.Store) error {
+	claims := token.ClaimsFromContext(c)
+	if claims == nil {
+		return fail(c, http.StatusUnauthorized, CodeUnauthorized, "missing token")
+	}
+	return revokeSessions(c, store, claims.UserID())
+}
+
+// ListUserSessions serves GET /admin/users/:nickname/sessions/.
+func ListUserSessions(c echo.Context, repo users.UserRepository, store *token.Store) error {
+	user, err := repo.FindByNickname(c.Request().Context(), c.Param(nickname))
+	if err != nil {
+		return userLookupError(c, err)
+	}
+	return listSessions(c, store, user.ID.Hex())
+}
+
+// RevokeUserSession ends one login of any account, such as a compromised
+// one.
+func RevokeUserSession(c echo.Context, repo users.UserRepository, store *token.Store) error {
+	user, err := repo.FindByNickname(c.Request().Context(), c.Param(nickname))
+	if err != nil {
+		return userLookupError(c, err)
+	}
+	return revokeSession(c, store, user.ID.Hex())
+}
+
+// RevokeUserSessions ends every login of any account.
+func RevokeUserSessions(c echo.Context, repo users.UserRepository, store *token.Store) error {
+	user, err := repo.FindByNickname(c.Request().Context(), c.Param(nickname))
+	if err != nil {
+		return userLookupError(c, err)
+	}
+	return revokeSessions(c, store, user.ID.Hex())
+}
+
+func listSessions(c echo.Context, store *token.Store, userID string) error {
+	sessions, err := store.Sessions(c.Request().Context(), userID)
+	if err != nil {
+		return internalError(c, "list sessions", err)
+	}
+	var current string
+	if claims := token.ClaimsFromContext(c); claims != nil {
+		current = claims.SessionID
+	}
+	result := Sessions{Sessions: make([]SessionInfo, 0, len(sessions))}
+	for _, s := range sessions {
+		result.Sessions = append(result.Sessions, SessionInfo{
+			ID:        s.ID,
+			UserAgent: s.UserAgent,
+			IP:        s.IP,
+			CreatedAt: s.CreatedAt,
+			LastSeen:  s.LastSeen,
+			Client:    s.ClientID,
+			Current:   s.ID == current,
+		})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func revokeSession(c echo.Context, store *token.Store, userID string) error {
+	err := store.RevokeSession(c.Request().Context(), userID, c.Param(sessionParam))
+	if errors.Is(err, token.ErrSessionNotFound) {
+		return fail(c, http.StatusNotFound, CodeNotFound, err.Error())
+	}
+	if err != nil {
+		return internalError(c, "revoke session", err)
+	}
+	return c.JSON(http.StatusOK, Message{Message: "session revoked"})
+}
+
+func revokeSessions(c echo.Context, store *token.Store, userID string) error {
+	if err := store.RevokeUser(c.Request().Context(), userID); err != nil {
+		return internalError(c, "revoke sessions", err)
+	}
+	return c.JSON(http.StatusOK, Message{Message: "sessions revoked"})
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"awesomeProject/internal/token"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSessions(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	store := newTestStore(t)
+	admin := seedUser(t, repo, "admin", "Qwerty1123@#")
+	admin.Role = users.RoleAdmin
+	seedUser(t, repo, "oleksii", "Qwerty1123@#")
+
+	login := func(userAgent string) token.Pair {
+		c, rec := newFormContext(http.MethodPost, "/log-in/", url.Values{nickname: {"oleksii"}, password: {"Qwerty1123@#"}})
+		c.Request().Header.Set("User-Agent", userAgent)
+		if err := Login(c, repo, testHasher, testTokens, store, UnverifiedAllow, newTestTwoFactor(t), newTestGuard(t)); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("Login(%s) = %d, %v", userAgent, rec.Code, err)
+		}
+		var pair token.Pair
+		if err := json.Unmarshal(rec.Body.Bytes(), &pair); err != nil {
+			t.Fatal(err)
+		}
+		return pair
+	}
+	// call runs handler behind JwtMiddleware with accessToken.
+	call := func(accessToken string, handler echo.HandlerFunc, params ...string) (*httptest.ResponseRecorder, error) {
+		c, rec := newFormContext(http.MethodPost, "/profile/sessions/", nil)
+		c.Request().Header.Set("Authorization", accessToken)
+		if len(params) > 0 {
+			c.SetParamNames(sessionParam)
+			c.SetParamValues(params...)
+		}
+		err := testTokens.JwtMiddleware(store, nil)(handler)(c)
+		return rec, err
+	}
+	list := func(accessToken string) Sessions {
+		rec, err := call(accessToken, func(c echo.Context) error { return ListSessions(c, store) })
+		if err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("ListSessions() = %d, %v", rec.Code, err)
+		}
+		var result Sessions
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	laptop, phone := login("Firefox"), login("Safari")
+	sessions := list(laptop.AccessToken).Sessions
+	if len(sessions) != 2 {
+		t.Fatalf("ListSessions() = %+v, expected 2 sessions", sessions)
+	}
+	var phoneID string
+	for _, s := range sessions {
+		if s.Current != (s.UserAgent == "Firefox") {
+			t.Errorf("ListSessions() = %+v, expected only the laptop session to be current", s)
+		}
+		if s.UserAgent == "Safari" {
+			phoneID = s.ID
+		}
+	}
+
+	revoke := func(c echo.Context) error { return RevokeSession(c, store) }
+	if rec, err := call(laptop.AccessToken, revoke, "unknown"); err != nil || rec.Code != http.StatusNotFound {
+		t.Errorf("RevokeSession(unknown) = %d, %v, expected %d", rec.Code, err, http.StatusNotFound)
+	}
+	if rec, err := call(laptop.AccessToken, revoke, phoneID); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("RevokeSession(phone) = %d, %v", rec.Code, err)
+	}
+	if _, err := call(phone.AccessToken, revoke, phoneID); !errors.Is(err, echo.ErrUnauthorized) {
+		t.Errorf("access token of a revoked session error = %v, expected %v", err, echo.ErrUnauthorized)
+	}
+	if sessions := list(laptop.AccessToken).Sessions; len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("ListSessions() after revoke = %+v, expected the current session only", sessions)
+	}
+
+	c, rec := newFormContext(http.MethodPost, "/admin/users/oleksii/sessions/revoke/", nil)
+	c.SetParamNames(nickname)
+	c.SetParamValues("oleksii")
+	authenticate(c, admin)
+	if err := RevokeUserSessions(c, repo, store); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("RevokeUserSessions() = %d, %v", rec.Code, err)
+	}
+	if _, err := call(laptop.AccessToken, revoke, "unknown"); !errors.Is(err, echo.ErrUnauthorized) {
+		t.Errorf("access token after RevokeUserSessions error = %v, expected %v", err, echo.ErrUnauthorized)
+	}
+}
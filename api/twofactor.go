@@ -0,0 +1,199 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"awesomeProject/internal/hash"
+	"awesomeProject/internal/token"
+	"awesomeProject/internal/totp"
+	"awesomeProject/internal/validation"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	codeParam           = "code"
+	recoveryCodeParam   = "recovery_code"
+	challengeTokenParam = "challenge_token"
+
+	recoveryCodeCount = 10
+)
+
+// TwoFactor holds what the TOTP handlers need.
+type TwoFactor struct {
+	// Tokens issues the challenge a password login returns for accounts
+	// with 2FA enabled.
+	Tokens       *token.OneTimeTokens
+	Issuer       string
+	ChallengeTTL time.Duration
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// Challenge replaces the token pair when the password was right but a
+// second factor is still missing.
+type Challenge struct {
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresIn      int64  `json:"expires_in"`
+}
+
+// EnrollTOTP generates a secret for the caller. 2FA stays off until
+// ConfirmTOTP proves the authenticator was set up; enrolling again replaces
+// an unconfirmed secret.
+func EnrollTOTP(c echo.Context, repo users.UserRepository, twoFactor *TwoFactor) error {
+	user, apiErr := authenticatedUser(c, repo)
+	if apiErr != nil {
+		return respondError(c, apiErr)
+	}
+	if user.TOTPEnabled {
+		return fail(c, http.StatusConflict, CodeConflict, "two-factor authentication is already enabled")
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return internalError(c, "generate totp secret", err)
+	}
+	if err := repo.SetTOTP(c.Request().Context(), user.ID, secret, false, nil); err != nil {
+		return userLookupError(c, err)
+	}
+	return c.JSON(http.StatusOK, TOTPEnrollment{Secret: secret, URI: totp.URI(twoFactor.Issuer, user.Nickname, secret)})
+}
+
+// ConfirmTOTP enables 2FA once the caller sends a valid code for the
+// enrolled secret, and returns the recovery codes. They are not stored in
+// plain text, so this is the only time they can be shown.
+func ConfirmTOTP(c echo.Context, repo users.UserRepository) error {
+	user, apiErr := authenticatedUser(c, repo)
+	if apiErr != nil {
+		return respondError(c, apiErr)
+	}
+	if user.TOTPEnabled {
+		return fail(c, http.StatusConflict, CodeConflict, "two-factor authentication is already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return fail(c, http.StatusBadRequest, CodeBadRequest, "enroll an authenticator first")
+	}
+	step, ok := totp.Validate(user.TOTPSecret, c.FormValue(codeParam), time.Now())
+	if !ok {
+		return respondError(c, validationError(FieldError{Field: codeParam, Message: "incorrect code"}))
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return internalError(c, "generate recovery codes", err)
+	}
+	digests := make([]string, len(codes))
+	for i, code := range codes {
+		digests[i] = totp.HashRecoveryCode(code)
+	}
+	ctx := c.Request().Context()
+	if err := repo.SetTOTP(ctx, user.ID, user.TOTPSecret, true, digests); err != nil {
+		return userLookupError(c, err)
+	}
+	if err := repo.UseTOTPStep(ctx, user.ID, step); err != nil {
+		return internalError(c, "record totp step", err)
+	}
+	return c.JSON(http.StatusOK, RecoveryCodes{Codes: codes})
+}
+
+// DisableTOTP turns 2FA off after checking the caller's password.
+func DisableTOTP(c echo.Context, repo users.UserRepository, hasher *hash.PasswordHasher) error {
+	user, apiErr := authenticatedUser(c, repo)
+	if apiErr != nil {
+		return respondError(c, apiErr)
+	}
+	if ok, _, err := hasher.Verify(c.FormValue(password), user.Password); err != nil || !ok {
+		return fail(c, http.StatusUnauthorized, CodeInvalidLogin, "password incorrect")
+	}
+	if err := repo.SetTOTP(c.Request().Context(), user.ID, "", false, nil); err != nil {
+		return userLookupError(c, err)
+	}
+	return c.JSON(http.StatusOK, Message{Message: "two-factor authentication disabled"})
+}
+
+// LoginTOTP finishes a login that returned a Challenge, with either an
+// authenticator code or a recovery code. A challenge works only once, right
+// or wrong, so guessing codes means starting over with the password.
+func LoginTOTP(c echo.Context, repo users.UserRepository, tokens *token.Manager, store *token.Store, twoFactor *TwoFactor, guard *token.LoginGuard) error {
+	ctx := c.Request().Context()
+	subject, err := twoFactor.Tokens.Consume(ctx, token.PurposeLoginChallenge, c.FormValue(challengeTokenParam))
+	if errors.Is(err, token.ErrInvalidOneTimeToken) {
+		return fail(c, http.StatusUnauthorized, CodeUnauthorized, "invalid or expired challenge")
+	}
+	if err != nil {
+		return internalError(c, "check login challenge", err)
+	}
+	id, err := primitive.ObjectIDFromHex(subject)
+	if err != nil {
+		return fail(c, http.StatusUnauthorized, CodeUnauthorized, "invalid or expired challenge")
+	}
+	user, err := repo.FindByID(ctx, id)
+	if err != nil {
+		return userLookupError(c, err)
+	}
+	if !user.TOTPEnabled {
+		return fail(c, http.StatusUnauthorized, CodeUnauthorized, "invalid or expired challenge")
+	}
+	wait, err := guard.Check(ctx, user.Nickname, c.RealIP())
+	if err != nil {
+		return internalError(c, "check login lock", err)
+	}
+	if wait > 0 {
+		return lockedOut(c, wait)
+	}
+
+	code, recovery := c.FormValue(codeParam), c.FormValue(recoveryCodeParam)
+	switch {
+	case code != "":
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return loginFailed(c, guard, user.Nickname, "incorrect code")
+		}
+		err = repo.UseTOTPStep(ctx, user.ID, step)
+	case recovery != "":
+		err = repo.UseRecoveryCode(ctx, user.ID, totp.HashRecoveryCode(recovery))
+	default:
+		return respondError(c, validationError(FieldError{Field: codeParam, Rule: validation.RuleRequired, Message: "code or recovery_code is required"}))
+	}
+	if errors.Is(err, users.ErrCodeUsed) {
+		return loginFailed(c, guard, user.Nickname, "incorrect code")
+	}
+	if err != nil {
+		return internalError(c, "use second factor", err)
+	}
+	loginSucceeded(ctx, guard, user.Nickname)
+
+	pair, err := issueTokens(c, user, tokens, store)
+	if err != nil {
+		return internalError(c, "issue token", err)
+	}
+	return c.JSON(http.StatusOK, pair)
+}
+
+// authenticatedUser loads the account of the authenticated caller.
+func authenticatedUser(c echo.Context, repo users.UserRepository) (*users.User, *APIError) {
+	claims := token.ClaimsFromContext(c)
+	if claims == nil {
+		return nil, newError(http.StatusUnauthorized, CodeUnauthorized, "missing token")
+	}
+	user, err := repo.FindByNickname(c.Request().Context(), claims.UserNickname)
+	if errors.Is(err, users.ErrUserNotFound) {
+		return nil, newError(http.StatusUnauthorized, CodeUnauthorized, "user not found")
+	}
+	if err != nil {
+		log.Errorf("load user %s: %s", claims.UserNickname, err)
+		return nil, newError(http.StatusInternalServerError, CodeInternal, "load user")
+	}
+	return user, nil
+}
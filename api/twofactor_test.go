@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/token"
+	"awesomeProject/internal/totp"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestTwoFactor(t *testing.T) *TwoFactor {
+	return &TwoFactor{
+		Tokens:       token.NewOneTimeTokens(newTestRedis(t), []byte("challenge-secret")),
+		Issuer:       "API Users",
+		ChallengeTTL: time.Minute,
+	}
+}
+
+// callAs runs handler as an authenticated request from u.
+func callAs(t *testing.T, u *users.User, handler func(c echo.Context) error, form url.Values) (int, []byte) {
+	t.Helper()
+	c, rec := newFormContext(http.MethodPost, "/profile/2fa/", form)
+	authenticate(c, u)
+	if err := handler(c); err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	return rec.Code, rec.Body.Bytes()
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	store := newTestStore(t)
+	twoFactor := newTestTwoFactor(t)
+	guard := newTestGuard(t)
+	owner := seedUser(t, repo, "oleksii", "Qwerty1123@#")
+
+	code, body := callAs(t, owner, func(c echo.Context) error { return EnrollTOTP(c, repo, twoFactor) }, url.Values{})
+	var enrollment TOTPEnrollment
+	if err := json.Unmarshal(body, &enrollment); code != http.StatusOK || err != nil || enrollment.Secret == "" {
+		t.Fatalf("EnrollTOTP() = %d, %s", code, body)
+	}
+
+	confirm := func(c echo.Context) error { return ConfirmTOTP(c, repo) }
+	if code, _ := callAs(t, owner, confirm, url.Values{codeParam: {"000000"}}); code != http.StatusBadRequest {
+		t.Errorf("ConfirmTOTP(wrong code) = %d, expected %d", code, http.StatusBadRequest)
+	}
+	// Confirm with the previous step's code so that the current one is still
+	// unused for the login below.
+	previous, _ := totp.Code(enrollment.Secret, time.Now().Add(-totp.Period*time.Second))
+	code, body = callAs(t, owner, confirm, url.Values{codeParam: {previous}})
+	var recovery RecoveryCodes
+	if err := json.Unmarshal(body, &recovery); code != http.StatusOK || err != nil || len(recovery.Codes) != recoveryCodeCount {
+		t.Fatalf("ConfirmTOTP() = %d, %s", code, body)
+	}
+
+	login := func() string {
+		c, rec := newFormContext(http.MethodPost, "/log-in/", url.Values{nickname: {"oleksii"}, password: {"Qwerty1123@#"}})
+		if err := Login(c, repo, testHasher, testTokens, store, UnverifiedAllow, twoFactor, guard); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("Login() = %d, %v", rec.Code, err)
+		}
+		var challenge Challenge
+		_ = json.Unmarshal(rec.Body.Bytes(), &challenge)
+		if challenge.ChallengeToken == "" {
+			t.Fatalf("Login() with 2FA returned no challenge: %s", rec.Body)
+		}
+		return challenge.ChallengeToken
+	}
+	second := func(form url.Values) (int, token.Pair) {
+		c, rec := newFormContext(http.MethodPost, "/log-in/2fa/", form)
+		if err := LoginTOTP(c, repo, testTokens, store, twoFactor, guard); err != nil {
+			t.Fatalf("LoginTOTP() error = %v", err)
+		}
+		var pair token.Pair
+		_ = json.Unmarshal(rec.Body.Bytes(), &pair)
+		return rec.Code, pair
+	}
+
+	current, _ := totp.Code(enrollment.Secret, time.Now())
+	tests := []struct {
+		name     string
+		form     url.Values
+		expected int
+	}{
+		{"replayed confirmation code", url.Values{codeParam: {previous}}, http.StatusUnauthorized},
+		{"current code", url.Values{codeParam: {current}}, http.StatusOK},
+		{"replayed current code", url.Values{codeParam: {current}}, http.StatusUnauthorized},
+		{"recovery code", url.Values{recoveryCodeParam: {recovery.Codes[0]}}, http.StatusOK},
+		{"used recovery code", url.Values{recoveryCodeParam: {recovery.Codes[0]}}, http.StatusUnauthorized},
+		{"unknown recovery code", url.Values{recoveryCodeParam: {"AAAAA-AAAAA"}}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		tt.form.Set(challengeTokenParam, login())
+		code, pair := second(tt.form)
+		if code != tt.expected {
+			t.Errorf("LoginTOTP(%s) = %d, expected %d", tt.name, code, tt.expected)
+		}
+		if code == http.StatusOK && pair.AccessToken == "" {
+			t.Errorf("LoginTOTP(%s) returned no access token", tt.name)
+		}
+	}
+
+	challenge := login()
+	second(url.Values{challengeTokenParam: {challenge}, codeParam: {"000000"}})
+	if code, _ := second(url.Values{challengeTokenParam: {challenge}, recoveryCodeParam: {recovery.Codes[1]}}); code != http.StatusUnauthorized {
+		t.Errorf("LoginTOTP() with a spent challenge = %d, expected %d", code, http.StatusUnauthorized)
+	}
+
+	disable := func(c echo.Context) error { return DisableTOTP(c, repo, testHasher) }
+	if code, _ := callAs(t, owner, disable, url.Values{password: {"wrong"}}); code != http.StatusUnauthorized {
+		t.Errorf("DisableTOTP(wrong password) = %d, expected %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := callAs(t, owner, disable, url.Values{password: {"Qwerty1123@#"}}); code != http.StatusOK {
+		t.Errorf("DisableTOTP() = %d, expected %d", code, http.StatusOK)
+	}
+	c, rec := newFormContext(http.MethodPost, "/log-in/", url.Values{nickname: {"oleksii"}, password: {"Qwerty1123@#"}})
+	if err := Login(c, repo, testHasher, testTokens, store, UnverifiedAllow, twoFactor, guard); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Login() after disabling 2FA = %d, %v", rec.Code, err)
+	}
+	var pair token.Pair
+	if _ = json.Unmarshal(rec.Body.Bytes(), &pair); pair.AccessToken == "" {
+		t.Errorf("Login() after disabling 2FA returned no tokens: %s", rec.Body)
+	}
+}
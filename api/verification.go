@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesomeProject/internal/mail"
+	"awesomeProject/internal/token"
+	"awesomeProject/internal/validation"
+	"awesomeProject/users"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const verificationTokenParam = "token"
+
+// UnverifiedPolicy decides what accounts with an unverified email may do.
+type UnverifiedPolicy string
+
+const (
+	// UnverifiedAllow puts no restrictions on unverified accounts.
+	UnverifiedAllow UnverifiedPolicy = "allow"
+	// UnverifiedLimited lets unverified accounts log in and read, but not
+	// edit, delete or vote.
+	UnverifiedLimited UnverifiedPolicy = "limited"
+	// UnverifiedBlock refuses to log unverified accounts in.
+	UnverifiedBlock UnverifiedPolicy = "block"
+)
+
+// EmailVerification holds what the verification handlers need.
+type EmailVerification struct {
+	Tokens         *token.OneTimeTokens
+	Mailer         mail.Mailer
+	Policy         UnverifiedPolicy
+	BaseURL        string
+	TokenTTL       time.Duration
+	ResendInterval time.Duration
+}
+
+// sendVerification mails a fresh verification link to user. Earlier links
+// stop working.
+func (v *EmailVerification) sendVerification(ctx context.Context, user *users.User) error {
+	tok, err := v.Tokens.Issue(ctx, token.PurposeVerifyEmail, user.ID.Hex(), v.TokenTTL)
+	if err != nil {
+		return err
+	}
+	link := strings.TrimSuffix(v.BaseURL, "/") + "/verify-email/?" + url.Values{verificationTokenParam: {tok}}.Encode()
+	return v.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email",
+		Body: fmt.Sprintf("Hi %s,\n\nplease confirm your email address by opening this link:\n\n%s\n\nThe link expires in %s.\n",
+			user.Nickname, link, v.TokenTTL),
+	})
+}
+
+// VerifyEmail consumes a verification token and marks its account verified.
+func VerifyEmail(c echo.Context, repo users.UserRepository, verification *EmailVerification) error {
+	ctx := c.Request().Context()
+	subject, err := verification.Tokens.Consume(ctx, token.PurposeVerifyEmail, c.FormValue(verificationTokenParam))
+	if errors.Is(err, token.ErrInvalidOneTimeToken) {
+		return fail(c, http.StatusBadRequest, CodeBadRequest, err.Error())
+	}
+	if err != nil {
+		return internalError(c, "verify email", err)
+	}
+	id, err := primitive.ObjectIDFromHex(subject)
+	if err != nil {
+		return fail(c, http.StatusBadRequest, CodeBadRequest, token.ErrInvalidOneTimeToken.Error())
+	}
+	user, err := repo.FindByID(ctx, id)
+	if err != nil {
+		return userLookupError(c, err)
+	}
+	if !user.EmailVerified {
+		user.EmailVerified = true
+		user.UpdatedAt = time.Now()
+		if err := repo.Update(ctx, user); err != nil {
+			return userLookupError(c, err)
+		}
+	}
+	return c.JSON(http.StatusOK, Message{Message: "email verified"})
+}
+
+// ResendVerification mails a new verification link. The reply is the same
+// whether or not the address belongs to an unverified account, so it cannot
+// be used to find registered emails.
+func ResendVerification(c echo.Context, repo users.UserRepository, verification *EmailVerification) error {
+	ctx := c.Request().Context()
+	address := strings.ToLower(c.FormValue(email))
+	var v validation.Validator
+	v.Required(email, address)
+	if apiErr := invalidRequest(&v); apiErr != nil {
+		return respondError(c, apiErr)
+	}
+
+	wait, err := verification.Tokens.Throttle(ctx, token.PurposeVerifyEmail, address, verification.ResendInterval)
+	if err != nil {
+		return internalError(c, "throttle verification email", err)
+	}
+	if wait > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())))
+		return fail(c, http.StatusTooManyRequests, CodeTooManyRequests, "verification email was sent recently")
+	}
+
+	user, err := repo.FindByEmail(ctx, address)
+	if err != nil && !errors.Is(err, users.ErrUserNotFound) {
+		return internalError(c, "load user", err)
+	}
+	if err == nil && !user.EmailVerified {
+		if err := verification.sendVerification(ctx, user); err != nil {
+			log.Errorf("send verification email to %s: %s", user.Nickname, err)
+		}
+	}
+	return c.JSON(http.StatusAccepted, Message{Message: "if the address belongs to an unverified account, a new link was sent"})
+}
+
+// RequireVerifiedEmail rejects requests from unverified accounts when policy
+// is UnverifiedLimited. It must run after JwtMiddleware.
+func RequireVerifiedEmail(policy UnverifiedPolicy) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if policy != UnverifiedLimited {
+				return next(c)
+			}
+			claims := token.ClaimsFromContext(c)
+			if claims == nil {
+				return echo.ErrUnauthorized
+			}
+			if !claims.EmailVerified {
+				return errEmailNotVerified
+			}
+			return next(c)
+		}
+	}
+}
+
+var errEmailNotVerified = newError(http.StatusForbidden, CodeEmailNotVerified, "email address is not verified")
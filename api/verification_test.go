@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/mail"
+	"awesomeProject/internal/token"
+	"awesomeProject/users"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestVerification(t *testing.T) *EmailVerification {
+	return newVerificationOn(newTestRedis(t))
+}
+
+func newVerificationOn(client *redis.Client) *EmailVerification {
+	return &EmailVerification{
+		Tokens:         token.NewOneTimeTokens(client, []byte("verification-secret")),
+		Mailer:         mail.NewMemoryMailer(),
+		Policy:         UnverifiedLimited,
+		BaseURL:        "http://api.example.org/",
+		TokenTTL:       time.Hour,
+		ResendInterval: time.Minute,
+	}
+}
+
+func register(t *testing.T, repo users.UserRepository, verification *EmailVerification, nick string) {
+	t.Helper()
+	c, rec := newFormContext(http.MethodPost, "/register/", url.Values{
+		firstname: {"Oleksii"},
+		lastname:  {"Pazyuk"},
+		nickname:  {nick},
+		email:     {nick + "@example.org"},
+		password:  {"Qwerty1123@#"},
+	})
+	if err := UserRegister(c, repo, testHasher, newTestLeaderboard(t), newTestCache(t), verification, testRules); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("UserRegister(%s) = %d, %v: %s", nick, rec.Code, err, rec.Body)
+	}
+}
+
+// linkToken extracts the token from the last verification link sent to to.
+func linkToken(t *testing.T, verification *EmailVerification, to string) string {
+	t.Helper()
+	msg, ok := verification.Mailer.(*mail.MemoryMailer).Last(to)
+	if !ok {
+		t.Fatalf("no email sent to %s", to)
+	}
+	i := strings.Index(msg.Body, "http://api.example.org/verify-email/?")
+	if i < 0 {
+		t.Fatalf("email to %s has no verification link:\n%s", to, msg.Body)
+	}
+	link, _, _ := strings.Cut(msg.Body[i:], "\n")
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parse link %s: %v", link, err)
+	}
+	return u.Query().Get(verificationTokenParam)
+}
+
+func TestEmailVerificationFlow(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	verification := newTestVerification(t)
+	register(t, repo, verification, "newuser")
+	tok := linkToken(t, verification, "newuser@example.org")
+
+	login := func() int {
+		c, rec := newFormContext(http.MethodPost, "/log-in/", url.Values{nickname: {"newuser"}, password: {"Qwerty1123@#"}})
+		if err := Login(c, repo, testHasher, testTokens, newTestStore(t), UnverifiedBlock, newTestTwoFactor(t), newTestGuard(t)); err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		return rec.Code
+	}
+	verify := func(tok string) int {
+		c, rec := newFormContext(http.MethodGet, "/verify-email/?"+url.Values{verificationTokenParam: {tok}}.Encode(), nil)
+		if err := VerifyEmail(c, repo, verification); err != nil {
+			t.Fatalf("VerifyEmail() error = %v", err)
+		}
+		return rec.Code
+	}
+
+	if code := login(); code != http.StatusForbidden {
+		t.Errorf("Login() before verification = %d, expected %d", code, http.StatusForbidden)
+	}
+	if code := verify(tok); code != http.StatusOK {
+		t.Fatalf("VerifyEmail() = %d, expected %d", code, http.StatusOK)
+	}
+	if user, _ := repo.FindByNickname(context.Background(), "newuser"); !user.EmailVerified {
+		t.Errorf("user is not verified after VerifyEmail()")
+	}
+	if code := login(); code != http.StatusOK {
+		t.Errorf("Login() after verification = %d, expected %d", code, http.StatusOK)
+	}
+	if code := verify(tok); code != http.StatusBadRequest {
+		t.Errorf("VerifyEmail() with a used token = %d, expected %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	mr := miniredis.RunT(t)
+	repo := users.NewMemoryRepository()
+	verification := newVerificationOn(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	mailer := verification.Mailer.(*mail.MemoryMailer)
+	register(t, repo, verification, "newuser")
+	first := linkToken(t, verification, "newuser@example.org")
+
+	resend := func(address string) *http.Response {
+		c, rec := newFormContext(http.MethodPost, "/verify-email/resend/", url.Values{email: {address}})
+		if err := ResendVerification(c, repo, verification); err != nil {
+			t.Fatalf("ResendVerification(%s) error = %v", address, err)
+		}
+		return rec.Result()
+	}
+
+	if res := resend("newuser@example.org"); res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") == "" {
+		t.Errorf("resend right after registration = %d, Retry-After %q, expected 429 with Retry-After", res.StatusCode, res.Header.Get("Retry-After"))
+	}
+	mr.FastForward(time.Minute)
+	if res := resend("NewUser@example.org"); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("resend after interval = %d, expected %d", res.StatusCode, http.StatusAccepted)
+	}
+	if second := linkToken(t, verification, "newuser@example.org"); second == first {
+		t.Errorf("resend reused the first token")
+	}
+
+	sent := len(mailer.Messages())
+	if res := resend("nobody@example.org"); res.StatusCode != http.StatusAccepted {
+		t.Errorf("resend to unknown address = %d, expected %d", res.StatusCode, http.StatusAccepted)
+	}
+	if len(mailer.Messages()) != sent {
+		t.Errorf("resend to unknown address sent an email")
+	}
+
+	c, rec := newFormContext(http.MethodGet, "/verify-email/?"+url.Values{verificationTokenParam: {first}}.Encode(), nil)
+	if err := VerifyEmail(c, repo, verification); err != nil || rec.Code != http.StatusBadRequest {
+		t.Errorf("VerifyEmail() with a superseded token = %d, %v, expected %d", rec.Code, err, http.StatusBadRequest)
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	tests := []struct {
+		policy   UnverifiedPolicy
+		verified bool
+		expected int
+	}{
+		{UnverifiedAllow, false, http.StatusOK},
+		{UnverifiedLimited, false, http.StatusForbidden},
+		{UnverifiedLimited, true, http.StatusOK},
+		{UnverifiedBlock, false, http.StatusOK},
+	}
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	for _, tt := range tests {
+		c, rec := newFormContext(http.MethodPost, "/profile/edit/", url.Values{})
+		token.SetClaims(c, &token.Claims{UserNickname: "user", UserRole: users.RoleUser, EmailVerified: tt.verified})
+		if err := RequireVerifiedEmail(tt.policy)(ok)(c); err != nil {
+			ErrorHandler(err, c)
+		}
+		if rec.Code != tt.expected {
+			t.Errorf("RequireVerifiedEmail(%s) verified=%v = %d, expected %d", tt.policy, tt.verified, rec.Code, tt.expected)
+		}
+	}
+}
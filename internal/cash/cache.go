@@ -0,0 +1,216 @@
+package cash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultTTL = time.Minute
+
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+
+	profileKey     = "user-profile-%s"
+	ratingKey      = "user-rating-%s"
+	listingKey     = "/users/%d"
+	listingPageSet = "users-pages"
+	generationKey  = "cache-generation"
+)
+
+var errCircuitOpen = errors.New("cache circuit open")
+
+// Cache stores rendered responses in Redis. Each mutation method drops the
+// keys the change makes stale, so a read after a write never sees old data.
+// Listing pages are tracked in a set because a single rating change can
+// reorder every page. Every invalidation also starts a new Generation, and
+// a value loaded after a miss is only stored while the generation of that
+// miss is current: a read racing a write would otherwise put back what the
+// write just dropped.
+//
+// The cache is optional: Redis errors are logged and counted but never
+// returned, a failed Get is a miss, and after repeated failures a circuit
+// breaker skips Redis entirely until it has had time to recover.
+type Cache struct {
+	client  *redis.Client
+	ttl     time.Duration
+	breaker *Breaker
+
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	errors  atomic.Uint64
+	skipped atomic.Uint64
+}
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Errors  uint64 `json:"errors"`
+	Skipped uint64 `json:"skipped"`
+	Open    bool   `json:"circuit_open"`
+}
+
+// Generation is the state of the cache seen by Get. Its zero value, from a
+// failed Get, lets Set store nothing.
+type Generation struct {
+	value string
+}
+
+// setIfCurrent stores ARGV[2] at KEYS[1] for ARGV[3] milliseconds unless
+// the generation at KEYS[2] moved past ARGV[1]. With KEYS[3], the key is
+// also added to that set, which expires along with it.
+var setIfCurrent = redis.NewScript(`
+if (redis.call('GET', KEYS[2]) or '0') ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+if KEYS[3] then
+	redis.call('SADD', KEYS[3], KEYS[1])
+	redis.call('PEXPIRE', KEYS[3], ARGV[3])
+end
+return 1
+`)
+
+func NewCache(client *redis.Client, ttl time.Duration) *Cache {
+	return &Cache{client: client, ttl: ttl, breaker: NewBreaker(breakerThreshold, breakerCooldown)}
+}
+
+func ProfileKey(nickname string) string {
+	return fmt.Sprintf(profileKey, nickname)
+}
+
+func RatingKey(nickname string) string {
+	return fmt.Sprintf(ratingKey, nickname)
+}
+
+func ListingKey(page int64) string {
+	return fmt.Sprintf(listingKey, page)
+}
+
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Errors:  c.errors.Load(),
+		Skipped: c.skipped.Load(),
+		Open:    c.breaker.Open(),
+	}
+}
+
+// Get returns the cached value and whether it was found, along with the
+// generation to pass to Set after a miss.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, Generation, bool) {
+	var data []byte
+	var gen Generation
+	err := c.do("get "+key, func() error {
+		values, err := c.client.MGet(ctx, key, generationKey).Result()
+		if err != nil {
+			return err
+		}
+		if v, ok := values[0].(string); ok {
+			data = []byte(v)
+		}
+		gen.value = "0"
+		if v, ok := values[1].(string); ok {
+			gen.value = v
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		c.misses.Add(1)
+		return nil, gen, false
+	}
+	c.hits.Add(1)
+	return data, gen, true
+}
+
+// Set caches data loaded after a miss of Get, unless the cache was
+// invalidated since gen.
+func (c *Cache) Set(ctx context.Context, key string, gen Generation, data []byte) {
+	c.setIfCurrent(ctx, gen, data, key, generationKey)
+}
+
+// SetListing caches a page of the user listing like Set and remembers its
+// key for invalidation.
+func (c *Cache) SetListing(ctx context.Context, page int64, gen Generation, data []byte) {
+	c.setIfCurrent(ctx, gen, data, ListingKey(page), generationKey, listingPageSet)
+}
+
+// setIfCurrent runs the script of the same name on keys.
+func (c *Cache) setIfCurrent(ctx context.Context, gen Generation, data []byte, keys ...string) {
+	if gen.value == "" {
+		return
+	}
+	_ = c.do("set "+keys[0], func() error {
+		return setIfCurrent.Run(ctx, c.client, keys, gen.value, data, c.ttl.Milliseconds()).Err()
+	})
+}
+
+// ProfileChanged is called after profile fields of nickname were edited.
+func (c *Cache) ProfileChanged(ctx context.Context, nickname string) {
+	_ = c.do("invalidate profile "+nickname, func() error {
+		return c.drop(ctx, ProfileKey(nickname))
+	})
+}
+
+// RatingChanged is called after the rating of nickname changed. The profile
+// and rating entries are dropped along with every listing page.
+func (c *Cache) RatingChanged(ctx context.Context, nickname string) {
+	c.dropWithListings(ctx, ProfileKey(nickname), RatingKey(nickname))
+}
+
+// UserAdded is called after a registration; only listings include new users.
+func (c *Cache) UserAdded(ctx context.Context) {
+	c.dropWithListings(ctx)
+}
+
+// UserRemoved is called after nickname was deleted.
+func (c *Cache) UserRemoved(ctx context.Context, nickname string) {
+	c.dropWithListings(ctx, ProfileKey(nickname), RatingKey(nickname))
+}
+
+func (c *Cache) dropWithListings(ctx context.Context, keys ...string) {
+	_ = c.do("invalidate listings", func() error {
+		pages, err := c.client.SMembers(ctx, listingPageSet).Result()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, pages...)
+		keys = append(keys, listingPageSet)
+		return c.drop(ctx, keys...)
+	})
+}
+
+// drop deletes keys and starts a new generation, so that values loaded
+// before now are not stored any more.
+func (c *Cache) drop(ctx context.Context, keys ...string) error {
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, generationKey)
+		pipe.Del(ctx, keys...)
+		return nil
+	})
+	return err
+}
+
+// do runs op through the circuit breaker and records its outcome.
+func (c *Cache) do(what string, op func() error) error {
+	if !c.breaker.Allow() {
+		c.skipped.Add(1)
+		return errCircuitOpen
+	}
+	if err := op(); err != nil {
+		c.breaker.Failure()
+		c.errors.Add(1)
+		log.Warnf("cache %s: %s", what, err)
+		return err
+	}
+	c.breaker.Success()
+	return nil
+}
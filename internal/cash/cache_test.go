@@ -0,0 +1,108 @@
+package cash
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCacheDegradesWhenRedisIsDown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cache := NewCache(redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1}), DefaultTTL)
+	ctx := context.Background()
+
+	_, gen, _ := cache.Get(ctx, "k")
+	cache.Set(ctx, "k", gen, []byte("v"))
+	if data, _, ok := cache.Get(ctx, "k"); !ok || string(data) != "v" {
+		t.Fatalf("Get(k) = %s, %v", data, ok)
+	}
+
+	mr.Close()
+	for i := 0; i < breakerThreshold; i++ {
+		if _, _, ok := cache.Get(ctx, "k"); ok {
+			t.Fatalf("Get(k) hit with Redis down")
+		}
+	}
+	stats := cache.Stats()
+	if stats.Errors != breakerThreshold || !stats.Open {
+		t.Errorf("Stats() = %+v, expected %d errors and an open circuit", stats, breakerThreshold)
+	}
+
+	cache.Set(ctx, "k", gen, []byte("v"))
+	cache.RatingChanged(ctx, "someone")
+	if stats := cache.Stats(); stats.Errors != breakerThreshold || stats.Skipped != 2 {
+		t.Errorf("Stats() with open circuit = %+v, expected calls to be skipped", stats)
+	}
+}
+
+func TestCacheDropsFillsRacingInvalidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cache := NewCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}), DefaultTTL)
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		key        string
+		set        func(Generation)
+		invalidate func()
+	}{
+		{"profile edited", ProfileKey("owner"),
+			func(gen Generation) { cache.Set(ctx, ProfileKey("owner"), gen, []byte("old")) },
+			func() { cache.ProfileChanged(ctx, "owner") }},
+		{"user removed", RatingKey("owner"),
+			func(gen Generation) { cache.Set(ctx, RatingKey("owner"), gen, []byte("old")) },
+			func() { cache.UserRemoved(ctx, "owner") }},
+		{"listing reordered", ListingKey(0),
+			func(gen Generation) { cache.SetListing(ctx, 0, gen, []byte("old")) },
+			func() { cache.RatingChanged(ctx, "someone") }},
+	}
+	for _, tt := range tests {
+		// The read misses and loads the old value, the write commits and
+		// invalidates, then the read stores what it loaded.
+		_, gen, _ := cache.Get(ctx, tt.key)
+		tt.invalidate()
+		tt.set(gen)
+		if data, _, ok := cache.Get(ctx, tt.key); ok {
+			t.Errorf("%s: Get(%s) = %s after the invalidation, expected a miss", tt.name, tt.key, data)
+		}
+
+		_, gen, _ = cache.Get(ctx, tt.key)
+		tt.set(gen)
+		if _, _, ok := cache.Get(ctx, tt.key); !ok {
+			t.Errorf("%s: Get(%s) missed after a fill without invalidation", tt.name, tt.key)
+		}
+	}
+	if members, _ := mr.Members(listingPageSet); len(members) != 1 || members[0] != ListingKey(0) {
+		t.Errorf("listing pages = %v, expected %s", members, ListingKey(0))
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	if !b.Allow() {
+		t.Fatalf("Allow() = false below threshold")
+	}
+	b.Failure()
+	if b.Allow() {
+		t.Fatalf("Allow() = true with open circuit")
+	}
+
+	now = now.Add(time.Minute)
+	if !b.Allow() {
+		t.Fatalf("Allow() = false after cooldown, expected a trial call")
+	}
+	if b.Allow() {
+		t.Errorf("Allow() = true while the trial call is running")
+	}
+	b.Success()
+	if !b.Allow() || b.Open() {
+		t.Errorf("circuit not closed after a successful trial")
+	}
+}
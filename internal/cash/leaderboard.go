@@ -0,0 +1,130 @@
+package cash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const leaderboardKey = "leaderboard:rating"
+
+var ErrNotRanked = errors.New("user is not on the leaderboard")
+
+// Entry is one leaderboard position. Rank starts at 1.
+type Entry struct {
+	Nickname string
+	Rating   int
+	Rank     int64
+}
+
+// Leaderboard keeps user ratings in a Redis sorted set so that top lists and
+// ranks can be read without sorting the users collection.
+type Leaderboard struct {
+	client *redis.Client
+	key    string
+}
+
+func NewLeaderboard(client *redis.Client) *Leaderboard {
+	return &Leaderboard{client: client, key: leaderboardKey}
+}
+
+func (l *Leaderboard) Set(ctx context.Context, nickname string, rating int) error {
+	return l.client.ZAdd(ctx, l.key, redis.Z{Score: float64(rating), Member: nickname}).Err()
+}
+
+func (l *Leaderboard) Remove(ctx context.Context, nickname string) error {
+	return l.client.ZRem(ctx, l.key, nickname).Err()
+}
+
+// Top returns n entries starting at offset, highest rating first.
+func (l *Leaderboard) Top(ctx context.Context, offset, n int64) ([]Entry, error) {
+	if n <= 0 {
+		return []Entry{}, nil
+	}
+	return l.rangeFrom(ctx, offset, offset+n-1)
+}
+
+func (l *Leaderboard) Rank(ctx context.Context, nickname string) (Entry, error) {
+	rank, err := l.client.ZRevRank(ctx, l.key, nickname).Result()
+	if errors.Is(err, redis.Nil) {
+		return Entry{}, ErrNotRanked
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	score, err := l.client.ZScore(ctx, l.key, nickname).Result()
+	if errors.Is(err, redis.Nil) {
+		return Entry{}, ErrNotRanked
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{Nickname: nickname, Rating: int(score), Rank: rank + 1}, nil
+}
+
+// Around returns the user together with up to radius entries above and
+// below them.
+func (l *Leaderboard) Around(ctx context.Context, nickname string, radius int64) ([]Entry, error) {
+	rank, err := l.client.ZRevRank(ctx, l.key, nickname).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotRanked
+	}
+	if err != nil {
+		return nil, err
+	}
+	start := rank - radius
+	if start < 0 {
+		start = 0
+	}
+	return l.rangeFrom(ctx, start, rank+radius)
+}
+
+// Rebuild replaces the leaderboard with the ratings produced by next. next
+// is called until it returns an empty batch. The new set is built under a
+// temporary key and swapped in with RENAME, so readers never see a partial
+// leaderboard.
+func (l *Leaderboard) Rebuild(ctx context.Context, next func() (map[string]int, error)) (int, error) {
+	tmpKey := l.key + ":rebuild"
+	if err := l.client.Del(ctx, tmpKey).Err(); err != nil {
+		return 0, err
+	}
+	total := 0
+	for {
+		batch, err := next()
+		if err != nil {
+			l.client.Del(ctx, tmpKey)
+			return total, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		members := make([]redis.Z, 0, len(batch))
+		for nickname, rating := range batch {
+			members = append(members, redis.Z{Score: float64(rating), Member: nickname})
+		}
+		if err := l.client.ZAdd(ctx, tmpKey, members...).Err(); err != nil {
+			l.client.Del(ctx, tmpKey)
+			return total, fmt.Errorf("fill leaderboard: %w", err)
+		}
+		total += len(batch)
+	}
+	if total == 0 {
+		return 0, l.client.Del(ctx, l.key).Err()
+	}
+	return total, l.client.Rename(ctx, tmpKey, l.key).Err()
+}
+
+func (l *Leaderboard) rangeFrom(ctx context.Context, start, stop int64) ([]Entry, error) {
+	list, err := l.client.ZRevRangeWithScores(ctx, l.key, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(list))
+	for i, z := range list {
+		member, _ := z.Member.(string)
+		entries = append(entries, Entry{Nickname: member, Rating: int(z.Score), Rank: start + int64(i) + 1})
+	}
+	return entries, nil
+}
@@ -0,0 +1,114 @@
+package cash
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestLeaderboard(t *testing.T) *Leaderboard {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	l := NewLeaderboard(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	ratings := map[string]int{"a": 10, "b": 8, "c": 5, "d": 3, "e": -1}
+	for nick, rating := range ratings {
+		if err := l.Set(context.Background(), nick, rating); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return l
+}
+
+func nicknames(entries []Entry) []string {
+	result := make([]string, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e.Nickname)
+	}
+	return result
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLeaderboardTop(t *testing.T) {
+	l := newTestLeaderboard(t)
+	top, err := l.Top(context.Background(), 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nicknames(top); !equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("Top(3) = %v", got)
+	}
+	if top[2].Rank != 3 || top[2].Rating != 5 {
+		t.Errorf("Top(3)[2] = %+v", top[2])
+	}
+}
+
+func TestLeaderboardRank(t *testing.T) {
+	l := newTestLeaderboard(t)
+	ctx := context.Background()
+
+	tests := map[string]int64{"a": 1, "c": 3, "e": 5}
+	for nick, expected := range tests {
+		entry, err := l.Rank(ctx, nick)
+		if err != nil || entry.Rank != expected {
+			t.Errorf("Rank(%s) = %+v, %v, expected %d", nick, entry, err, expected)
+		}
+	}
+	if _, err := l.Rank(ctx, "missing"); !errors.Is(err, ErrNotRanked) {
+		t.Errorf("Rank(missing) error = %v, expected %v", err, ErrNotRanked)
+	}
+
+	_ = l.Set(ctx, "e", 20)
+	if entry, _ := l.Rank(ctx, "e"); entry.Rank != 1 {
+		t.Errorf("Rank(e) after update = %d, expected 1", entry.Rank)
+	}
+	_ = l.Remove(ctx, "e")
+	if _, err := l.Rank(ctx, "e"); !errors.Is(err, ErrNotRanked) {
+		t.Errorf("Rank(e) after remove error = %v", err)
+	}
+}
+
+func TestLeaderboardAround(t *testing.T) {
+	l := newTestLeaderboard(t)
+	tests := map[string][]string{
+		"c": {"b", "c", "d"},
+		"a": {"a", "b"},
+		"e": {"d", "e"},
+	}
+	for nick, expected := range tests {
+		list, err := l.Around(context.Background(), nick, 1)
+		if err != nil || !equal(nicknames(list), expected) {
+			t.Errorf("Around(%s, 1) = %v, %v, expected %v", nick, nicknames(list), err, expected)
+		}
+	}
+}
+
+func TestLeaderboardRebuild(t *testing.T) {
+	l := newTestLeaderboard(t)
+	batches := []map[string]int{{"x": 1, "y": 2}, {"z": 3}, {}}
+	n, err := l.Rebuild(context.Background(), func() (map[string]int, error) {
+		b := batches[0]
+		batches = batches[1:]
+		return b, nil
+	})
+	if err != nil || n != 3 {
+		t.Fatalf("Rebuild() = %d, %v", n, err)
+	}
+	top, _ := l.Top(context.Background(), 0, 10)
+	if got := nicknames(top); !equal(got, []string{"z", "y", "x"}) {
+		t.Errorf("Top() after rebuild = %v", got)
+	}
+}
@@ -1,29 +1,30 @@
 package cash
 
 import (
-	"os"
-	"strconv"
+	"context"
+	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
 )
 
-func InitRedis() (*redis.Client, error) {
-	err := godotenv.Load(".env")
-	if err != nil {
-		return nil, err
-	}
-
-	i, err := strconv.Atoi(os.Getenv("redis_db"))
-	if err != nil {
-		return nil, err
-	}
+const pingTimeout = 2 * time.Second
 
+// InitRedis creates the Redis client. An unreachable server is only logged:
+// the client reconnects on its own and the cache degrades to misses until
+// Redis is back.
+func InitRedis(addr, password string, db int) *redis.Client {
 	client := redis.NewClient(&redis.Options{
-		Addr:     os.Getenv("redis_addr"),
-		Password: os.Getenv("redis_pass"),
-		DB:       i,
+		Addr:     addr,
+		Password: password,
+		DB:       db,
 	})
 
-	return client, nil
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		log.Warnf("redis is not reachable at %s, continuing without cache: %s", addr, err)
+	}
+
+	return client
 }
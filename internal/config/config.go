@@ -0,0 +1,518 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesomeProject/internal/hash"
+	"awesomeProject/internal/ratelimit"
+	"awesomeProject/internal/token"
+	"awesomeProject/internal/validation"
+
+	"github.com/joho/godotenv"
+)
+
+const defaultFile = ".env"
+
+type Config struct {
+	Port           string
+	Mongo          MongoConfig
+	Redis          RedisConfig
+	JWT            JWTConfig
+	CacheTTL       time.Duration
+	PageSize       int64
+	PasswordHasher string
+	// PublicURL is where clients reach the API; links in emails start with it.
+	PublicURL    string
+	Mail         MailConfig
+	Verification VerificationConfig
+	Reset        ResetConfig
+	TwoFactor    TwoFactorConfig
+	Login        LoginConfig
+	RateLimit    RateLimitConfig
+	Purge        PurgeConfig
+	OAuth        OAuthConfig
+	// Validation holds the rules for names, nicknames and passwords of new
+	// and edited users.
+	Validation validation.Policy
+}
+
+type MongoConfig struct {
+	URI      string
+	Database string
+}
+
+type RedisConfig struct {
+	Addr     string
+	Password string
+	DB       int
+}
+
+type MailConfig struct {
+	// Driver is smtp, or file to write messages into Dir instead.
+	Driver       string
+	Dir          string
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUser     string
+	SMTPPassword string
+}
+
+type VerificationConfig struct {
+	// Policy is allow, limited or block; see api.UnverifiedPolicy.
+	Policy         string
+	TokenTTL       time.Duration
+	ResendInterval time.Duration
+}
+
+type ResetConfig struct {
+	TokenTTL        time.Duration
+	RequestInterval time.Duration
+}
+
+type TwoFactorConfig struct {
+	// Issuer names the service in authenticator apps.
+	Issuer       string
+	ChallengeTTL time.Duration
+}
+
+// LoginConfig tunes the brute-force protection of logins.
+type LoginConfig struct {
+	MaxFailures   int
+	MaxIPFailures int
+	Lockout       time.Duration
+	MaxLockout    time.Duration
+	FailureWindow time.Duration
+}
+
+type RateLimitConfig struct {
+	// Store is redis, shared by all instances with an in-memory fallback,
+	// or memory for a single instance.
+	Store string
+	// Policies maps route policy names to their limits. The "default"
+	// policy applies to every request.
+	Policies map[string]ratelimit.Limit
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For names
+	// the client. Without any, clients are told apart by the connection.
+	TrustedProxies []*net.IPNet
+}
+
+// PurgeConfig controls the removal of soft-deleted accounts.
+type PurgeConfig struct {
+	// Retention is how long deleted accounts can still be restored.
+	Retention time.Duration
+	Interval  time.Duration
+}
+
+// OAuthConfig tunes the OpenID Connect provider.
+type OAuthConfig struct {
+	// CodeTTL is how long a client has to exchange an authorization code.
+	CodeTTL time.Duration
+}
+
+type JWTConfig struct {
+	// Secret is the HMAC key, read from the secret setting or from the file
+	// named by the key file setting. It signs one-time tokens, and access
+	// tokens too unless a signing key is configured.
+	Secret []byte
+	// Keys sign and verify access tokens.
+	Keys *token.KeySet
+	// Issuer and Audience are the iss and aud claims of access tokens.
+	Issuer     string
+	Audience   string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// setting is one configuration value. It can come from the config file or
+// the environment under env, or from the command line as -flag.
+type setting struct {
+	env   string
+	flag  string
+	def   string
+	usage string
+}
+
+var settings = []setting{
+	{"port", "port", "8080", "HTTP port to listen on"},
+	{"mongo_uri", "mongo-uri", "", "full MongoDB connection URI; overrides the db_* parts"},
+	{"mongo_scheme", "mongo-scheme", "mongodb+srv", "MongoDB URI scheme used with the db_* parts"},
+	{"db_account", "db-account", "", "MongoDB user"},
+	{"db_password", "db-password", "", "MongoDB password"},
+	{"db_url", "db-url", "", "MongoDB host"},
+	{"db_name", "db-name", "api_users", "MongoDB database name"},
+	{"redis_addr", "redis-addr", "localhost:6379", "Redis address"},
+	{"redis_pass", "redis-password", "", "Redis password"},
+	{"redis_db", "redis-db", "0", "Redis database number"},
+	{"jwt_secret", "jwt-secret", "", "HMAC secret used to sign tokens"},
+	{"jwt_key_file", "jwt-key-file", "", "file holding the HMAC secret; used when jwt_secret is empty"},
+	{"jwt_issuer", "jwt-issuer", "", "iss claim of access tokens; defaults to public_url"},
+	{"jwt_audience", "jwt-audience", "users-api", "aud claim of access tokens, which every verifying service must expect"},
+	{"jwt_signing_key", "jwt-signing-key", "", "PEM private key (RSA, ECDSA or Ed25519) signing access tokens instead of the HMAC secret"},
+	{"jwt_verification_keys", "jwt-verification-keys", "",
+		"PEM keys of earlier signing keys whose tokens are still accepted, comma separated"},
+	{"access_token_ttl", "access-token-ttl", "15m", "access token lifetime"},
+	{"refresh_token_ttl", "refresh-token-ttl", "720h", "refresh token lifetime"},
+	{"cache_ttl", "cache-ttl", "1m", "lifetime of cached responses"},
+	{"page_size", "page-size", "3", "users per page in /users listings"},
+	{"password_hasher", "password-hasher", "argon2id", "algorithm for new password hashes: argon2id or bcrypt"},
+	{"public_url", "public-url", "http://localhost:8080", "external base URL used in links sent by email"},
+	{"mailer", "mailer", "file", "how to deliver email: smtp, or file to write it into mail_dir"},
+	{"mail_dir", "mail-dir", "mail", "directory for the file mailer"},
+	{"mail_from", "mail-from", "no-reply@localhost", "sender address of outgoing email"},
+	{"smtp_host", "smtp-host", "", "SMTP server host"},
+	{"smtp_port", "smtp-port", "587", "SMTP server port"},
+	{"smtp_user", "smtp-user", "", "SMTP user; empty disables authentication"},
+	{"smtp_password", "smtp-password", "", "SMTP password"},
+	{"unverified_policy", "unverified-policy", "limited", "what unverified accounts may do: allow, limited or block"},
+	{"verify_token_ttl", "verify-token-ttl", "24h", "lifetime of email verification links"},
+	{"verify_resend_interval", "verify-resend-interval", "5m", "minimum time between verification emails to one address"},
+	{"reset_token_ttl", "reset-token-ttl", "1h", "lifetime of password reset links"},
+	{"totp_issuer", "totp-issuer", "API Users", "service name shown in authenticator apps"},
+	{"login_challenge_ttl", "login-challenge-ttl", "5m", "time to enter the second factor after the password"},
+	{"login_max_failures", "login-max-failures", "5", "failed logins of one nickname before it is locked"},
+	{"login_ip_max_failures", "login-ip-max-failures", "20", "failed logins from one address before it is locked"},
+	{"login_lockout", "login-lockout", "1m", "first lockout; doubles with every further failure"},
+	{"login_max_lockout", "login-max-lockout", "1h", "longest lockout"},
+	{"login_failure_window", "login-failure-window", "24h", "how long failed logins are remembered"},
+	{"rate_limit_store", "rate-limit-store", "redis", "where request counters live: redis or memory"},
+	{"rate_limits", "rate-limits", "default=300/1m,register=5/1h,login=10/1m,recovery=5/15m,vote=30/1m",
+		"request limits per route policy as name=requests/window, comma separated"},
+	{"trusted_proxies", "trusted-proxies", "", "addresses or CIDR ranges of reverse proxies whose X-Forwarded-For is believed, comma separated"},
+	{"reset_request_interval", "reset-request-interval", "5m", "minimum time between password reset emails to one address"},
+	{"name_min_length", "name-min-length", "2", "shortest allowed first or last name"},
+	{"name_max_length", "name-max-length", "32", "longest allowed first or last name"},
+	{"nickname_min_length", "nickname-min-length", "4", "shortest allowed nickname"},
+	{"nickname_max_length", "nickname-max-length", "12", "longest allowed nickname"},
+	{"reserved_nicknames", "reserved-nicknames", strings.Join(validation.DefaultReservedNicknames, ","),
+		"nicknames nobody can register, comma separated; look-alikes are refused too"},
+	{"password_min_length", "password-min-length", "8", "shortest allowed password"},
+	{"password_max_length", "password-max-length", "64", "longest allowed password"},
+	{"password_require", "password-require", "", "character classes every password needs: upper, lower, digit, special, comma separated"},
+	{"password_min_entropy", "password-min-entropy", "40", "lowest estimated password strength in bits; 0 disables the check"},
+	{"breached_passwords", "breached-passwords", "", "SHA-1 hash file or hash-prefix directory of leaked passwords to refuse"},
+	{"deleted_retention", "deleted-retention", "720h", "how long deleted accounts can be restored before they are purged"},
+	{"purge_interval", "purge-interval", "1h", "how often deleted accounts past retention are purged"},
+	{"oauth_code_ttl", "oauth-code-ttl", "1m", "time an OAuth client has to exchange an authorization code"},
+}
+
+// Load builds the configuration from, in increasing priority: defaults, the
+// config file, the environment and the command line. Its flags are added to
+// fs, so callers can register their own flags on the same set first. Every
+// invalid setting is reported, not only the first one.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	file := fs.String("config", "", "optional file with env style settings (default .env if present)")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.env] = fs.String(s.flag, "", fmt.Sprintf("%s (env %s, default %q)", s.usage, s.env, s.def))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(settings))
+	for _, s := range settings {
+		values[s.env] = s.def
+	}
+
+	path, required := *file, true
+	if path == "" {
+		path, required = defaultFile, false
+	}
+	fileValues, err := godotenv.Read(path)
+	if err != nil && (required || !errors.Is(err, os.ErrNotExist)) {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	for k, v := range fileValues {
+		if _, known := values[k]; known {
+			values[k] = v
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			values[s.env] = v
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				values[s.env] = *flagValues[s.env]
+			}
+		}
+	})
+
+	return parse(values)
+}
+
+func parse(values map[string]string) (*Config, error) {
+	var errs []error
+	invalid := func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+	duration := func(key string) time.Duration {
+		d, err := time.ParseDuration(values[key])
+		if err != nil || d <= 0 {
+			invalid(key, "must be a positive duration like 15m, got %q", values[key])
+		}
+		return d
+	}
+
+	positive := func(key string) int {
+		n, err := strconv.Atoi(values[key])
+		if err != nil || n < 1 {
+			invalid(key, "must be a positive number, got %q", values[key])
+		}
+		return n
+	}
+
+	cfg := &Config{
+		Port:           values["port"],
+		CacheTTL:       duration("cache_ttl"),
+		PasswordHasher: values["password_hasher"],
+		PublicURL:      values["public_url"],
+		Mail: MailConfig{
+			Driver:       values["mailer"],
+			Dir:          values["mail_dir"],
+			From:         values["mail_from"],
+			SMTPHost:     values["smtp_host"],
+			SMTPUser:     values["smtp_user"],
+			SMTPPassword: values["smtp_password"],
+		},
+		Verification: VerificationConfig{
+			Policy:         values["unverified_policy"],
+			TokenTTL:       duration("verify_token_ttl"),
+			ResendInterval: duration("verify_resend_interval"),
+		},
+		TwoFactor: TwoFactorConfig{
+			Issuer:       values["totp_issuer"],
+			ChallengeTTL: duration("login_challenge_ttl"),
+		},
+		Login: LoginConfig{
+			Lockout:       duration("login_lockout"),
+			MaxLockout:    duration("login_max_lockout"),
+			FailureWindow: duration("login_failure_window"),
+		},
+		Reset: ResetConfig{
+			TokenTTL:        duration("reset_token_ttl"),
+			RequestInterval: duration("reset_request_interval"),
+		},
+		Purge: PurgeConfig{
+			Retention: duration("deleted_retention"),
+			Interval:  duration("purge_interval"),
+		},
+		OAuth: OAuthConfig{CodeTTL: duration("oauth_code_ttl")},
+		Mongo: MongoConfig{Database: values["db_name"]},
+		Redis: RedisConfig{Addr: values["redis_addr"], Password: values["redis_pass"]},
+		JWT: JWTConfig{
+			AccessTTL:  duration("access_token_ttl"),
+			RefreshTTL: duration("refresh_token_ttl"),
+		},
+	}
+
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		invalid("port", "must be a number between 1 and 65535, got %q", cfg.Port)
+	}
+
+	cfg.Mongo.URI = values["mongo_uri"]
+	if cfg.Mongo.URI == "" {
+		if values["db_url"] == "" {
+			invalid("mongo_uri", "set mongo_uri or db_url")
+		} else {
+			u := url.URL{Scheme: values["mongo_scheme"], Host: values["db_url"]}
+			if values["db_account"] != "" {
+				u.User = url.UserPassword(values["db_account"], values["db_password"])
+			}
+			cfg.Mongo.URI = u.String()
+		}
+	} else if !strings.HasPrefix(cfg.Mongo.URI, "mongodb://") && !strings.HasPrefix(cfg.Mongo.URI, "mongodb+srv://") {
+		invalid("mongo_uri", "must start with mongodb:// or mongodb+srv://")
+	}
+	if cfg.Mongo.Database == "" {
+		invalid("db_name", "must not be empty")
+	}
+
+	if cfg.Redis.Addr == "" {
+		invalid("redis_addr", "must not be empty")
+	}
+	db, err := strconv.Atoi(values["redis_db"])
+	if err != nil || db < 0 {
+		invalid("redis_db", "must be a non-negative number, got %q", values["redis_db"])
+	}
+	cfg.Redis.DB = db
+
+	switch {
+	case values["jwt_secret"] != "":
+		cfg.JWT.Secret = []byte(values["jwt_secret"])
+	case values["jwt_key_file"] != "":
+		raw, err := os.ReadFile(values["jwt_key_file"])
+		if err != nil {
+			invalid("jwt_key_file", "%s", err)
+		}
+		cfg.JWT.Secret = []byte(strings.TrimSpace(string(raw)))
+	default:
+		invalid("jwt_secret", "set jwt_secret or jwt_key_file")
+	}
+	if cfg.JWT.Secret != nil && len(cfg.JWT.Secret) < 32 {
+		invalid("jwt_secret", "must be at least 32 bytes long")
+	}
+	cfg.JWT.Issuer, cfg.JWT.Audience = values["jwt_issuer"], values["jwt_audience"]
+	if cfg.JWT.Issuer == "" {
+		cfg.JWT.Issuer = cfg.PublicURL
+	}
+	if cfg.JWT.Audience == "" {
+		invalid("jwt_audience", "must not be empty")
+	}
+	cfg.JWT.Keys = token.HMACKeys(cfg.JWT.Secret)
+	if path := values["jwt_signing_key"]; path != "" {
+		var verify []*token.Key
+		for _, p := range strings.Split(values["jwt_verification_keys"], ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			if key, err := token.LoadKey(p); err != nil {
+				invalid("jwt_verification_keys", "%s", err)
+			} else {
+				verify = append(verify, key)
+			}
+		}
+		signing, err := token.LoadKey(path)
+		if err == nil {
+			cfg.JWT.Keys, err = token.NewKeySet(signing, verify...)
+		}
+		if err != nil {
+			invalid("jwt_signing_key", "%s", err)
+		}
+	} else if values["jwt_verification_keys"] != "" {
+		invalid("jwt_verification_keys", "needs jwt_signing_key")
+	}
+	if cfg.JWT.AccessTTL > 0 && cfg.JWT.RefreshTTL > 0 && cfg.JWT.AccessTTL >= cfg.JWT.RefreshTTL {
+		invalid("access_token_ttl", "must be shorter than refresh_token_ttl")
+	}
+
+	pageSize, err := strconv.ParseInt(values["page_size"], 10, 64)
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		invalid("page_size", "must be a number between 1 and 100, got %q", values["page_size"])
+	}
+	cfg.PageSize = pageSize
+
+	if cfg.PasswordHasher != "argon2id" && cfg.PasswordHasher != "bcrypt" {
+		invalid("password_hasher", "must be argon2id or bcrypt, got %q", cfg.PasswordHasher)
+	}
+
+	if u, err := url.Parse(cfg.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		invalid("public_url", "must be an absolute http or https URL, got %q", cfg.PublicURL)
+	}
+	switch cfg.Mail.Driver {
+	case "smtp":
+		if cfg.Mail.SMTPHost == "" {
+			invalid("smtp_host", "is required with the smtp mailer")
+		}
+	case "file":
+		if cfg.Mail.Dir == "" {
+			invalid("mail_dir", "is required with the file mailer")
+		}
+	default:
+		invalid("mailer", "must be smtp or file, got %q", cfg.Mail.Driver)
+	}
+	smtpPort, err := strconv.Atoi(values["smtp_port"])
+	if err != nil || smtpPort < 1 || smtpPort > 65535 {
+		invalid("smtp_port", "must be a number between 1 and 65535, got %q", values["smtp_port"])
+	}
+	cfg.Mail.SMTPPort = smtpPort
+	cfg.Login.MaxFailures = positive("login_max_failures")
+	cfg.Login.MaxIPFailures = positive("login_ip_max_failures")
+	if cfg.Login.Lockout > cfg.Login.MaxLockout {
+		invalid("login_lockout", "must not be longer than login_max_lockout")
+	}
+
+	cfg.Validation = validation.Policy{
+		NameMinLength:     positive("name_min_length"),
+		NameMaxLength:     positive("name_max_length"),
+		NicknameMinLength: positive("nickname_min_length"),
+		NicknameMaxLength: positive("nickname_max_length"),
+		Password: validation.PasswordPolicy{
+			MinLength: positive("password_min_length"),
+			MaxLength: positive("password_max_length"),
+		},
+	}
+	if cfg.Validation.NameMinLength > cfg.Validation.NameMaxLength {
+		invalid("name_min_length", "must not be greater than name_max_length")
+	}
+	if cfg.Validation.NicknameMinLength > cfg.Validation.NicknameMaxLength {
+		invalid("nickname_min_length", "must not be greater than nickname_max_length")
+	}
+	for _, nick := range strings.Split(values["reserved_nicknames"], ",") {
+		if nick = strings.TrimSpace(nick); nick != "" {
+			cfg.Validation.ReservedNicknames = append(cfg.Validation.ReservedNicknames, nick)
+		}
+	}
+	password := &cfg.Validation.Password
+	if password.MinLength > password.MaxLength {
+		invalid("password_min_length", "must not be greater than password_max_length")
+	}
+	// bcrypt only hashes the first 72 bytes of a password, which may be as
+	// few as 18 characters, so the bytes are limited as well.
+	if cfg.PasswordHasher == "bcrypt" {
+		if password.MaxLength > hash.BcryptMaxBytes {
+			invalid("password_max_length", "must be at most %d with the bcrypt hasher", hash.BcryptMaxBytes)
+		}
+		password.MaxBytes = hash.BcryptMaxBytes
+	}
+	for _, class := range strings.Split(values["password_require"], ",") {
+		switch strings.TrimSpace(class) {
+		case "":
+		case "upper":
+			password.RequireUpper = true
+		case "lower":
+			password.RequireLower = true
+		case "digit":
+			password.RequireDigit = true
+		case "special":
+			password.RequireSpecial = true
+		default:
+			invalid("password_require", "unknown character class %q", class)
+		}
+	}
+	if password.MinEntropy, err = strconv.ParseFloat(values["password_min_entropy"], 64); err != nil || password.MinEntropy < 0 {
+		invalid("password_min_entropy", "must be a non-negative number, got %q", values["password_min_entropy"])
+	}
+	if path := values["breached_passwords"]; path != "" {
+		if password.Breached, err = validation.LoadBreachedPasswords(path); err != nil {
+			invalid("breached_passwords", "%s", err)
+		}
+	}
+
+	cfg.RateLimit.Store = values["rate_limit_store"]
+	if cfg.RateLimit.Store != "redis" && cfg.RateLimit.Store != "memory" {
+		invalid("rate_limit_store", "must be redis or memory, got %q", cfg.RateLimit.Store)
+	}
+	if cfg.RateLimit.Policies, err = ratelimit.ParsePolicies(values["rate_limits"]); err != nil {
+		invalid("rate_limits", "%s", err)
+	}
+	if cfg.RateLimit.TrustedProxies, err = ratelimit.ParseTrustedProxies(values["trusted_proxies"]); err != nil {
+		invalid("trusted_proxies", "%s", err)
+	}
+
+	if cfg.TwoFactor.Issuer == "" || strings.Contains(cfg.TwoFactor.Issuer, ":") {
+		invalid("totp_issuer", "must be non-empty and must not contain a colon")
+	}
+	switch cfg.Verification.Policy {
+	case "allow", "limited", "block":
+	default:
+		invalid("unverified_policy", "must be allow, limited or block, got %q", cfg.Verification.Policy)
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return cfg, nil
+}
@@ -2,9 +2,13 @@ package hash
 
 import (
 	"crypto/md5"
+	"crypto/subtle"
 	"encoding/hex"
 )
 
+// HashString returns the unsalted MD5 hex digest of s. It is kept only to
+// recognise passwords stored before the salted hashers were introduced; use
+// PasswordHasher for anything new.
 func HashString(s string) string {
 	hasher := md5.New()
 	hasher.Write([]byte(s))
@@ -13,3 +17,30 @@ func HashString(s string) string {
 
 	return hashString
 }
+
+// LegacyMD5 verifies hashes produced by HashString. It refuses to create new
+// ones.
+type LegacyMD5 struct{}
+
+func (LegacyMD5) Hash(string) (string, error) {
+	return "", ErrHashNotSupported
+}
+
+func (LegacyMD5) Identify(encoded string) bool {
+	if len(encoded) != md5.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(encoded)
+	return err == nil
+}
+
+func (m LegacyMD5) Verify(password, encoded string) (bool, error) {
+	if !m.Identify(encoded) {
+		return false, ErrUnknownHash
+	}
+	return subtle.ConstantTimeCompare([]byte(HashString(password)), []byte(encoded)) == 1, nil
+}
+
+func (LegacyMD5) NeedsRehash(string) bool {
+	return true
+}
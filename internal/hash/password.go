@@ -0,0 +1,79 @@
+package hash
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownHash      = errors.New("unknown password hash format")
+	ErrHashNotSupported = errors.New("hashing is not supported by this algorithm")
+)
+
+// Hasher is one password hashing algorithm. Encoded hashes are
+// self-describing: they carry the algorithm, its parameters and the salt.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded using a constant-time
+	// comparison. It returns ErrUnknownHash when encoded does not belong to
+	// this algorithm.
+	Verify(password, encoded string) (bool, error)
+	// Identify reports whether encoded was produced by this algorithm.
+	Identify(encoded string) bool
+	// NeedsRehash reports whether encoded was made with weaker parameters
+	// than the hasher currently uses.
+	NeedsRehash(encoded string) bool
+}
+
+// PasswordHasher hashes new passwords with the current algorithm and still
+// accepts hashes made by the legacy ones, so stored passwords can be
+// upgraded the next time their owner logs in.
+type PasswordHasher struct {
+	current Hasher
+	legacy  []Hasher
+}
+
+func NewPasswordHasher(current Hasher, legacy ...Hasher) *PasswordHasher {
+	return &PasswordHasher{current: current, legacy: legacy}
+}
+
+// Default hashes new passwords with the algorithm registered under name and
+// accepts argon2id, bcrypt and legacy MD5 hashes.
+func Default(name string) (*PasswordHasher, error) {
+	current, err := New(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewPasswordHasher(current, NewArgon2id(), NewBcrypt(), LegacyMD5{}), nil
+}
+
+func (p *PasswordHasher) Hash(password string) (string, error) {
+	return p.current.Hash(password)
+}
+
+// Verify checks password against encoded. rehash is true when the password
+// matched but encoded should be replaced with a fresh Hash.
+func (p *PasswordHasher) Verify(password, encoded string) (ok, rehash bool, err error) {
+	if p.current.Identify(encoded) {
+		ok, err = p.current.Verify(password, encoded)
+		return ok, ok && p.current.NeedsRehash(encoded), err
+	}
+	for _, h := range p.legacy {
+		if h.Identify(encoded) {
+			ok, err = h.Verify(password, encoded)
+			return ok, ok, err
+		}
+	}
+	return false, false, ErrUnknownHash
+}
+
+// New returns the Hasher registered under name.
+func New(name string) (Hasher, error) {
+	switch name {
+	case "", "argon2id":
+		return NewArgon2id(), nil
+	case "bcrypt":
+		return NewBcrypt(), nil
+	}
+	return nil, fmt.Errorf("unknown password hasher %q", name)
+}
@@ -0,0 +1,86 @@
+package hash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func fastArgon2id() *Argon2idHasher {
+	return &Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func TestHashersRoundTrip(t *testing.T) {
+	hashers := map[string]Hasher{
+		"argon2id": fastArgon2id(),
+		"bcrypt":   &BcryptHasher{Cost: 4},
+	}
+	for name, h := range hashers {
+		encoded, err := h.Hash("Qwerty1123@#")
+		if err != nil {
+			t.Fatalf("%s: Hash() error = %v", name, err)
+		}
+		if !h.Identify(encoded) {
+			t.Errorf("%s: Identify(%s) = false", name, encoded)
+		}
+		other, _ := h.Hash("Qwerty1123@#")
+		if other == encoded {
+			t.Errorf("%s: two hashes of the same password are equal, salt is missing", name)
+		}
+
+		tests := map[string]bool{
+			"Qwerty1123@#": true,
+			"qwerty1123@#": false,
+			"":             false,
+		}
+		for input, expected := range tests {
+			result, err := h.Verify(input, encoded)
+			if err != nil || result != expected {
+				t.Errorf("%s: Verify(%s) = %v, %v, expected %v", name, input, result, err, expected)
+			}
+		}
+	}
+}
+
+func TestArgon2idEncoding(t *testing.T) {
+	encoded, err := fastArgon2id().Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Hash() = %s, expected PHC encoding with parameters", encoded)
+	}
+	if !NewArgon2id().NeedsRehash(encoded) {
+		t.Errorf("NeedsRehash() = false for weaker parameters")
+	}
+	if fastArgon2id().NeedsRehash(encoded) {
+		t.Errorf("NeedsRehash() = true for current parameters")
+	}
+}
+
+func TestPasswordHasherVerify(t *testing.T) {
+	p := NewPasswordHasher(fastArgon2id(), &BcryptHasher{Cost: 4}, LegacyMD5{})
+	current, _ := p.Hash("secret")
+	bcryptHash, _ := (&BcryptHasher{Cost: 4}).Hash("secret")
+
+	tests := []struct {
+		encoded, password string
+		ok, rehash        bool
+	}{
+		{current, "secret", true, false},
+		{current, "wrong", false, false},
+		{bcryptHash, "secret", true, true},
+		{HashString("secret"), "secret", true, true},
+		{HashString("secret"), "wrong", false, false},
+	}
+	for _, tt := range tests {
+		ok, rehash, err := p.Verify(tt.password, tt.encoded)
+		if err != nil || ok != tt.ok || rehash != tt.rehash {
+			t.Errorf("Verify(%s, %s) = %v, %v, %v, expected %v, %v", tt.password, tt.encoded, ok, rehash, err, tt.ok, tt.rehash)
+		}
+	}
+
+	if _, _, err := p.Verify("secret", "plain-text"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("Verify(unknown) error = %v, expected %v", err, ErrUnknownHash)
+	}
+}
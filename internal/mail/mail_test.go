@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir, "no-reply@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(context.Background(), Message{To: "a@example.org", Subject: "Hi", Body: "line1\nline2"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("Send() wrote %d files, expected 1", len(entries))
+	}
+	raw, _ := os.ReadFile(dir + "/" + entries[0].Name())
+	for _, want := range []string{"From: no-reply@example.org\r\n", "To: a@example.org\r\n", "Subject: Hi\r\n", "\r\n\r\nline1\r\nline2"} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("written mail does not contain %q:\n%s", want, raw)
+		}
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	tests := map[string]Message{
+		"to":      {To: "a@example.org\r\nBcc: b@example.org", Subject: "Hi"},
+		"subject": {To: "a@example.org", Subject: "Hi\nBcc: b@example.org"},
+	}
+	m, _ := NewFileMailer(t.TempDir(), "no-reply@example.org")
+	for name, msg := range tests {
+		if err := m.Send(context.Background(), msg); err == nil {
+			t.Errorf("Send() with a line break in %s succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesomeProject/internal/token"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// KeyFunc names the client a request is counted for.
+type KeyFunc func(c echo.Context) string
+
+// ClientKey counts requests made with an API key per key, other
+// authenticated requests per nickname and anonymous ones per client IP.
+// Claims are only present when the middleware runs after
+// token.JwtMiddleware, so on public routes every request is keyed by IP.
+func ClientKey(c echo.Context) string {
+	if claims := token.ClaimsFromContext(c); claims != nil {
+		if claims.APIKeyID != "" {
+			return "key:" + claims.APIKeyID
+		}
+		return "user:" + claims.UserNickname
+	}
+	return "ip:" + c.RealIP()
+}
+
+// IPExtractor decides what Echo's RealIP returns. Without trusted proxies
+// it is the address of the connection; otherwise it is the last address in
+// X-Forwarded-For that none of the trusted proxies added. Forwarding
+// headers sent by clients themselves are never believed, or anyone could
+// get a fresh rate limit bucket per request.
+func IPExtractor(trusted []*net.IPNet) echo.IPExtractor {
+	if len(trusted) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, n := range trusted {
+		options = append(options, echo.TrustIPRange(n))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// ParseTrustedProxies reads comma separated CIDR ranges or single addresses.
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("proxy %q: expected an address or CIDR range", part)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			part = fmt.Sprintf("%s/%d", part, bits)
+		}
+		_, n, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %q: expected an address or CIDR range", part)
+		}
+		ranges = append(ranges, n)
+	}
+	return ranges, nil
+}
+
+// Middleware enforces limit for the policy name and sets the RateLimit-*
+// headers of the IETF draft on every response, and Retry-After on 429s.
+// When the limiter fails the request is let through.
+func Middleware(limiter Limiter, name string, limit Limit, key KeyFunc) echo.MiddlewareFunc {
+	policy := fmt.Sprintf("%d;w=%d", limit.Requests, int64(limit.Window/time.Second))
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			res, err := limiter.Allow(c.Request().Context(), name+":"+key(c), limit)
+			if err != nil {
+				log.Errorf("rate limit %s: %s", name, err)
+				return next(c)
+			}
+			h := c.Response().Header()
+			reset := strconv.Itoa(seconds(res.Reset))
+			h.Set("RateLimit-Policy", policy)
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", reset)
+			if !res.Allowed {
+				h.Set("Retry-After", reset)
+				return echo.NewHTTPError(http.StatusTooManyRequests, "rate limit exceeded")
+			}
+			return next(c)
+		}
+	}
+}
+
+// seconds rounds up, so clients never retry too early.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
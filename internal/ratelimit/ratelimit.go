@@ -0,0 +1,222 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/internal/cash"
+
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+const keyPrefix = "rate:"
+
+// Limit allows Requests per sliding Window.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Window)
+}
+
+// Result describes the state of a key after a request was counted.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is when the oldest counted request leaves the window, which is
+	// also when a rejected client may retry.
+	Reset time.Duration
+}
+
+// Limiter counts requests per key in a sliding window.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// slidingWindow drops requests older than the window, then counts the new
+// one if there is room. Scores are milliseconds.
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+local reset = window
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, count, reset}
+`)
+
+// RedisLimiter shares its counters between all instances of the service.
+type RedisLimiter struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+func NewRedisLimiter(client *redis.Client) *RedisLimiter {
+	return &RedisLimiter{client: client, now: time.Now}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	member := make([]byte, 8)
+	if _, err := rand.Read(member); err != nil {
+		return Result{}, fmt.Errorf("random: %w", err)
+	}
+	reply, err := slidingWindow.Run(ctx, l.client, []string{keyPrefix + key},
+		l.now().UnixMilli(), limit.Window.Milliseconds(), limit.Requests, hex.EncodeToString(member)).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("rate limit %s: %w", key, err)
+	}
+	if len(reply) != 3 {
+		return Result{}, fmt.Errorf("rate limit %s: unexpected reply %v", key, reply)
+	}
+	return result(reply[0] == 1, int(reply[1]), limit, time.Duration(reply[2])*time.Millisecond), nil
+}
+
+// MemoryLimiter keeps counters in the process. It is meant for single
+// instance deployments and as a fallback while Redis is unavailable.
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	windows map[string]*window
+	calls   int
+	now     func() time.Time
+}
+
+type window struct {
+	times  []time.Time
+	length time.Duration
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{windows: make(map[string]*window), now: time.Now}
+}
+
+func (l *MemoryLimiter) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.calls++
+	if l.calls%1000 == 0 {
+		l.sweep(now)
+	}
+
+	w, ok := l.windows[key]
+	if !ok {
+		w = &window{}
+		l.windows[key] = w
+	}
+	w.length = limit.Window
+	w.times = prune(w.times, now.Add(-limit.Window))
+	allowed := len(w.times) < limit.Requests
+	if allowed {
+		w.times = append(w.times, now)
+	}
+
+	reset := limit.Window
+	if len(w.times) > 0 {
+		reset = w.times[0].Add(limit.Window).Sub(now)
+	}
+	return result(allowed, len(w.times), limit, reset), nil
+}
+
+// sweep drops keys without requests in their last window.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	for key, w := range l.windows {
+		if len(w.times) == 0 || !w.times[len(w.times)-1].After(now.Add(-w.length)) {
+			delete(l.windows, key)
+		}
+	}
+}
+
+func prune(times []time.Time, after time.Time) []time.Time {
+	i := sort.Search(len(times), func(i int) bool { return times[i].After(after) })
+	return append(times[:0], times[i:]...)
+}
+
+// Fallback uses primary and switches to secondary for requests primary
+// fails on, so an unavailable Redis degrades to per-instance limits. Like
+// the cache it stops trying primary for a while after repeated failures.
+type Fallback struct {
+	primary   Limiter
+	secondary Limiter
+	breaker   *cash.Breaker
+}
+
+func NewFallback(primary, secondary Limiter) *Fallback {
+	return &Fallback{primary: primary, secondary: secondary, breaker: cash.NewBreaker(5, 30*time.Second)}
+}
+
+func (f *Fallback) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if f.breaker.Allow() {
+		res, err := f.primary.Allow(ctx, key, limit)
+		if err == nil {
+			f.breaker.Success()
+			return res, nil
+		}
+		f.breaker.Failure()
+		log.Warnf("%s, using in-memory limits", err)
+	}
+	return f.secondary.Allow(ctx, key, limit)
+}
+
+func result(allowed bool, count int, limit Limit, reset time.Duration) Result {
+	return Result{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: max(limit.Requests-count, 0),
+		Reset:     max(reset, 0),
+	}
+}
+
+// ParsePolicies reads named limits like "login=10/1m,register=5/1h".
+func ParsePolicies(s string) (map[string]Limit, error) {
+	policies := make(map[string]Limit)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(part, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("policy %q: expected name=requests/window", part)
+		}
+		count, window, ok := strings.Cut(spec, "/")
+		if !ok {
+			return nil, fmt.Errorf("policy %q: expected name=requests/window", part)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("policy %q: requests must be a positive number", part)
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("policy %q: window must be a duration of at least 1s", part)
+		}
+		if _, dup := policies[name]; dup {
+			return nil, fmt.Errorf("policy %q is declared twice", name)
+		}
+		policies[name] = Limit{Requests: n, Window: d}
+	}
+	return policies, nil
+}
@@ -0,0 +1,230 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"awesomeProject/internal/token"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+func newClock() *clock                   { return &clock{t: time.Unix(1700000000, 0)} }
+
+func limiters(t *testing.T, clk *clock) map[string]Limiter {
+	mr := miniredis.RunT(t)
+	r := NewRedisLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	r.now = clk.now
+	m := NewMemoryLimiter()
+	m.now = clk.now
+	return map[string]Limiter{"redis": r, "memory": m}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Requests: 3, Window: time.Minute}
+	for _, name := range []string{"redis", "memory"} {
+		clk := newClock()
+		limiter := limiters(t, clk)[name]
+
+		tests := []struct {
+			advance   time.Duration
+			allowed   bool
+			remaining int
+			reset     time.Duration
+		}{
+			{0, true, 2, time.Minute},
+			{10 * time.Second, true, 1, 50 * time.Second},
+			{10 * time.Second, true, 0, 40 * time.Second},
+			{10 * time.Second, false, 0, 30 * time.Second},
+			// The first request leaves the window.
+			{30 * time.Second, true, 0, 10 * time.Second},
+		}
+		for i, tt := range tests {
+			clk.advance(tt.advance)
+			res, err := limiter.Allow(ctx, "ip:1", limit)
+			if err != nil {
+				t.Fatalf("%s: Allow() #%d error = %v", name, i+1, err)
+			}
+			if res.Allowed != tt.allowed || res.Remaining != tt.remaining || res.Reset != tt.reset || res.Limit != 3 {
+				t.Errorf("%s: Allow() #%d = %+v, expected allowed %v, remaining %d, reset %s",
+					name, i+1, res, tt.allowed, tt.remaining, tt.reset)
+			}
+		}
+		if res, _ := limiter.Allow(ctx, "ip:2", limit); !res.Allowed || res.Remaining != 2 {
+			t.Errorf("%s: Allow(other key) = %+v, expected a fresh window", name, res)
+		}
+	}
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string, Limit) (Result, error) {
+	return Result{}, errors.New("redis down")
+}
+
+func TestFallback(t *testing.T) {
+	f := NewFallback(failingLimiter{}, NewMemoryLimiter())
+	limit := Limit{Requests: 1, Window: time.Minute}
+	if res, err := f.Allow(context.Background(), "k", limit); err != nil || !res.Allowed {
+		t.Fatalf("Allow() = %+v, %v", res, err)
+	}
+	if res, _ := f.Allow(context.Background(), "k", limit); res.Allowed {
+		t.Errorf("second Allow() through the fallback = %+v, expected rejection", res)
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	policies, err := ParsePolicies(" default=100/1m, login=10/30s ,")
+	if err != nil {
+		t.Fatalf("ParsePolicies() error = %v", err)
+	}
+	expected := map[string]Limit{"default": {100, time.Minute}, "login": {10, 30 * time.Second}}
+	for name, limit := range expected {
+		if policies[name] != limit {
+			t.Errorf("policy %s = %s, expected %s", name, policies[name], limit)
+		}
+	}
+
+	for _, input := range []string{"login", "login=10", "login=x/1m", "login=0/1m", "login=1/1ms", "=1/1m", "a=1/1m,a=2/1m"} {
+		if _, err := ParsePolicies(input); err == nil {
+			t.Errorf("ParsePolicies(%q) error = nil", input)
+		}
+	}
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	mw := Middleware(limiter, "login", Limit{Requests: 1, Window: time.Minute}, ClientKey)
+	handler := mw(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	call := func() (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(http.MethodPost, "/log-in/", nil)
+		req.Header.Set(echo.HeaderXRealIP, "10.0.0.1")
+		rec := httptest.NewRecorder()
+		return rec, handler(echo.New().NewContext(req, rec))
+	}
+
+	rec, err := call()
+	if err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("first request = %d, %v", rec.Code, err)
+	}
+	expected := map[string]string{"RateLimit-Policy": "1;w=60", "RateLimit-Limit": "1", "RateLimit-Remaining": "0", "RateLimit-Reset": "60"}
+	for header, value := range expected {
+		if got := rec.Header().Get(header); got != value {
+			t.Errorf("%s = %q, expected %q", header, got, value)
+		}
+	}
+
+	rec, err = call()
+	var he *echo.HTTPError
+	if !errors.As(err, &he) || he.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request error = %v, expected 429", err)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Retry-After missing on 429")
+	}
+}
+
+func TestMiddlewareIgnoresSpoofedForwardedFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    string
+		remoteAddr string
+		forwarded  []string
+		allowed    []bool
+	}{
+		{"rotating headers", "", "198.51.100.1:1234",
+			[]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, []bool{true, false, false}},
+		{"behind a trusted proxy", "10.0.0.0/8", "10.0.0.5:1234",
+			[]string{"203.0.113.7", "203.0.113.8", "203.0.113.7"}, []bool{true, true, false}},
+		{"spoofed hop behind a trusted proxy", "10.0.0.0/8", "10.0.0.5:1234",
+			[]string{"1.1.1.1, 203.0.113.7", "2.2.2.2, 203.0.113.7"}, []bool{true, false}},
+		{"untrusted sender", "10.0.0.0/8", "198.51.100.1:1234",
+			[]string{"203.0.113.7", "203.0.113.8"}, []bool{true, false}},
+	}
+	for _, tt := range tests {
+		trusted, err := ParseTrustedProxies(tt.trusted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := echo.New()
+		e.IPExtractor = IPExtractor(trusted)
+		e.Use(Middleware(NewMemoryLimiter(), "login", Limit{Requests: 1, Window: time.Minute}, ClientKey))
+		e.POST("/log-in/", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+		for i, forwarded := range tt.forwarded {
+			req := httptest.NewRequest(http.MethodPost, "/log-in/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set(echo.HeaderXForwardedFor, forwarded)
+			req.Header.Set(echo.HeaderXRealIP, forwarded)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if allowed := rec.Code == http.StatusOK; allowed != tt.allowed[i] {
+				t.Errorf("%s: request #%d from %s = %d, expected allowed %v", tt.name, i+1, forwarded, rec.Code, tt.allowed[i])
+			}
+		}
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		claims   *token.Claims
+		expected string
+	}{
+		{nil, "ip:192.0.2.1"},
+		{&token.Claims{UserNickname: "oleksii"}, "user:oleksii"},
+		{&token.Claims{UserNickname: "oleksii", APIKeyID: "key-1"}, "key:key-1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		e := echo.New()
+		e.IPExtractor = IPExtractor(nil)
+		c := e.NewContext(req, httptest.NewRecorder())
+		if tt.claims != nil {
+			token.SetClaims(c, tt.claims)
+		}
+		if got := ClientKey(c); got != tt.expected {
+			t.Errorf("ClientKey(%+v) = %s, expected %s", tt.claims, got, tt.expected)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := map[string]int{
+		"":                         0,
+		"10.0.0.0/8":               1,
+		"10.0.0.1, 192.168.0.0/16": 2,
+		"::1,fd00::/8":             2,
+		"10.0.0.0/33":              -1,
+		"proxy.internal":           -1,
+	}
+	for input, expected := range tests {
+		ranges, err := ParseTrustedProxies(input)
+		if expected < 0 {
+			if err == nil {
+				t.Errorf("ParseTrustedProxies(%q) error = nil", input)
+			}
+			continue
+		}
+		if err != nil || len(ranges) != expected {
+			t.Errorf("ParseTrustedProxies(%q) = %v, %v, expected %d ranges", input, ranges, err, expected)
+		}
+	}
+	single, _ := ParseTrustedProxies("10.0.0.1")
+	if single[0].Contains(net.ParseIP("10.0.0.2")) || !single[0].Contains(net.ParseIP("10.0.0.1")) {
+		t.Errorf("ParseTrustedProxies(10.0.0.1) = %v, expected just that address", single[0])
+	}
+}
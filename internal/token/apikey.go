@@ -0,0 +1,104 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"awesomeProject/users"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// APIKeyPrefix starts every API key, so keys can be told apart from access
+// tokens in the Authorization header and found by secret scanners.
+const APIKeyPrefix = "uak_"
+
+const apiKeyHeader = "X-API-Key"
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// NewAPIKey returns a new API key secret and the digest to store for it.
+func NewAPIKey() (secret, digest string, err error) {
+	random, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	secret = APIKeyPrefix + random
+	return secret, APIKeyDigest(secret), nil
+}
+
+// APIKeyDigest is the SHA-256 of secret. Secrets are random, so a plain hash
+// is enough and lets keys be looked up by it.
+func APIKeyDigest(secret string) string {
+	return digest(secret)
+}
+
+// Credentials returns the credential a request was made with: an API key
+// from X-API-Key or the Authorization header, or else the access token from
+// the Authorization header. The "Bearer " scheme is optional.
+func Credentials(r *http.Request) (accessToken, apiKey string) {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return "", key
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		auth = auth[7:]
+	}
+	if strings.HasPrefix(auth, APIKeyPrefix) {
+		return "", auth
+	}
+	return auth, ""
+}
+
+// APIKeys authenticates requests made with API keys for JwtMiddleware.
+type APIKeys struct {
+	keys  users.APIKeyRepository
+	users users.UserRepository
+}
+
+func NewAPIKeys(keys users.APIKeyRepository, repo users.UserRepository) *APIKeys {
+	return &APIKeys{keys: keys, users: repo}
+}
+
+// Authenticate returns the claims of the owner of secret, limited to the
+// scopes of the key. The owner is loaded on every request, so role changes
+// and deleted accounts take effect at once.
+func (a *APIKeys) Authenticate(ctx context.Context, secret string) (*Claims, error) {
+	key, err := a.keys.FindByDigest(ctx, APIKeyDigest(secret))
+	if errors.Is(err, users.ErrAPIKeyNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if key.Expired(now) {
+		return nil, ErrInvalidAPIKey
+	}
+	user, err := a.users.FindByID(ctx, key.UserID)
+	if errors.Is(err, users.ErrUserNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load api key owner: %w", err)
+	}
+	if now.Sub(key.LastUsedAt) >= touchInterval {
+		if err := a.keys.Touch(ctx, key.ID, now.UTC()); err != nil {
+			log.Errorf("record api key use: %s", err)
+		}
+	}
+
+	claims := &Claims{
+		UserRole:      user.Role,
+		UserNickname:  user.Nickname,
+		EmailVerified: user.EmailVerified,
+		APIKeyID:      key.ID.Hex(),
+		Scopes:        key.Scopes,
+	}
+	claims.Subject = user.ID.Hex()
+	return claims, nil
+}
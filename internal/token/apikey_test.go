@@ -0,0 +1,97 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"awesomeProject/users"
+)
+
+func TestCredentials(t *testing.T) {
+	tests := []struct {
+		header, value string
+		token, apiKey string
+	}{
+		{"Authorization", "eyJ.a.b", "eyJ.a.b", ""},
+		{"Authorization", "Bearer eyJ.a.b", "eyJ.a.b", ""},
+		{"Authorization", "bearer " + APIKeyPrefix + "abc", "", APIKeyPrefix + "abc"},
+		{"Authorization", APIKeyPrefix + "abc", "", APIKeyPrefix + "abc"},
+		{"X-API-Key", APIKeyPrefix + "abc", "", APIKeyPrefix + "abc"},
+		{"X-Other", "x", "", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(tt.header, tt.value)
+		tok, key := Credentials(req)
+		if tok != tt.token || key != tt.apiKey {
+			t.Errorf("Credentials(%s: %s) = %q, %q, expected %q, %q", tt.header, tt.value, tok, key, tt.token, tt.apiKey)
+		}
+	}
+}
+
+func TestClaimsCan(t *testing.T) {
+	tests := []struct {
+		claims   Claims
+		perm     users.Permission
+		expected bool
+	}{
+		{Claims{UserRole: users.RoleUser}, users.PermRatingVote, true},
+		{Claims{UserRole: users.RoleUser}, users.PermUserDelete, false},
+		{Claims{UserRole: users.RoleUser, APIKeyID: "k", Scopes: []users.Permission{users.PermRatingVote}}, users.PermRatingVote, true},
+		{Claims{UserRole: users.RoleUser, APIKeyID: "k", Scopes: []users.Permission{users.PermRatingVote}}, users.PermProfileView, false},
+		// A scope the role lost since the key was made grants nothing.
+		{Claims{UserRole: users.RoleUser, APIKeyID: "k", Scopes: []users.Permission{users.PermUserDelete}}, users.PermUserDelete, false},
+	}
+	for _, tt := range tests {
+		if result := tt.claims.Can(tt.perm); result != tt.expected {
+			t.Errorf("Can(%s, scopes %v, %s) = %v, expected %v", tt.claims.UserRole, tt.claims.Scopes, tt.perm, result, tt.expected)
+		}
+	}
+}
+
+func TestAPIKeysAuthenticate(t *testing.T) {
+	repo := users.NewMemoryRepository()
+	keys := users.NewMemoryAPIKeyRepository()
+	auth := NewAPIKeys(keys, repo)
+	ctx := context.Background()
+	owner := &users.User{Nickname: "oleksii", Email: "oleksii@example.com", Role: users.RoleUser}
+	if err := repo.Insert(ctx, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	newKey := func(expiresAt time.Time) string {
+		secret, digest, err := NewAPIKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := &users.APIKey{UserID: owner.ID, Digest: digest, Scopes: []users.Permission{users.PermRatingVote}, ExpiresAt: expiresAt}
+		if err := keys.Insert(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		return secret
+	}
+	valid, expired := newKey(time.Time{}), newKey(time.Now().Add(-time.Minute))
+
+	claims, err := auth.Authenticate(ctx, valid)
+	if err != nil || claims.UserID() != owner.ID.Hex() || claims.UserNickname != "oleksii" || claims.APIKeyID == "" {
+		t.Fatalf("Authenticate(valid) = %+v, %v", claims, err)
+	}
+	if key, _ := keys.FindByDigest(ctx, APIKeyDigest(valid)); key.LastUsedAt.IsZero() {
+		t.Errorf("Authenticate() did not record the last use")
+	}
+	for name, secret := range map[string]string{"expired": expired, "unknown": APIKeyPrefix + "unknown"} {
+		if _, err := auth.Authenticate(ctx, secret); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("Authenticate(%s) error = %v, expected %v", name, err, ErrInvalidAPIKey)
+		}
+	}
+
+	if err := repo.SoftDelete(ctx, "oleksii"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Authenticate(ctx, valid); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Authenticate(key of a deleted user) error = %v, expected %v", err, ErrInvalidAPIKey)
+	}
+}
@@ -0,0 +1,100 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	authCodeKey     = "oauth-code:%s"
+	authCodeUsedKey = "oauth-code-used:%s"
+)
+
+var ErrInvalidAuthorizationCode = errors.New("invalid or expired authorization code")
+
+// AuthorizationCode is what a user granted a client on the consent screen,
+// waiting to be exchanged for tokens.
+type AuthorizationCode struct {
+	ClientID    string `json:"client_id"`
+	RedirectURI string `json:"redirect_uri"`
+	UserID      string `json:"user_id"`
+	Scope       string `json:"scope"`
+	Nonce       string `json:"nonce,omitempty"`
+	// CodeChallenge is the S256 PKCE challenge the code_verifier must
+	// match.
+	CodeChallenge string    `json:"code_challenge"`
+	AuthTime      time.Time `json:"auth_time"`
+}
+
+// AuthorizationCodes keeps authorization codes in Redis, stored by digest
+// like refresh tokens. A code works once; presenting it again revokes the
+// session it was exchanged for (RFC 6749, section 4.1.2).
+type AuthorizationCodes struct {
+	client *redis.Client
+	store  *Store
+	ttl    time.Duration
+}
+
+func NewAuthorizationCodes(client *redis.Client, store *Store, ttl time.Duration) *AuthorizationCodes {
+	return &AuthorizationCodes{client: client, store: store, ttl: ttl}
+}
+
+// Issue stores grant and returns its code.
+func (a *AuthorizationCodes) Issue(ctx context.Context, grant *AuthorizationCode) (string, error) {
+	code, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(grant)
+	if err != nil {
+		return "", err
+	}
+	if err := a.client.Set(ctx, fmt.Sprintf(authCodeKey, digest(code)), raw, a.ttl).Err(); err != nil {
+		return "", fmt.Errorf("store authorization code: %w", err)
+	}
+	return code, nil
+}
+
+// Exchange consumes code and returns its grant. Call Redeemed once the
+// tokens were issued.
+func (a *AuthorizationCodes) Exchange(ctx context.Context, code string) (*AuthorizationCode, error) {
+	raw, err := a.client.GetDel(ctx, fmt.Sprintf(authCodeKey, digest(code))).Result()
+	if errors.Is(err, redis.Nil) {
+		a.revokeRedeemed(ctx, code)
+		return nil, ErrInvalidAuthorizationCode
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load authorization code: %w", err)
+	}
+	var grant AuthorizationCode
+	if err := json.Unmarshal([]byte(raw), &grant); err != nil {
+		return nil, fmt.Errorf("decode authorization code: %w", err)
+	}
+	return &grant, nil
+}
+
+// Redeemed records that code was exchanged for session, so that a replay
+// of the code can end it.
+func (a *AuthorizationCodes) Redeemed(ctx context.Context, code string, session *Session) error {
+	value := session.UserID + " " + session.ID
+	if err := a.client.Set(ctx, fmt.Sprintf(authCodeUsedKey, digest(code)), value, a.store.refreshTTL).Err(); err != nil {
+		return fmt.Errorf("mark authorization code used: %w", err)
+	}
+	return nil
+}
+
+func (a *AuthorizationCodes) revokeRedeemed(ctx context.Context, code string) {
+	value, err := a.client.GetDel(ctx, fmt.Sprintf(authCodeUsedKey, digest(code))).Result()
+	if err != nil {
+		return
+	}
+	if userID, sessionID, ok := strings.Cut(value, " "); ok {
+		_ = a.store.RevokeSession(ctx, userID, sessionID)
+	}
+}
@@ -0,0 +1,49 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestAuthorizationCodes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	store := NewStore(client, time.Hour)
+	codes := NewAuthorizationCodes(client, store, time.Minute)
+	ctx := context.Background()
+
+	grant := &AuthorizationCode{ClientID: "client-1", UserID: "user-1", Scope: "openid", CodeChallenge: "challenge"}
+	code, err := codes.Issue(ctx, grant)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	got, err := codes.Exchange(ctx, code)
+	if err != nil || got.ClientID != "client-1" || got.CodeChallenge != "challenge" {
+		t.Fatalf("Exchange() = %+v, %v", got, err)
+	}
+	session, _, err := store.IssueClient(ctx, "user-1", "client-1", "openid", Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := codes.Redeemed(ctx, code, session); err != nil {
+		t.Fatalf("Redeemed() error = %v", err)
+	}
+
+	if _, err := codes.Exchange(ctx, code); !errors.Is(err, ErrInvalidAuthorizationCode) {
+		t.Errorf("Exchange(used code) error = %v, expected %v", err, ErrInvalidAuthorizationCode)
+	}
+	if _, err := store.Session(ctx, session.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Session() after code reuse error = %v, expected %v", err, ErrSessionNotFound)
+	}
+
+	expiring, _ := codes.Issue(ctx, grant)
+	mr.FastForward(2 * time.Minute)
+	if _, err := codes.Exchange(ctx, expiring); !errors.Is(err, ErrInvalidAuthorizationCode) {
+		t.Errorf("Exchange(expired code) error = %v, expected %v", err, ErrInvalidAuthorizationCode)
+	}
+}
@@ -0,0 +1,115 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	loginFailuresKey = "login-fail:%s:%s"
+	loginLockKey     = "login-lock:%s:%s"
+
+	scopeAccount = "account"
+	scopeIP      = "ip"
+)
+
+// GuardPolicy configures LoginGuard.
+type GuardPolicy struct {
+	// AccountThreshold and IPThreshold are the failures after which a
+	// nickname or a client address is locked.
+	AccountThreshold int
+	IPThreshold      int
+	// Lockout is the first lock; it doubles with every further failure up
+	// to MaxLockout.
+	Lockout    time.Duration
+	MaxLockout time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// LoginGuard counts failed logins per nickname and per client IP in Redis
+// and locks either of them out with exponential backoff. Nicknames are
+// counted whether or not they exist, so a lock reveals nothing about
+// registered accounts.
+type LoginGuard struct {
+	client *redis.Client
+	policy GuardPolicy
+}
+
+func NewLoginGuard(client *redis.Client, policy GuardPolicy) *LoginGuard {
+	return &LoginGuard{client: client, policy: policy}
+}
+
+// Check returns how long logins for nickname from ip stay locked, or zero.
+func (g *LoginGuard) Check(ctx context.Context, nickname, ip string) (time.Duration, error) {
+	pipe := g.client.Pipeline()
+	account := pipe.PTTL(ctx, fmt.Sprintf(loginLockKey, scopeAccount, normalizeNickname(nickname)))
+	addr := pipe.PTTL(ctx, fmt.Sprintf(loginLockKey, scopeIP, ip))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("check login lock: %w", err)
+	}
+	return max(account.Val(), addr.Val(), 0), nil
+}
+
+// Fail records a failed attempt and returns the lock it caused, if any.
+func (g *LoginGuard) Fail(ctx context.Context, nickname, ip string) (time.Duration, error) {
+	account, err := g.fail(ctx, scopeAccount, normalizeNickname(nickname), g.policy.AccountThreshold)
+	if err != nil {
+		return 0, err
+	}
+	addr, err := g.fail(ctx, scopeIP, ip, g.policy.IPThreshold)
+	if err != nil {
+		return 0, err
+	}
+	return max(account, addr), nil
+}
+
+// Succeed clears the failures of nickname. The IP counter is kept, so an
+// attacker cannot reset it by logging into an account of their own.
+func (g *LoginGuard) Succeed(ctx context.Context, nickname string) error {
+	return g.Unlock(ctx, nickname)
+}
+
+// Unlock lifts the lock of nickname and forgets its failures.
+func (g *LoginGuard) Unlock(ctx context.Context, nickname string) error {
+	nickname = normalizeNickname(nickname)
+	err := g.client.Del(ctx,
+		fmt.Sprintf(loginFailuresKey, scopeAccount, nickname),
+		fmt.Sprintf(loginLockKey, scopeAccount, nickname)).Err()
+	if err != nil {
+		return fmt.Errorf("unlock login: %w", err)
+	}
+	return nil
+}
+
+func (g *LoginGuard) fail(ctx context.Context, scope, subject string, threshold int) (time.Duration, error) {
+	key := fmt.Sprintf(loginFailuresKey, scope, subject)
+	pipe := g.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, g.policy.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("count login failure: %w", err)
+	}
+	failures := int(incr.Val())
+	if failures < threshold {
+		return 0, nil
+	}
+
+	lock := g.policy.Lockout
+	for i := threshold; i < failures && lock < g.policy.MaxLockout; i++ {
+		lock *= 2
+	}
+	lock = min(lock, g.policy.MaxLockout)
+	if err := g.client.Set(ctx, fmt.Sprintf(loginLockKey, scope, subject), 1, lock).Err(); err != nil {
+		return 0, fmt.Errorf("lock login: %w", err)
+	}
+	return lock, nil
+}
+
+func normalizeNickname(nickname string) string {
+	return strings.ToLower(nickname)
+}
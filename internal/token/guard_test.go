@@ -0,0 +1,80 @@
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestGuard(t *testing.T) (*LoginGuard, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return NewLoginGuard(redis.NewClient(&redis.Options{Addr: mr.Addr()}), GuardPolicy{
+		AccountThreshold: 3,
+		IPThreshold:      5,
+		Lockout:          time.Minute,
+		MaxLockout:       5 * time.Minute,
+		Window:           time.Hour,
+	}), mr
+}
+
+func TestLoginGuardBackoff(t *testing.T) {
+	guard, _ := newTestGuard(t)
+	ctx := context.Background()
+
+	expected := []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, lock := range expected {
+		// Spread over addresses so only the account counter applies.
+		ip := string(rune('a' + i))
+		result, err := guard.Fail(ctx, "Oleksii", ip)
+		if err != nil || result != lock {
+			t.Errorf("Fail() #%d = %s, %v, expected %s", i+1, result, err, lock)
+		}
+	}
+	if wait, _ := guard.Check(ctx, "oleksii", "z"); wait <= 0 {
+		t.Errorf("Check() of a locked account = %s, expected a lock", wait)
+	}
+	if wait, _ := guard.Check(ctx, "other", "z"); wait != 0 {
+		t.Errorf("Check() of another account = %s, expected none", wait)
+	}
+
+	if err := guard.Unlock(ctx, "OLEKSII"); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if wait, _ := guard.Check(ctx, "oleksii", "z"); wait != 0 {
+		t.Errorf("Check() after Unlock = %s, expected none", wait)
+	}
+	if lock, _ := guard.Fail(ctx, "oleksii", "z"); lock != 0 {
+		t.Errorf("Fail() after Unlock = %s, expected the counter to restart", lock)
+	}
+}
+
+func TestLoginGuardIP(t *testing.T) {
+	guard, mr := newTestGuard(t)
+	ctx := context.Background()
+
+	nicknames := []string{"a", "b", "c", "d", "e"}
+	for _, nick := range nicknames {
+		_, _ = guard.Fail(ctx, nick, "10.0.0.1")
+	}
+	if wait, _ := guard.Check(ctx, "fresh", "10.0.0.1"); wait != time.Minute {
+		t.Errorf("Check() from a locked IP = %s, expected %s", wait, time.Minute)
+	}
+	if err := guard.Succeed(ctx, "fresh"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := guard.Check(ctx, "fresh", "10.0.0.1"); wait == 0 {
+		t.Errorf("Succeed() lifted the IP lock")
+	}
+	if wait, _ := guard.Check(ctx, "fresh", "10.0.0.2"); wait != 0 {
+		t.Errorf("Check() from another IP = %s, expected none", wait)
+	}
+
+	mr.FastForward(time.Minute)
+	if wait, _ := guard.Check(ctx, "fresh", "10.0.0.1"); wait != 0 {
+		t.Errorf("Check() after the lock expired = %s, expected none", wait)
+	}
+}
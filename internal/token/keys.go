@@ -0,0 +1,267 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is one key for access tokens. Keys loaded from a public key have no
+// private part and can only verify.
+type Key struct {
+	// ID is the "kid" header of tokens signed with the key. For asymmetric
+	// keys it is the RFC 7638 thumbprint, so it stays the same whichever
+	// service loads the key.
+	ID      string
+	Method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// LoadKey reads a PEM encoded RSA, ECDSA (P-256, P-384 or P-521) or Ed25519
+// key. Private keys may be PKCS#1, SEC 1 or PKCS#8, public keys PKIX. RSA
+// keys sign with RS256, ECDSA keys with the ES variant of their curve and
+// Ed25519 keys with EdDSA. A key pair is made with, for example,
+//
+//	openssl genpkey -algorithm ed25519 -out jwt.pem
+//	openssl pkey -in jwt.pem -pubout -out jwt.pub.pem
+func LoadKey(path string) (*Key, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// ParseKey is LoadKey for PEM data in memory.
+func ParseKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.private, key.public = k, &k.PublicKey
+	case *ecdsa.PrivateKey:
+		key.private, key.public = k, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.private, key.public = k, k.Public()
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		key.public = k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must have at least 2048 bits")
+		}
+		key.Method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.Method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	}
+	key.ID, err = thumbprint(key.JWK())
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// HMACKey is a symmetric key for HS256. It is never published in a JWKS.
+func HMACKey(secret []byte) *Key {
+	return &Key{ID: "hs256", Method: jwt.SigningMethodHS256, private: secret, public: secret}
+}
+
+// CanSign reports whether the key has its private part.
+func (k *Key) CanSign() bool {
+	return k.private != nil
+}
+
+// JWK is the public part of a key as a JSON Web Key (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWK returns the public key, or nil for an HMAC key.
+func (k *Key) JWK() *JWK {
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwk := &JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty, jwk.Crv = "EC", pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return nil
+	}
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 thumbprint: the SHA-256 of the required
+// members of the JWK in lexicographic order.
+func thumbprint(jwk *JWK) (string, error) {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	raw, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// KeySet is the signing key plus every key tokens are still accepted from.
+// To rotate, make the new key the signing key and keep the old one as a
+// verification key until the tokens it signed have expired.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeySet signs with signing and verifies with it and with verify.
+func NewKeySet(signing *Key, verify ...*Key) (*KeySet, error) {
+	if !signing.CanSign() {
+		return nil, errors.New("the signing key has no private part")
+	}
+	set := &KeySet{signing: signing, keys: map[string]*Key{signing.ID: signing}}
+	for _, k := range verify {
+		if _, ok := set.keys[k.ID]; ok {
+			continue
+		}
+		set.keys[k.ID] = k
+	}
+	return set, nil
+}
+
+// HMACKeys is a KeySet of a single HS256 secret.
+func HMACKeys(secret []byte) *KeySet {
+	set, _ := NewKeySet(HMACKey(secret))
+	return set
+}
+
+// Signing returns the key new tokens are signed with.
+func (s *KeySet) Signing() *Key {
+	return s.signing
+}
+
+// Lookup returns the verification key with the given ID. Tokens issued
+// before keys had IDs carry none; they are checked with the signing key.
+func (s *KeySet) Lookup(kid string) (*Key, bool) {
+	if kid == "" {
+		return s.signing, true
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+// JWKSet is the body of /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, signing key first. HMAC keys
+// are left out.
+func (s *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	if jwk := s.signing.JWK(); jwk != nil {
+		set.Keys = append(set.Keys, *jwk)
+	}
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		if id != s.signing.ID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if jwk := s.keys[id].JWK(); jwk != nil {
+			set.Keys = append(set.Keys, *jwk)
+		}
+	}
+	return set
+}
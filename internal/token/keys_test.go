@@ -0,0 +1,139 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"awesomeProject/users"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testKeyPEM returns a new private key of the given kind and its public key,
+// both PEM encoded.
+func testKeyPEM(t *testing.T, kind string) (private, public []byte) {
+	t.Helper()
+	var key interface{}
+	var err error
+	switch kind {
+	case "RSA":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "EC":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "Ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDer, err := x509.MarshalPKIXPublicKey(key.(crypto.Signer).Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer})
+}
+
+func newTestKey(t *testing.T, data []byte) *Key {
+	t.Helper()
+	key, err := ParseKey(data)
+	if err != nil {
+		t.Fatalf("ParseKey() error = %v", err)
+	}
+	return key
+}
+
+func TestParseKey(t *testing.T) {
+	tests := map[string]string{
+		"RSA":     "RS256",
+		"EC":      "ES256",
+		"Ed25519": "EdDSA",
+	}
+	for kind, alg := range tests {
+		private, public := testKeyPEM(t, kind)
+		key := newTestKey(t, private)
+		if key.Method.Alg() != alg || !key.CanSign() {
+			t.Errorf("ParseKey(%s) = %s, can sign %v, expected %s", kind, key.Method.Alg(), key.CanSign(), alg)
+		}
+		pub := newTestKey(t, public)
+		if pub.ID != key.ID || pub.CanSign() {
+			t.Errorf("ParseKey(%s public) = %s, can sign %v, expected the private key's ID %s", kind, pub.ID, pub.CanSign(), key.ID)
+		}
+		if jwk := pub.JWK(); jwk == nil || jwk.Kid != key.ID || jwk.Alg != alg {
+			t.Errorf("JWK(%s) = %+v", kind, jwk)
+		}
+	}
+
+	if _, err := ParseKey([]byte("not a key")); err == nil {
+		t.Errorf("ParseKey(garbage) error = nil")
+	}
+	_, public := testKeyPEM(t, "EC")
+	if _, err := NewKeySet(newTestKey(t, public)); err == nil {
+		t.Errorf("NewKeySet(public key) error = nil")
+	}
+}
+
+func TestManagerKeyRotation(t *testing.T) {
+	user := &users.User{ID: primitive.NewObjectID(), Nickname: "oleksii", Role: users.RoleUser}
+	oldPEM, oldPublic := testKeyPEM(t, "Ed25519")
+	newPEM, _ := testKeyPEM(t, "EC")
+
+	oldKeys, _ := NewKeySet(newTestKey(t, oldPEM))
+	old := NewManager(oldKeys, "test", "test", time.Minute)
+	oldToken, err := old.GenerateToken(user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotatedKeys, _ := NewKeySet(newTestKey(t, newPEM), newTestKey(t, oldPublic))
+	rotated := NewManager(rotatedKeys, "test", "test", time.Minute)
+	newToken, _ := rotated.GenerateToken(user, "")
+
+	if claims, err := rotated.ParseToken(oldToken); err != nil || claims.UserNickname != "oleksii" {
+		t.Errorf("ParseToken(token of the previous key) = %v, %v", claims, err)
+	}
+	if _, err := rotated.ParseToken(newToken); err != nil {
+		t.Errorf("ParseToken(token of the signing key) error = %v", err)
+	}
+	if _, err := old.ParseToken(newToken); err == nil {
+		t.Errorf("ParseToken(token of an unknown key) error = nil")
+	}
+
+	jwks := rotatedKeys.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kid != rotatedKeys.Signing().ID || jwks.Keys[0].Kty != "EC" || jwks.Keys[1].Kty != "OKP" {
+		t.Errorf("JWKS() = %+v, expected the EC signing key, then the Ed25519 one", jwks)
+	}
+	if keys := HMACKeys([]byte("secret")).JWKS(); len(keys.Keys) != 0 {
+		t.Errorf("JWKS() of an HMAC key = %+v, expected no keys", keys)
+	}
+}
+
+func TestManagerRejectsAlgorithmConfusion(t *testing.T) {
+	private, public := testKeyPEM(t, "RSA")
+	keys, _ := NewKeySet(newTestKey(t, private))
+	m := NewManager(keys, "test", "test", time.Minute)
+
+	// An attacker who knows the public key signs with it as an HMAC secret.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserNickname: "admin", UserRole: users.RoleAdmin})
+	forged.Header["kid"] = keys.Signing().ID
+	tok, err := forged.SignedString(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ParseToken(tok); err == nil {
+		t.Errorf("ParseToken(HS256 token signed with the public key) error = nil")
+	}
+}
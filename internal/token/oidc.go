@@ -0,0 +1,155 @@
+package token
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"awesomeProject/users"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes of the OpenID Connect provider.
+const (
+	ScopeOpenID        = "openid"
+	ScopeProfile       = "profile"
+	ScopeEmail         = "email"
+	ScopeOfflineAccess = "offline_access"
+)
+
+// HasScope reports whether the space separated scope contains want.
+func HasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// Profile holds the standard OpenID Connect claims about a user that the
+// profile and email scopes release.
+type Profile struct {
+	Name              string `json:"name,omitempty"`
+	GivenName         string `json:"given_name,omitempty"`
+	FamilyName        string `json:"family_name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+// NewProfile returns the claims about user that scope grants.
+func NewProfile(user *users.User, scope string) Profile {
+	var p Profile
+	if HasScope(scope, ScopeProfile) {
+		p.Name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		p.GivenName = user.FirstName
+		p.FamilyName = user.LastName
+		p.PreferredUsername = user.Nickname
+	}
+	if HasScope(scope, ScopeEmail) {
+		verified := user.EmailVerified
+		p.Email, p.EmailVerified = user.Email, &verified
+	}
+	return p
+}
+
+// IDClaims are the claims of an ID token. The audience is the client.
+type IDClaims struct {
+	Nonce           string `json:"nonce,omitempty"`
+	AuthTime        int64  `json:"auth_time"`
+	AuthorizedParty string `json:"azp"`
+	Profile
+	jwt.RegisteredClaims
+}
+
+// GenerateIDToken issues the ID token telling clientID that user logged in
+// at authTime. nonce is repeated from the authorization request.
+func (m *Manager) GenerateIDToken(user *users.User, clientID, nonce, scope string, authTime time.Time) (string, error) {
+	now := time.Now()
+	return m.sign(&IDClaims{
+		Nonce:           nonce,
+		AuthTime:        authTime.Unix(),
+		AuthorizedParty: clientID,
+		Profile:         NewProfile(user, scope),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID.Hex(),
+			Issuer:    m.issuer,
+			Audience:  jwt.ClaimStrings{clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.accessTTL)),
+		},
+	})
+}
+
+// GenerateClientToken issues an access token that lets clientID act for
+// user within scope. Its audience is audience rather than ours, so the rest
+// of the API rejects it.
+func (m *Manager) GenerateClientToken(user *users.User, sessionID, clientID, scope, audience string) (string, error) {
+	claims, err := m.newClaims(user, sessionID, audience)
+	if err != nil {
+		return "", err
+	}
+	claims.UserRole = ""
+	claims.ClientID, claims.Scope = clientID, scope
+	return m.sign(claims)
+}
+
+// ParseClientToken is ParseToken for tokens made by GenerateClientToken.
+func (m *Manager) ParseClientToken(tokenString, audience string) (*Claims, error) {
+	claims, err := m.parse(tokenString, audience)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ClientID == "" {
+		return nil, errors.New("not a client token")
+	}
+	return claims, nil
+}
+
+// VerifyPKCE checks a code_verifier against the S256 code_challenge of the
+// authorization request (RFC 7636).
+func VerifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, r := range verifier {
+		if !pkceCharacter(r) {
+			return false
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// PKCEChallenge returns the S256 code_challenge of verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func pkceCharacter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-._~", r)
+}
+
+// NewClientCredentials returns a client_id and a client secret with the
+// digest to store for it.
+func NewClientCredentials() (clientID, secret, secretDigest string, err error) {
+	clientID, err = randomString(16)
+	if err != nil {
+		return "", "", "", err
+	}
+	secret, err = randomString(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	return clientID, secret, digest(secret), nil
+}
+
+// CheckClientSecret compares secret with the stored digest in constant time.
+func CheckClientSecret(secret, secretDigest string) bool {
+	return subtle.ConstantTimeCompare([]byte(digest(secret)), []byte(secretDigest)) == 1
+}
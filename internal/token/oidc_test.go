@@ -0,0 +1,91 @@
+package token
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/users"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got := PKCEChallenge(verifier); got != challenge {
+		t.Fatalf("PKCEChallenge(RFC 7636 example) = %s, expected %s", got, challenge)
+	}
+
+	tests := map[string]bool{
+		verifier:                 true,
+		verifier + "x":           false,
+		"short":                  false,
+		strings.Repeat("a", 129): false,
+		verifier[:42] + "+":      false,
+	}
+	for v, expected := range tests {
+		if got := VerifyPKCE(v, challenge); got != expected {
+			t.Errorf("VerifyPKCE(%s) = %v, expected %v", v, got, expected)
+		}
+	}
+	long := strings.Repeat("a~._-", 9)
+	if !VerifyPKCE(long, PKCEChallenge(long)) {
+		t.Errorf("VerifyPKCE(%s) with its own challenge = false", long)
+	}
+}
+
+func TestClientTokens(t *testing.T) {
+	m := NewManager(HMACKeys([]byte("test-secret-that-is-long-enough!!")), "https://users.example", "users-api", time.Minute)
+	user := &users.User{ID: primitive.NewObjectID(), Nickname: "oleksii", FirstName: "Oleksii", Role: users.RoleAdmin, Email: "o@example.com"}
+	const audience = "https://users.example/oauth/userinfo"
+
+	tok, err := m.GenerateClientToken(user, "session-1", "client-1", "openid profile", audience)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := m.ParseClientToken(tok, audience)
+	if err != nil {
+		t.Fatalf("ParseClientToken() error = %v", err)
+	}
+	if claims.ClientID != "client-1" || claims.Scope != "openid profile" || claims.SessionID != "session-1" || claims.UserRole != "" {
+		t.Errorf("ParseClientToken() = client %q, scope %q, sid %q, role %q", claims.ClientID, claims.Scope, claims.SessionID, claims.UserRole)
+	}
+	if _, err := m.ParseToken(tok); err == nil {
+		t.Errorf("ParseToken(client token) error = nil, expected the audience to be rejected")
+	}
+	own, _ := m.GenerateToken(user, "session-1")
+	if _, err := m.ParseClientToken(own, "users-api"); err == nil {
+		t.Errorf("ParseClientToken(login token) error = nil")
+	}
+
+	idToken, err := m.GenerateIDToken(user, "client-1", "n-0S6_WzA2Mj", "openid email", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id IDClaims
+	_, err = jwt.ParseWithClaims(idToken, &id, m.verificationKey, jwt.WithAudience("client-1"), jwt.WithIssuer("https://users.example"))
+	if err != nil {
+		t.Fatalf("parse id token error = %v", err)
+	}
+	if id.Subject != user.ID.Hex() || id.Nonce != "n-0S6_WzA2Mj" || id.AuthorizedParty != "client-1" {
+		t.Errorf("id token = sub %s, nonce %s, azp %s", id.Subject, id.Nonce, id.AuthorizedParty)
+	}
+	if id.Email != "o@example.com" || id.EmailVerified == nil || id.Name != "" {
+		t.Errorf("id token profile = %+v, expected the email claims only", id.Profile)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	id, secret, digest, err := NewClientCredentials()
+	if err != nil || id == "" || secret == "" || digest == secret {
+		t.Fatalf("NewClientCredentials() = %s, %s, %s, %v", id, secret, digest, err)
+	}
+	if !CheckClientSecret(secret, digest) {
+		t.Errorf("CheckClientSecret(secret) = false")
+	}
+	if CheckClientSecret(secret+"x", digest) || CheckClientSecret("", digest) {
+		t.Errorf("CheckClientSecret(wrong secret) = true")
+	}
+}
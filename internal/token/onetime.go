@@ -0,0 +1,154 @@
+package token
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	oneTimeTokenKey  = "one-time:%s:%s"
+	oneTimeLatestKey = "one-time-latest:%s:%s"
+	throttleKey      = "throttle:%s:%s"
+)
+
+// Purposes of one-time tokens. A token issued for one purpose is never
+// accepted for another.
+const (
+	PurposeVerifyEmail    = "verify-email"
+	PurposeResetPassword  = "reset-password"
+	PurposeLoginChallenge = "login-challenge"
+)
+
+var ErrInvalidOneTimeToken = errors.New("invalid or expired token")
+
+// OneTimeTokens issues signed, single-use tokens for links sent by email.
+// A token is "purpose.subject.expiry.nonce" plus an HMAC over those parts.
+// Redis keeps only a digest of the nonce, which Consume deletes, so a token
+// works once and issuing a new one for the same subject cancels the old one.
+type OneTimeTokens struct {
+	client *redis.Client
+	secret []byte
+}
+
+func NewOneTimeTokens(client *redis.Client, secret []byte) *OneTimeTokens {
+	return &OneTimeTokens{client: client, secret: secret}
+}
+
+// Issue returns a token for subject that is valid for ttl.
+func (t *OneTimeTokens) Issue(ctx context.Context, purpose, subject string, ttl time.Duration) (string, error) {
+	nonce, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	payload := strings.Join([]string{purpose, subject, expires, nonce}, ".")
+
+	key := fmt.Sprintf(oneTimeTokenKey, purpose, digest(nonce))
+	previous, err := t.client.SetArgs(ctx, fmt.Sprintf(oneTimeLatestKey, purpose, subject), key, redis.SetArgs{Get: true, TTL: ttl}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", fmt.Errorf("store token: %w", err)
+	}
+	pipe := t.client.TxPipeline()
+	if previous != "" {
+		pipe.Del(ctx, previous)
+	}
+	pipe.Set(ctx, key, subject, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", fmt.Errorf("store token: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(t.sign(payload)), nil
+}
+
+// Consume checks tok and returns its subject. It fails with
+// ErrInvalidOneTimeToken when the token is forged, expired, meant for
+// another purpose, superseded or already used.
+func (t *OneTimeTokens) Consume(ctx context.Context, purpose, tok string) (string, error) {
+	claimed, nonce, err := t.parse(purpose, tok)
+	if err != nil {
+		return "", err
+	}
+
+	subject, err := t.client.GetDel(ctx, fmt.Sprintf(oneTimeTokenKey, purpose, digest(nonce))).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrInvalidOneTimeToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("consume token: %w", err)
+	}
+	if subject != claimed {
+		return "", ErrInvalidOneTimeToken
+	}
+	t.client.Del(ctx, fmt.Sprintf(oneTimeLatestKey, purpose, subject))
+	return subject, nil
+}
+
+// Subject returns the subject of tok without using it up, so a handler can
+// look at the account before it commits to Consume. It only checks the
+// signature, purpose and expiry; a token that Subject accepts may still be
+// refused by Consume.
+func (t *OneTimeTokens) Subject(purpose, tok string) (string, error) {
+	subject, _, err := t.parse(purpose, tok)
+	return subject, err
+}
+
+func (t *OneTimeTokens) parse(purpose, tok string) (subject, nonce string, err error) {
+	encoded, sig, ok := strings.Cut(tok, ".")
+	if !ok {
+		return "", "", ErrInvalidOneTimeToken
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", ErrInvalidOneTimeToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, t.sign(string(raw))) {
+		return "", "", ErrInvalidOneTimeToken
+	}
+
+	// The subject may contain dots; the other parts never do.
+	parts := strings.Split(string(raw), ".")
+	if len(parts) < 4 || parts[0] != purpose {
+		return "", "", ErrInvalidOneTimeToken
+	}
+	n := len(parts)
+	expires, err := strconv.ParseInt(parts[n-2], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", "", ErrInvalidOneTimeToken
+	}
+	return strings.Join(parts[1:n-2], "."), parts[n-1], nil
+}
+
+// Throttle allows one call per interval for purpose and subject. When the
+// call is not allowed it returns how long to wait.
+func (t *OneTimeTokens) Throttle(ctx context.Context, purpose, subject string, interval time.Duration) (time.Duration, error) {
+	key := fmt.Sprintf(throttleKey, purpose, digest(subject))
+	ok, err := t.client.SetNX(ctx, key, 1, interval).Result()
+	if err != nil {
+		return 0, fmt.Errorf("throttle: %w", err)
+	}
+	if ok {
+		return 0, nil
+	}
+	wait, err := t.client.PTTL(ctx, key).Result()
+	if err != nil || wait <= 0 {
+		return interval, nil
+	}
+	return wait, nil
+}
+
+func (t *OneTimeTokens) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,86 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestOneTimeTokens(t *testing.T) (*OneTimeTokens, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return NewOneTimeTokens(redis.NewClient(&redis.Options{Addr: mr.Addr()}), []byte("one-time-secret")), mr
+}
+
+func TestOneTimeTokensConsume(t *testing.T) {
+	tokens, _ := newTestOneTimeTokens(t)
+	ctx := context.Background()
+
+	tok, err := tokens.Issue(ctx, PurposeVerifyEmail, "user.1", time.Hour)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if _, err := tokens.Consume(ctx, "other-purpose", tok); !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Errorf("Consume(other purpose) error = %v, expected %v", err, ErrInvalidOneTimeToken)
+	}
+	subject, err := tokens.Consume(ctx, PurposeVerifyEmail, tok)
+	if err != nil || subject != "user.1" {
+		t.Fatalf("Consume() = %s, %v, expected user.1", subject, err)
+	}
+	if _, err := tokens.Consume(ctx, PurposeVerifyEmail, tok); !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Errorf("Consume() twice error = %v, expected %v", err, ErrInvalidOneTimeToken)
+	}
+}
+
+func TestOneTimeTokensRejected(t *testing.T) {
+	tokens, mr := newTestOneTimeTokens(t)
+	ctx := context.Background()
+
+	superseded, _ := tokens.Issue(ctx, PurposeVerifyEmail, "user-1", time.Hour)
+	latest, _ := tokens.Issue(ctx, PurposeVerifyEmail, "user-1", time.Hour)
+	expired, _ := tokens.Issue(ctx, PurposeVerifyEmail, "user-2", time.Second)
+	mr.FastForward(2 * time.Second)
+	payload, _, _ := strings.Cut(latest, ".")
+	foreign, _ := NewOneTimeTokens(tokens.client, []byte("another-secret")).Issue(ctx, PurposeVerifyEmail, "user-3", time.Hour)
+
+	tests := map[string]string{
+		"superseded": superseded,
+		"expired":    expired,
+		"unsigned":   payload,
+		"resigned":   payload + ".AAAA",
+		"foreign":    foreign,
+		"garbage":    "not-a-token",
+	}
+	for name, tok := range tests {
+		if _, err := tokens.Consume(ctx, PurposeVerifyEmail, tok); !errors.Is(err, ErrInvalidOneTimeToken) {
+			t.Errorf("Consume(%s) error = %v, expected %v", name, err, ErrInvalidOneTimeToken)
+		}
+	}
+	if _, err := tokens.Consume(ctx, PurposeVerifyEmail, latest); err != nil {
+		t.Errorf("Consume(latest) error = %v", err)
+	}
+}
+
+func TestOneTimeTokensThrottle(t *testing.T) {
+	tokens, mr := newTestOneTimeTokens(t)
+	ctx := context.Background()
+
+	if wait, err := tokens.Throttle(ctx, PurposeVerifyEmail, "a@example.org", time.Minute); err != nil || wait != 0 {
+		t.Fatalf("Throttle() first = %s, %v", wait, err)
+	}
+	if wait, _ := tokens.Throttle(ctx, PurposeVerifyEmail, "a@example.org", time.Minute); wait <= 0 {
+		t.Errorf("Throttle() second = %s, expected a wait", wait)
+	}
+	if wait, _ := tokens.Throttle(ctx, PurposeVerifyEmail, "b@example.org", time.Minute); wait != 0 {
+		t.Errorf("Throttle() other subject = %s, expected no wait", wait)
+	}
+	mr.FastForward(time.Minute)
+	if wait, _ := tokens.Throttle(ctx, PurposeVerifyEmail, "a@example.org", time.Minute); wait != 0 {
+		t.Errorf("Throttle() after interval = %s, expected no wait", wait)
+	}
+}
@@ -0,0 +1,175 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+const (
+	// maxUserAgent bounds what a client can make us store per session.
+	maxUserAgent = 256
+	// touchInterval is how stale LastSeen may get before a request updates
+	// it, so that not every request writes to Redis.
+	touchInterval = time.Minute
+)
+
+// Device describes the client a login came from.
+type Device struct {
+	UserAgent string
+	IP        string
+}
+
+// Session is one login: a refresh family together with the client it was
+// started from. Access tokens carry its ID in the sid claim and stop working
+// as soon as the session is revoked.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	// TokenID is the jti of the access token last used in the session.
+	TokenID string `json:"jti,omitempty"`
+	// ClientID is set for sessions of OAuth clients, which may only act
+	// within Scope.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// Session returns the live session with the given ID.
+func (s *Store) Session(ctx context.Context, id string) (*Session, error) {
+	raw, err := s.client.Get(ctx, fmt.Sprintf(refreshFamilyKey, id)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load session: %w", err)
+	}
+	return decodeSession(id, raw)
+}
+
+// Sessions returns the live sessions of userID, most recently used first.
+func (s *Store) Sessions(ctx context.Context, userID string) ([]Session, error) {
+	userKey := fmt.Sprintf(refreshUserKey, userID)
+	families, err := s.client.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("load refresh families: %w", err)
+	}
+	if len(families) == 0 {
+		return []Session{}, nil
+	}
+	keys := make([]string, len(families))
+	for i, family := range families {
+		keys[i] = fmt.Sprintf(refreshFamilyKey, family)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("load sessions: %w", err)
+	}
+
+	sessions := make([]Session, 0, len(families))
+	var ended []interface{}
+	for i, v := range values {
+		raw, ok := v.(string)
+		if !ok {
+			ended = append(ended, families[i])
+			continue
+		}
+		session, err := decodeSession(families[i], raw)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	if len(ended) > 0 {
+		// Expired and reused families are only dropped from the index here.
+		s.client.SRem(ctx, userKey, ended...)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// RevokeSession ends the session id of userID. Its refresh token stops
+// working at once and so do its access tokens, since JwtMiddleware checks
+// the session on every request.
+func (s *Store) RevokeSession(ctx context.Context, userID, id string) error {
+	session, err := s.Session(ctx, id)
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID {
+		return ErrSessionNotFound
+	}
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, fmt.Sprintf(refreshFamilyKey, id))
+	pipe.SRem(ctx, fmt.Sprintf(refreshUserKey, userID), id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	return nil
+}
+
+// Touch records that the access token jti of session id was used. It
+// returns ErrSessionNotFound once the session was revoked or has expired.
+func (s *Store) Touch(ctx context.Context, id, jti string) error {
+	session, err := s.Session(ctx, id)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if session.TokenID == jti && now.Sub(session.LastSeen) < touchInterval {
+		return nil
+	}
+	session.TokenID, session.LastSeen = jti, now
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	// XX keeps a session revoked in the meantime from coming back.
+	err = s.client.SetArgs(ctx, fmt.Sprintf(refreshFamilyKey, id), raw, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if errors.Is(err, redis.Nil) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("touch session: %w", err)
+	}
+	return nil
+}
+
+// decodeSession reads a refresh family value. Families started before
+// sessions were recorded hold just the user ID.
+func decodeSession(id, raw string) (*Session, error) {
+	if !strings.HasPrefix(raw, "{") {
+		return &Session{ID: id, UserID: raw}, nil
+	}
+	var session Session
+	if err := json.Unmarshal([]byte(raw), &session); err != nil {
+		return nil, fmt.Errorf("decode session: %w", err)
+	}
+	session.ID = id
+	return &session, nil
+}
+
+// truncate cuts s to at most n bytes without splitting a character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
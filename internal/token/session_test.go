@@ -0,0 +1,56 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStoreSessions(t *testing.T) {
+	store, mr := newTestStore(t)
+	ctx := context.Background()
+
+	laptop, laptopToken, err := store.Issue(ctx, "user-1", Device{UserAgent: "Firefox", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	phone, _, _ := store.Issue(ctx, "user-1", Device{UserAgent: strings.Repeat("é", maxUserAgent), IP: "10.0.0.2"})
+	if len(phone.UserAgent) > maxUserAgent || !strings.HasSuffix(phone.UserAgent, "é") {
+		t.Errorf("Issue() user agent of %d bytes, expected at most %d whole characters", len(phone.UserAgent), maxUserAgent)
+	}
+	if err := store.Touch(ctx, phone.ID, "jti-phone"); err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+
+	sessions, err := store.Sessions(ctx, "user-1")
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("Sessions() = %+v, %v, expected 2 sessions", sessions, err)
+	}
+	if sessions[0].ID != phone.ID || sessions[0].TokenID != "jti-phone" || sessions[1].UserAgent != "Firefox" {
+		t.Errorf("Sessions() = %+v, expected the phone, last seen, first", sessions)
+	}
+
+	if err := store.RevokeSession(ctx, "user-2", laptop.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("RevokeSession(other user) error = %v, expected %v", err, ErrSessionNotFound)
+	}
+	if err := store.RevokeSession(ctx, "user-1", laptop.ID); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+	if err := store.Touch(ctx, laptop.ID, "jti-laptop"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Touch(revoked) error = %v, expected %v", err, ErrSessionNotFound)
+	}
+	if _, _, err := store.Rotate(ctx, laptopToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Rotate() of a revoked session error = %v, expected %v", err, ErrInvalidRefreshToken)
+	}
+	if sessions, _ := store.Sessions(ctx, "user-1"); len(sessions) != 1 || sessions[0].ID != phone.ID {
+		t.Errorf("Sessions() after revoke = %+v, expected only the phone", sessions)
+	}
+
+	// Families started before sessions were recorded hold the user ID only.
+	mr.Set(fmt.Sprintf(refreshFamilyKey, "legacy"), "user-3")
+	if session, err := store.Session(ctx, "legacy"); err != nil || session.UserID != "user-3" {
+		t.Errorf("Session(legacy) = %+v, %v", session, err)
+	}
+}
@@ -0,0 +1,245 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	refreshTokenKey  = "refresh-token:%s"
+	refreshUsedKey   = "refresh-used:%s"
+	refreshFamilyKey = "refresh-family:%s"
+	refreshUserKey   = "refresh-user:%s"
+	deniedTokenKey   = "jwt-deny:%s"
+	revokedUserKey   = "jwt-revoked-before:%s"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused, session revoked")
+)
+
+type refreshEntry struct {
+	UserID string `json:"user_id"`
+	Family string `json:"family"`
+}
+
+// Store keeps refresh tokens, sessions and the access token denylist in
+// Redis. Refresh tokens are opaque random strings stored only as SHA-256
+// digests. Every login starts a family, which is the session of that login;
+// each refresh rotates the token inside the family, and presenting an
+// already rotated token revokes the whole family.
+type Store struct {
+	client     *redis.Client
+	refreshTTL time.Duration
+}
+
+func NewStore(client *redis.Client, refreshTTL time.Duration) *Store {
+	return &Store{client: client, refreshTTL: refreshTTL}
+}
+
+// Issue starts a new session for userID logged in from device and returns
+// it with the first refresh token of its family.
+func (s *Store) Issue(ctx context.Context, userID string, device Device) (*Session, string, error) {
+	return s.issue(ctx, &Session{UserID: userID, UserAgent: device.UserAgent, IP: device.IP})
+}
+
+// IssueClient starts a session in which the OAuth client clientID acts for
+// userID within scope. Its refresh tokens are only good for that client.
+func (s *Store) IssueClient(ctx context.Context, userID, clientID, scope string, device Device) (*Session, string, error) {
+	return s.issue(ctx, &Session{UserID: userID, ClientID: clientID, Scope: scope, UserAgent: device.UserAgent, IP: device.IP})
+}
+
+func (s *Store) issue(ctx context.Context, session *Session) (*Session, string, error) {
+	family, err := randomString(16)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	session.ID = family
+	session.UserAgent = truncate(session.UserAgent, maxUserAgent)
+	session.CreatedAt, session.LastSeen = now, now
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return nil, "", err
+	}
+	userKey := fmt.Sprintf(refreshUserKey, session.UserID)
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, fmt.Sprintf(refreshFamilyKey, family), raw, s.refreshTTL)
+	pipe.SAdd(ctx, userKey, family)
+	pipe.Expire(ctx, userKey, s.refreshTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, "", fmt.Errorf("store refresh family: %w", err)
+	}
+	refreshToken, err := s.issueInFamily(ctx, session.UserID, family)
+	if err != nil {
+		return nil, "", err
+	}
+	return session, refreshToken, nil
+}
+
+// Rotate consumes refreshToken and returns the session it belongs to
+// together with its replacement.
+func (s *Store) Rotate(ctx context.Context, refreshToken string) (*Session, string, error) {
+	digest := digest(refreshToken)
+	raw, err := s.client.GetDel(ctx, fmt.Sprintf(refreshTokenKey, digest)).Result()
+	if errors.Is(err, redis.Nil) {
+		family, err := s.client.Get(ctx, fmt.Sprintf(refreshUsedKey, digest)).Result()
+		if err == nil {
+			s.client.Del(ctx, fmt.Sprintf(refreshFamilyKey, family))
+			return nil, "", ErrRefreshTokenReused
+		}
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("load refresh token: %w", err)
+	}
+
+	var entry refreshEntry
+	if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+		return nil, "", fmt.Errorf("decode refresh token: %w", err)
+	}
+	if err := s.client.Set(ctx, fmt.Sprintf(refreshUsedKey, digest), entry.Family, s.refreshTTL).Err(); err != nil {
+		return nil, "", fmt.Errorf("mark refresh token used: %w", err)
+	}
+	// The user index must live as long as the family, or RevokeUser and
+	// Sessions would no longer find a session that keeps being refreshed.
+	userKey := fmt.Sprintf(refreshUserKey, entry.UserID)
+	pipe := s.client.TxPipeline()
+	extended := pipe.Expire(ctx, fmt.Sprintf(refreshFamilyKey, entry.Family), s.refreshTTL)
+	pipe.SAdd(ctx, userKey, entry.Family)
+	pipe.Expire(ctx, userKey, s.refreshTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, "", fmt.Errorf("load refresh family: %w", err)
+	}
+	if !extended.Val() {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	session, err := s.Session(ctx, entry.Family)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	next, err := s.issueInFamily(ctx, entry.UserID, entry.Family)
+	if err != nil {
+		return nil, "", err
+	}
+	return session, next, nil
+}
+
+// Revoke ends the family refreshToken belongs to. Unknown tokens are ignored.
+func (s *Store) Revoke(ctx context.Context, refreshToken string) error {
+	digest := digest(refreshToken)
+	family, err := s.client.Get(ctx, fmt.Sprintf(refreshUsedKey, digest)).Result()
+	if errors.Is(err, redis.Nil) {
+		raw, err := s.client.GetDel(ctx, fmt.Sprintf(refreshTokenKey, digest)).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("load refresh token: %w", err)
+		}
+		var entry refreshEntry
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+			return fmt.Errorf("decode refresh token: %w", err)
+		}
+		family = entry.Family
+	} else if err != nil {
+		return fmt.Errorf("load refresh token: %w", err)
+	}
+	return s.client.Del(ctx, fmt.Sprintf(refreshFamilyKey, family)).Err()
+}
+
+// RevokeUser ends every refresh family of userID and rejects its access
+// tokens issued up to now. Token times have whole seconds, so tokens issued
+// within the same second as the call are rejected as well, even those of a
+// login right after it.
+func (s *Store) RevokeUser(ctx context.Context, userID string) error {
+	userKey := fmt.Sprintf(refreshUserKey, userID)
+	families, err := s.client.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return fmt.Errorf("load refresh families: %w", err)
+	}
+	pipe := s.client.TxPipeline()
+	for _, family := range families {
+		pipe.Del(ctx, fmt.Sprintf(refreshFamilyKey, family))
+	}
+	pipe.Del(ctx, userKey)
+	// Access tokens never outlive refresh tokens, so the marker can expire
+	// with them.
+	pipe.Set(ctx, fmt.Sprintf(revokedUserKey, userID), time.Now().Unix(), s.refreshTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("revoke user sessions: %w", err)
+	}
+	return nil
+}
+
+// IsRevoked reports whether tokens of userID issued at issuedAt, in Unix
+// seconds, were revoked by RevokeUser.
+func (s *Store) IsRevoked(ctx context.Context, userID string, issuedAt int64) (bool, error) {
+	before, err := s.client.Get(ctx, fmt.Sprintf(revokedUserKey, userID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return issuedAt <= before, nil
+}
+
+// Deny puts an access token id on the denylist until the token expires.
+func (s *Store) Deny(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, fmt.Sprintf(deniedTokenKey, jti), 1, ttl).Err()
+}
+
+func (s *Store) IsDenied(ctx context.Context, jti string) (bool, error) {
+	n, err := s.client.Exists(ctx, fmt.Sprintf(deniedTokenKey, jti)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (s *Store) issueInFamily(ctx context.Context, userID, family string) (string, error) {
+	refreshToken, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(refreshEntry{UserID: userID, Family: family})
+	if err != nil {
+		return "", err
+	}
+	if err := s.client.Set(ctx, fmt.Sprintf(refreshTokenKey, digest(refreshToken)), raw, s.refreshTTL).Err(); err != nil {
+		return "", fmt.Errorf("store refresh token: %w", err)
+	}
+	return refreshToken, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("random: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func digest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,173 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestStore(t *testing.T) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return NewStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Hour), mr
+}
+
+func TestStoreRotate(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	_, first, err := store.Issue(ctx, "user-1", Device{})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	session, second, err := store.Rotate(ctx, first)
+	if err != nil || session.UserID != "user-1" || second == first {
+		t.Fatalf("Rotate(first) = %+v, %s, %v", session, second, err)
+	}
+
+	if _, _, err := store.Rotate(ctx, first); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("Rotate(first) again error = %v, expected %v", err, ErrRefreshTokenReused)
+	}
+	if _, _, err := store.Rotate(ctx, second); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Rotate(second) after reuse error = %v, expected %v", err, ErrInvalidRefreshToken)
+	}
+	if _, _, err := store.Rotate(ctx, "unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Rotate(unknown) error = %v, expected %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+func TestStoreRevoke(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	_, first, _ := store.Issue(ctx, "user-1", Device{})
+	_, second, _ := store.Rotate(ctx, first)
+	_, other, _ := store.Issue(ctx, "user-1", Device{})
+
+	if err := store.Revoke(ctx, first); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if _, _, err := store.Rotate(ctx, second); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Rotate(second) after revoke error = %v, expected %v", err, ErrInvalidRefreshToken)
+	}
+	if _, _, err := store.Rotate(ctx, other); err != nil {
+		t.Errorf("Rotate(other family) error = %v", err)
+	}
+}
+
+func TestStoreDeny(t *testing.T) {
+	store, mr := newTestStore(t)
+	ctx := context.Background()
+
+	if err := store.Deny(ctx, "jti-1", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"jti-1": true,
+		"jti-2": false,
+	}
+	for input, expected := range tests {
+		result, err := store.IsDenied(ctx, input)
+		if err != nil || result != expected {
+			t.Errorf("IsDenied(%s) = %v, %v, expected %v", input, result, err, expected)
+		}
+	}
+
+	mr.FastForward(2 * time.Minute)
+	if denied, _ := store.IsDenied(ctx, "jti-1"); denied {
+		t.Errorf("IsDenied(jti-1) = true after the token expired")
+	}
+}
+
+func TestStoreRevokeUser(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	_, first, _ := store.Issue(ctx, "user-1", Device{})
+	_, second, _ := store.Issue(ctx, "user-1", Device{})
+	_, other, _ := store.Issue(ctx, "user-2", Device{})
+	issuedAt := time.Now().Add(-time.Second).Unix()
+
+	if err := store.RevokeUser(ctx, "user-1"); err != nil {
+		t.Fatalf("RevokeUser() error = %v", err)
+	}
+	for _, tok := range []string{first, second} {
+		if _, _, err := store.Rotate(ctx, tok); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("Rotate() after RevokeUser error = %v, expected %v", err, ErrInvalidRefreshToken)
+		}
+	}
+	if _, _, err := store.Rotate(ctx, other); err != nil {
+		t.Errorf("Rotate(other user) error = %v", err)
+	}
+
+	tests := []struct {
+		userID   string
+		issuedAt int64
+		expected bool
+	}{
+		{"user-1", issuedAt, true},
+		{"user-1", time.Now().Add(time.Second).Unix(), false},
+		{"user-2", issuedAt, false},
+	}
+	for _, tt := range tests {
+		if revoked, err := store.IsRevoked(ctx, tt.userID, tt.issuedAt); err != nil || revoked != tt.expected {
+			t.Errorf("IsRevoked(%s, %d) = %v, %v, expected %v", tt.userID, tt.issuedAt, revoked, err, tt.expected)
+		}
+	}
+}
+
+func TestStoreRevokeUserSameSecond(t *testing.T) {
+	store, mr := newTestStore(t)
+	ctx := context.Background()
+
+	if err := store.RevokeUser(ctx, "user-1"); err != nil {
+		t.Fatalf("RevokeUser() error = %v", err)
+	}
+	// A token issued in the second RevokeUser recorded, whether just
+	// before or just after the call.
+	marker, err := mr.Get(fmt.Sprintf(revokedUserKey, "user-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuedAt, err := strconv.ParseInt(marker, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := store.IsRevoked(ctx, "user-1", issuedAt); err != nil || !revoked {
+		t.Errorf("IsRevoked(user-1, %d) = %v, %v, expected the revocation's own second to be revoked", issuedAt, revoked, err)
+	}
+}
+
+func TestStoreRotateKeepsUserIndex(t *testing.T) {
+	store, mr := newTestStore(t)
+	ctx := context.Background()
+
+	_, refreshToken, err := store.Issue(ctx, "user-1", Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Refreshing every 40 minutes keeps the session alive well past the
+	// hour the index was first stored for.
+	for i := 0; i < 3; i++ {
+		mr.FastForward(40 * time.Minute)
+		if _, refreshToken, err = store.Rotate(ctx, refreshToken); err != nil {
+			t.Fatalf("Rotate() after %d refreshes error = %v", i, err)
+		}
+	}
+	if sessions, err := store.Sessions(ctx, "user-1"); err != nil || len(sessions) != 1 {
+		t.Errorf("Sessions() = %+v, %v, expected the refreshed session", sessions, err)
+	}
+
+	if err := store.RevokeUser(ctx, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Rotate(ctx, refreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Rotate() after RevokeUser error = %v, expected %v", err, ErrInvalidRefreshToken)
+	}
+}
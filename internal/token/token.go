@@ -1,36 +1,161 @@
 package token
 
 import (
+	"errors"
 	"fmt"
-	"strings"
 	"time"
 
 	"awesomeProject/users"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var secretKey = []byte("EqIN6cImvf7fUZJXK4YL")
+const claimsContextKey = "token-claims"
 
+// Claims of an access token. The registered claims identify the user (sub,
+// the hex ObjectID), the issuing service (iss), who may accept the token
+// (aud), its lifetime (iat, nbf, exp) and the token itself (jti). Manager
+// requires all of them.
 type Claims struct {
-	UserID       string
-	UserRole     string
-	UserNickname string
-	jwt.StandardClaims
-}
-
-func GenerateToken(user *users.User) (string, error) {
-	claims := &Claims{
-		UserID:       user.ID.String(),
-		UserRole:     user.Role,
-		UserNickname: user.Nickname,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
-		},
+	UserRole     string `json:"role"`
+	UserNickname string `json:"nickname"`
+	// EmailVerified is the account's verification state when the token was
+	// issued; it changes for the client on the next refresh.
+	EmailVerified bool `json:"email_verified"`
+	// SessionID is the login the token was issued in. Tokens issued before
+	// sessions were recorded have none.
+	SessionID string `json:"sid,omitempty"`
+	// APIKeyID is set when the request was made with an API key instead of
+	// an access token. Scopes then limits what the request may do.
+	APIKeyID string             `json:"-"`
+	Scopes   []users.Permission `json:"-"`
+	// ClientID and Scope are set in tokens issued to OAuth clients (RFC
+	// 9068). Those tokens have another audience and only work at the
+	// userinfo endpoint.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Can reports whether the credential grants p: the role has to grant it
+// and, for API keys, so does one of the key's scopes.
+func (c *Claims) Can(p users.Permission) bool {
+	if !users.HasPermission(c.UserRole, p) {
+		return false
+	}
+	if c.APIKeyID == "" {
+		return true
+	}
+	for _, scope := range c.Scopes {
+		if scope == p {
+			return true
+		}
+	}
+	return false
+}
+
+// UserID is the hex ObjectID of the user the token was issued to.
+func (c *Claims) UserID() string {
+	return c.Subject
+}
+
+// Validate checks the claims jwt does not check by itself. It runs after the
+// signature, time, issuer and audience checks.
+func (c *Claims) Validate() error {
+	if _, err := primitive.ObjectIDFromHex(c.Subject); err != nil {
+		return fmt.Errorf("%w: sub is not a user ID", jwt.ErrTokenInvalidClaims)
+	}
+	if c.ID == "" {
+		return fmt.Errorf("%w: jti is missing", jwt.ErrTokenInvalidClaims)
+	}
+	if c.IssuedAt == nil || c.NotBefore == nil {
+		return fmt.Errorf("%w: iat or nbf is missing", jwt.ErrTokenInvalidClaims)
+	}
+	return nil
+}
+
+// Pair is what a client receives after logging in or refreshing.
+type Pair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// leeway is the clock skew allowed between us and other services that
+// verify our tokens.
+const leeway = 30 * time.Second
+
+// Manager signs access tokens with the signing key of its KeySet and
+// accepts tokens from every key of the set that were issued by issuer for
+// audience.
+type Manager struct {
+	keys      *KeySet
+	issuer    string
+	audience  string
+	accessTTL time.Duration
+}
+
+func NewManager(keys *KeySet, issuer, audience string, accessTTL time.Duration) *Manager {
+	return &Manager{keys: keys, issuer: issuer, audience: audience, accessTTL: accessTTL}
+}
+
+// Keys returns the keys tokens are signed and verified with.
+func (m *Manager) Keys() *KeySet {
+	return m.keys
+}
+
+// Issuer is the iss claim of tokens made by GenerateToken.
+func (m *Manager) Issuer() string {
+	return m.issuer
+}
+
+// AccessTTL is the lifetime of tokens made by GenerateToken.
+func (m *Manager) AccessTTL() time.Duration {
+	return m.accessTTL
+}
+
+// GenerateToken issues an access token for user in the session sessionID.
+func (m *Manager) GenerateToken(user *users.User, sessionID string) (string, error) {
+	claims, err := m.newClaims(user, sessionID, m.audience)
+	if err != nil {
+		return "", err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(secretKey)
+	return m.sign(claims)
+}
+
+func (m *Manager) newClaims(user *users.User, sessionID, audience string) (*Claims, error) {
+	jti, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &Claims{
+		UserRole:      user.Role,
+		UserNickname:  user.Nickname,
+		EmailVerified: user.EmailVerified,
+		SessionID:     sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   user.ID.Hex(),
+			Issuer:    m.issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.accessTTL)),
+		},
+	}, nil
+}
+
+// sign signs claims with the signing key and names the key in the kid
+// header.
+func (m *Manager) sign(claims jwt.Claims) (string, error) {
+	signing := m.keys.Signing()
+	token := jwt.NewWithClaims(signing.Method, claims)
+	token.Header["kid"] = signing.ID
+	tokenString, err := token.SignedString(signing.private)
 	if err != nil {
 		return "", fmt.Errorf("SignedString: %s", err)
 	}
@@ -38,52 +163,143 @@ func GenerateToken(user *users.User) (string, error) {
 	return tokenString, nil
 }
 
-func JwtMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		tokenString := c.Request().Header.Get("authorization")
-		if tokenString == "" {
-			return echo.ErrUnauthorized
-		}
+// ParseToken verifies tokenString: its signature, that it is within its
+// lifetime, that it was issued by us for our audience, and that it carries
+// every claim GenerateToken sets. Access tokens of users are only checked
+// here; handlers read the result with ClaimsFromContext.
+func (m *Manager) ParseToken(tokenString string) (*Claims, error) {
+	return m.parse(tokenString, m.audience)
+}
+
+func (m *Manager) parse(tokenString, audience string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, m.verificationKey,
+		jwt.WithIssuer(m.issuer),
+		jwt.WithAudience(audience),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %s", err)
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if token.Method != jwt.SigningMethodHS256 {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// verificationKey picks the key named by the kid header. The algorithm must
+// be the one of that key, so an RSA public key can never be used as an
+// HMAC secret.
+func (m *Manager) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := m.keys.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// JwtMiddleware accepts requests with a valid access token that was neither
+// logged out nor revoked, and whose session is still alive. With apiKeys it
+// accepts API keys as well; handlers see their owner's claims either way.
+func (m *Manager) JwtMiddleware(store *Store, apiKeys *APIKeys) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+			tokenString, apiKey := Credentials(c.Request())
+			if apiKey != "" && apiKeys != nil {
+				claims, err := apiKeys.Authenticate(ctx, apiKey)
+				if err != nil {
+					if !errors.Is(err, ErrInvalidAPIKey) {
+						log.Errorf("check api key: %s", err)
+					}
+					return echo.ErrUnauthorized
+				}
+				SetClaims(c, claims)
+				return next(c)
+			}
+			if tokenString == "" {
+				return echo.ErrUnauthorized
+			}
+
+			claims, err := m.ParseToken(tokenString)
+			if err != nil {
+				return echo.ErrUnauthorized
+			}
+			denied, err := store.IsDenied(ctx, claims.ID)
+			if err == nil && !denied {
+				denied, err = store.IsRevoked(ctx, claims.UserID(), claims.IssuedAt.Unix())
+			}
+			if err != nil {
+				log.Errorf("check token denylist: %s", err)
+				return echo.ErrUnauthorized
+			}
+			if denied {
+				return echo.ErrUnauthorized
 			}
+			if claims.SessionID != "" {
+				if err := store.Touch(ctx, claims.SessionID, claims.ID); err != nil {
+					if !errors.Is(err, ErrSessionNotFound) {
+						log.Errorf("check session: %s", err)
+					}
+					return echo.ErrUnauthorized
+				}
+			}
+			SetClaims(c, claims)
 
-			return secretKey, nil
-		})
+			return next(c)
+		}
+	}
+}
 
-		UserRole := token.Claims.(jwt.MapClaims)["UserRole"]
+// RequirePermission rejects requests whose credential does not grant every
+// permission in perms. It must run after JwtMiddleware.
+func RequirePermission(perms ...users.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims := ClaimsFromContext(c)
+			if claims == nil {
+				return echo.ErrUnauthorized
+			}
+			for _, p := range perms {
+				if !claims.Can(p) {
+					return echo.ErrForbidden
+				}
+			}
+			return next(c)
+		}
+	}
+}
 
-		if err != nil || !token.Valid {
+// RequireLoginToken rejects requests made with an API key. It guards what
+// automation must not reach whatever its scopes, such as managing
+// credentials. It must run after JwtMiddleware.
+func RequireLoginToken(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims := ClaimsFromContext(c)
+		if claims == nil {
 			return echo.ErrUnauthorized
 		}
-		if UserRole != "admin" {
+		if claims.APIKeyID != "" {
 			return echo.ErrForbidden
 		}
-
 		return next(c)
 	}
 }
 
-func GetUserNicknameFromToken(t string) (string, error) {
-	parts := strings.Split(t, ".")
-	if len(parts) != 3 {
-		return "", fmt.Errorf("Invalid token format")
-	}
-	claims := jwt.MapClaims{}
-	_, err := jwt.ParseWithClaims(t, claims, func(token *jwt.Token) (interface{}, error) {
-		if token.Method != jwt.SigningMethodHS256 {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return secretKey, nil
-	})
-	if err != nil {
-		return "", fmt.Errorf("parse error: %s", err)
-	}
-	userNickname, ok := claims["UserNickname"].(string)
-	if !ok {
-		return "", fmt.Errorf("nickname not found in claims")
-	}
-	return userNickname, nil
+// SetClaims stores claims on c for ClaimsFromContext.
+func SetClaims(c echo.Context, claims *Claims) {
+	c.Set(claimsContextKey, claims)
+}
+
+// ClaimsFromContext returns the claims stored by JwtMiddleware, or nil when
+// the request was not authenticated.
+func ClaimsFromContext(c echo.Context) *Claims {
+	claims, _ := c.Get(claimsContextKey).(*Claims)
+	return claims
 }
@@ -0,0 +1,73 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"awesomeProject/users"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGenerateTokenClaims(t *testing.T) {
+	m := NewManager(HMACKeys([]byte("test-secret-that-is-long-enough!!")), "https://users.example", "users-api", time.Minute)
+	user := &users.User{ID: primitive.NewObjectID(), Nickname: "oleksii", Role: users.RoleUser}
+	tok, err := m.GenerateToken(user, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := m.ParseToken(tok)
+	if err != nil {
+		t.Fatalf("ParseToken() error = %v", err)
+	}
+	if claims.UserID() != user.ID.Hex() || claims.Issuer != "https://users.example" || claims.ID == "" || claims.SessionID != "session-1" {
+		t.Errorf("ParseToken() = sub %s, iss %s, jti %q, sid %q", claims.Subject, claims.Issuer, claims.ID, claims.SessionID)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != "users-api" || claims.NotBefore == nil || claims.IssuedAt == nil {
+		t.Errorf("ParseToken() = aud %v, nbf %v, iat %v", claims.Audience, claims.NotBefore, claims.IssuedAt)
+	}
+}
+
+func TestParseTokenRejectsInvalidClaims(t *testing.T) {
+	keys := HMACKeys([]byte("test-secret-that-is-long-enough!!"))
+	m := NewManager(keys, "https://users.example", "users-api", time.Minute)
+	now := time.Now()
+	valid := func() *Claims {
+		return &Claims{UserNickname: "oleksii", RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			Subject:   primitive.NewObjectID().Hex(),
+			Issuer:    "https://users.example",
+			Audience:  jwt.ClaimStrings{"users-api"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		}}
+	}
+
+	tests := map[string]func(c *Claims){
+		"valid":            func(c *Claims) {},
+		"other issuer":     func(c *Claims) { c.Issuer = "https://evil.example" },
+		"other audience":   func(c *Claims) { c.Audience = jwt.ClaimStrings{"billing"} },
+		"no audience":      func(c *Claims) { c.Audience = nil },
+		"expired":          func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Hour)) },
+		"no expiry":        func(c *Claims) { c.ExpiresAt = nil },
+		"not yet valid":    func(c *Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour)) },
+		"issued in future": func(c *Claims) { c.IssuedAt = jwt.NewNumericDate(now.Add(time.Hour)) },
+		"no nbf":           func(c *Claims) { c.NotBefore = nil },
+		"no jti":           func(c *Claims) { c.ID = "" },
+		"legacy subject":   func(c *Claims) { c.Subject = `ObjectID("` + c.Subject + `")` },
+	}
+	for name, change := range tests {
+		claims := valid()
+		change(claims)
+		tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(keys.Signing().private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = m.ParseToken(tok)
+		if (err == nil) != (name == "valid") {
+			t.Errorf("ParseToken(%s) error = %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA1 column.
+func TestHOTPVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, expected := range tests {
+		if result := hotp(key, uint64(unix/Period), 8); result != expected {
+			t.Errorf("hotp(%d) = %s, expected %s", unix, result, expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, _ := Code(secret, now)
+
+	tests := []struct {
+		code     string
+		at       time.Time
+		expected bool
+	}{
+		{code, now, true},
+		{code, now.Add(Period * time.Second), true},
+		{code, now.Add(-Period * time.Second), true},
+		{code, now.Add(3 * Period * time.Second), false},
+		{"000000", now, code == "000000"},
+		{"12345", now, false},
+	}
+	for _, tt := range tests {
+		if _, ok := Validate(secret, tt.code, tt.at); ok != tt.expected {
+			t.Errorf("Validate(%s, %s) = %v, expected %v", tt.code, tt.at, ok, tt.expected)
+		}
+	}
+	if step, _ := Validate(secret, code, now); step != Step(now) {
+		t.Errorf("Validate() step = %d, expected %d", step, Step(now))
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("API Users", "oleksii", "JBSWY3DPEHPK3PXP")
+	for _, want := range []string{"otpauth://totp/API%20Users:oleksii?", "secret=JBSWY3DPEHPK3PXP", "issuer=API+Users", "digits=6", "period=30"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("URI() = %s, missing %s", uri, want)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil || len(codes) != 10 {
+		t.Fatalf("GenerateRecoveryCodes(10) = %v, %v", codes, err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Errorf("unexpected recovery code %q", code)
+		}
+		seen[code] = true
+	}
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+strings.ToLower(strings.ReplaceAll(codes[0], "-", ""))) {
+		t.Errorf("HashRecoveryCode() is not normalized")
+	}
+}
@@ -0,0 +1,135 @@
+package validation
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// prefixLength is the number of hex digits of a SHA-1 hash that select a
+// range, as in the k-anonymity range API of Have I Been Pwned.
+const prefixLength = 5
+
+// BreachedPasswords looks passwords up by their SHA-1 hash in a local copy
+// of a leaked password corpus, so the check works offline and passwords
+// never leave the process.
+//
+// The corpus is either one file with a full upper or lower case hex hash
+// per line, or a directory of range files named by the 5 digit hash prefix
+// (ABCDE or ABCDE.txt) holding the remaining 35 digits per line. Anything
+// after a colon, such as the HIBP occurrence count, is ignored. A file is
+// loaded into memory; a directory is read one range at a time on lookup,
+// which suits the full corpus of several hundred million hashes.
+type BreachedPasswords struct {
+	dir    string
+	ranges map[string]map[string]struct{}
+	// warned holds the range files whose malformed lines were logged.
+	warned sync.Map
+}
+
+// LoadBreachedPasswords opens the corpus at path.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &BreachedPasswords{dir: path}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b := &BreachedPasswords{ranges: make(map[string]map[string]struct{})}
+	err = readHashes(f, 40, func(hash string) {
+		prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+		if b.ranges[prefix] == nil {
+			b.ranges[prefix] = make(map[string]struct{})
+		}
+		b.ranges[prefix][suffix] = struct{}{}
+	}, func(line int, hash string) error {
+		return fmt.Errorf("line %d: expected 40 hex digits, got %q", line, hash)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+// readHashes calls add with every hash of size hex digits in r, upper
+// cased, and bad with the other lines. An error from bad stops the scan.
+func readHashes(r io.Reader, size int, add func(string), bad func(line int, hash string) error) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if hash == "" || strings.HasPrefix(hash, "#") {
+			continue
+		}
+		if len(hash) != size || strings.Trim(hash, "0123456789abcdefABCDEF") != "" {
+			if err := bad(line, hash); err != nil {
+				return err
+			}
+			continue
+		}
+		add(strings.ToUpper(hash))
+	}
+	return scanner.Err()
+}
+
+// Contains reports whether password is in the corpus. A range file that
+// cannot be read counts as not containing it, so a damaged corpus weakens
+// the check instead of blocking every registration. Malformed lines of a
+// range file are skipped, and logged the first time the file is read.
+func (b *BreachedPasswords) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+	if b.dir == "" {
+		_, ok := b.ranges[prefix][suffix]
+		return ok
+	}
+
+	for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+		f, err := os.Open(filepath.Join(b.dir, name))
+		if err != nil {
+			continue
+		}
+		path := f.Name()
+		found := false
+		var malformed []int
+		err = readHashes(f, 40-prefixLength, func(s string) {
+			found = found || s == suffix
+		}, func(line int, _ string) error {
+			malformed = append(malformed, line)
+			return nil
+		})
+		f.Close()
+		if len(malformed) > 0 || err != nil {
+			b.warnOnce(path, malformed, err)
+		}
+		return found
+	}
+	return false
+}
+
+func (b *BreachedPasswords) warnOnce(path string, malformed []int, err error) {
+	if _, logged := b.warned.LoadOrStore(path, true); logged {
+		return
+	}
+	if len(malformed) > 0 {
+		log.Warnf("breached passwords %s: skipped malformed lines, the first of %d at line %d", path, len(malformed), malformed[0])
+	}
+	if err != nil {
+		log.Warnf("breached passwords %s: %s", path, err)
+	}
+}
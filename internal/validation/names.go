@@ -0,0 +1,188 @@
+package validation
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Policy holds the configurable rules for user input. Name and nickname
+// lengths are counted in characters after NormalizeName.
+type Policy struct {
+	NameMinLength     int
+	NameMaxLength     int
+	NicknameMinLength int
+	NicknameMaxLength int
+	// ReservedNicknames cannot be registered. They are matched ignoring
+	// case and look-alike characters, so "Аdmin" with a Cyrillic А is
+	// reserved as well.
+	ReservedNicknames []string
+	Password          PasswordPolicy
+}
+
+// DefaultReservedNicknames are names users could mistake for staff or the
+// service itself.
+var DefaultReservedNicknames = []string{
+	"admin", "administrator", "root", "support", "system", "moderator",
+	"staff", "help", "security", "api", "null", "anonymous",
+}
+
+// DefaultPolicy keeps the nickname bounds of the old ASCII rules, allows
+// names from 2 to 32 characters and uses DefaultPasswordPolicy.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		NameMinLength:     2,
+		NameMaxLength:     32,
+		NicknameMinLength: 4,
+		NicknameMaxLength: 12,
+		ReservedNicknames: DefaultReservedNicknames,
+		Password:          DefaultPasswordPolicy(),
+	}
+}
+
+// NormalizeName trims surrounding white space and converts s to NFC, so a
+// name typed with combining accents is stored and compared like its
+// precomposed form. Names and nicknames are normalized before validation.
+func NormalizeName(s string) string {
+	return norm.NFC.String(strings.TrimSpace(s))
+}
+
+func length(id string, min, max int) Rule {
+	return Rule{
+		ID:      id,
+		Message: "must be " + strconv.Itoa(min) + " to " + strconv.Itoa(max) + " characters long",
+		Check: func(s string) bool {
+			n := utf8.RuneCountInString(s)
+			return n >= min && n <= max
+		},
+	}
+}
+
+// NameRules checks first and last names: letters of any script, with
+// single spaces, hyphens or apostrophes between them, as in "Jean-Luc",
+// "O'Brien" or "Анна Марія".
+func (p *Policy) NameRules() []Rule {
+	return []Rule{
+		length("name_length", p.NameMinLength, p.NameMaxLength),
+		{"name_characters", "may only contain letters, spaces, hyphens and apostrophes between letters", validName},
+	}
+}
+
+// NicknameRules checks nicknames: letters of one script, digits, _ and -.
+func (p *Policy) NicknameRules() []Rule {
+	return []Rule{
+		length("nickname_length", p.NicknameMinLength, p.NicknameMaxLength),
+		{"nickname_characters", "may only contain letters, digits, _ and -", validNickname},
+		{"nickname_mixed_scripts", "must not mix letters of different alphabets", singleScript},
+		{"nickname_reserved", "is reserved", func(s string) bool { return !p.Reserved(s) }},
+	}
+}
+
+// Reserved reports whether nickname looks like one of the reserved ones.
+func (p *Policy) Reserved(nickname string) bool {
+	skeleton := Skeleton(nickname)
+	for _, r := range p.ReservedNicknames {
+		if skeleton == Skeleton(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNameSeparator(r rune) bool {
+	return r == ' ' || r == '-' || r == '\'' || r == '’'
+}
+
+func validName(s string) bool {
+	prevLetter := false
+	for i, r := range s {
+		switch {
+		case unicode.IsLetter(r):
+			prevLetter = true
+		case unicode.Is(unicode.M, r):
+			// Combining marks without a precomposed form stay after NFC.
+			if i == 0 {
+				return false
+			}
+		case isNameSeparator(r):
+			if !prevLetter {
+				return false
+			}
+			prevLetter = false
+		default:
+			return false
+		}
+	}
+	return prevLetter
+}
+
+func validNickname(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.Is(unicode.M, r) && !('0' <= r && r <= '9') && r != '_' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// scripts are the alphabets told apart by singleScript. Letters of other
+// scripts are not checked against each other.
+var scripts = []*unicode.RangeTable{
+	unicode.Latin, unicode.Cyrillic, unicode.Greek, unicode.Armenian,
+	unicode.Georgian, unicode.Hebrew, unicode.Arabic, unicode.Han,
+}
+
+// singleScript rejects nicknames such as "pаypal" with a Cyrillic а, which
+// look like another user's nickname but are different strings.
+func singleScript(s string) bool {
+	var seen *unicode.RangeTable
+	for _, r := range s {
+		for _, script := range scripts {
+			if !unicode.Is(script, r) {
+				continue
+			}
+			if seen != nil && seen != script {
+				return false
+			}
+			seen = script
+		}
+	}
+	return true
+}
+
+// confusables maps letters and digits to the Latin letter they are easily
+// mistaken for. It covers the look-alikes common in Cyrillic and Greek
+// rather than the full Unicode confusables table.
+var confusables = map[rune]rune{
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'з': '3', 'і': 'i', 'ї': 'i',
+	'ј': 'j', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c',
+	'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'ԁ': 'd', 'ɡ': 'g', 'ӏ': 'l',
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+	'0': 'o', '1': 'l', '|': 'l', '5': 's', '_': '-',
+}
+
+// Skeleton reduces s to a form in which look-alike strings are equal: it
+// folds case, replaces confusable characters and drops accents, so
+// "Ádmin", "аdmin" and "ADM1N" all become "admln". It is meant for
+// comparisons only, never for display.
+func Skeleton(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		if r == 'i' {
+			// i and l are too close in many fonts to tell apart.
+			r = 'l'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
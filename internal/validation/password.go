@@ -0,0 +1,167 @@
+package validation
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy is the configurable set of password rules. Character
+// classes are off by default: length, entropy and the breached list judge
+// a password better than a required digit does.
+type PasswordPolicy struct {
+	MinLength      int
+	MaxLength      int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSpecial bool
+	// MaxBytes bounds the UTF-8 length, which hashers such as bcrypt limit
+	// regardless of MaxLength. Zero disables the check.
+	MaxBytes int
+	// MinEntropy is the lowest EstimateEntropy result accepted, in bits.
+	// Zero disables the check.
+	MinEntropy float64
+	// Breached refuses passwords found in it. Nil disables the check.
+	Breached *BreachedPasswords
+}
+
+// DefaultPasswordPolicy accepts passphrases of 8 to 64 characters that are
+// not trivially guessable.
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{MinLength: 8, MaxLength: 64, MinEntropy: 40}
+}
+
+// Rules returns the checks for a new password. identity lists what the
+// account is known by, such as its nickname and email; a password that
+// contains one of them is refused.
+func (p *PasswordPolicy) Rules(identity ...string) []Rule {
+	rules := []Rule{length("password_length", p.MinLength, p.MaxLength)}
+	if p.MaxBytes > 0 {
+		rules = append(rules, Rule{
+			ID:      "password_bytes",
+			Message: "must be at most " + strconv.Itoa(p.MaxBytes) + " bytes long; letters outside the Latin alphabet take 2 to 4 bytes each",
+			Check:   func(s string) bool { return len(s) <= p.MaxBytes },
+		})
+	}
+	if p.RequireUpper {
+		rules = append(rules, Rule{"password_upper", "must contain an uppercase letter", hasClass(unicode.IsUpper)})
+	}
+	if p.RequireLower {
+		rules = append(rules, Rule{"password_lower", "must contain a lowercase letter", hasClass(unicode.IsLower)})
+	}
+	if p.RequireDigit {
+		rules = append(rules, Rule{"password_digit", "must contain a digit", hasClass(unicode.IsDigit)})
+	}
+	if p.RequireSpecial {
+		rules = append(rules, Rule{"password_special", "must contain a special character", hasClass(isSpecial)})
+	}
+	if p.MinEntropy > 0 {
+		rules = append(rules, Rule{
+			ID:      "password_entropy",
+			Message: "is too easy to guess; use a longer password or more kinds of characters",
+			Check:   func(s string) bool { return EstimateEntropy(s) >= p.MinEntropy },
+		})
+	}
+	if words := personalWords(identity); len(words) > 0 {
+		rules = append(rules, Rule{
+			ID:      "password_personal",
+			Message: "must not contain your nickname or email",
+			Check: func(s string) bool {
+				lower := strings.ToLower(s)
+				for _, w := range words {
+					if strings.Contains(lower, w) {
+						return false
+					}
+				}
+				return true
+			},
+		})
+	}
+	if p.Breached != nil {
+		rules = append(rules, Rule{
+			ID:      "password_breached",
+			Message: "appears in a list of leaked passwords; choose another one",
+			Check:   func(s string) bool { return !p.Breached.Contains(s) },
+		})
+	}
+	return rules
+}
+
+func hasClass(is func(rune) bool) func(string) bool {
+	return func(s string) bool {
+		return strings.IndexFunc(s, is) >= 0
+	}
+}
+
+func isSpecial(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// personalWords lowercases identity values and keeps only the local part
+// of emails. Values shorter than 3 characters would refuse too many
+// passwords and are left out.
+func personalWords(identity []string) []string {
+	var words []string
+	for _, value := range identity {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			value = local
+		}
+		if utf8.RuneCountInString(value) >= 3 {
+			words = append(words, value)
+		}
+	}
+	return words
+}
+
+// EstimateEntropy gives a rough strength of s in bits: each character adds
+// log2 of the size of the character classes s draws from, but a character
+// that repeats or continues a run of its predecessor, as in "aaa" or
+// "1234", adds only one bit. It is cheap and deliberately pessimistic
+// about patterns; it does not know dictionary words, which is what the
+// breached list is for.
+func EstimateEntropy(s string) float64 {
+	pool := 0
+	var lower, upper, digit, special, other bool
+	for _, r := range s {
+		switch {
+		case 'a' <= r && r <= 'z':
+			lower = true
+		case 'A' <= r && r <= 'Z':
+			upper = true
+		case '0' <= r && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf:
+			special = true
+		default:
+			other = true
+		}
+	}
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {special, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	perChar := math.Log2(float64(pool))
+	bits := 0.0
+	prev := rune(-1)
+	for _, r := range s {
+		if r == prev || r == prev+1 || r == prev-1 {
+			bits++
+		} else {
+			bits += perChar
+		}
+		prev = r
+	}
+	return bits
+}
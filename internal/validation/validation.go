@@ -2,43 +2,32 @@ package validation
 
 import (
 	"net/mail"
-	"regexp"
-)
-
-const (
-	nicknameRegex        = "^[a-zA-Z0-9_-]{4,12}$"
-	nameRegex            = "^[a-zA-Z]{3,16}$"
-	passwordRegexLength  = `^[\d\D\S]{6,16}$`
-	passwordRegexUpper   = `[A-Z]`
-	passwordRegexDigit   = `[\d]`
-	passwordRegexSpecial = `[\W_]`
 )
 
+// NicknameValidation checks s against the nickname rules of DefaultPolicy.
 func NicknameValidation(s string) bool {
-	regexpObj := regexp.MustCompile(nicknameRegex)
-	if !regexpObj.MatchString(s) {
-		return false
-	}
-	return true
+	return passes(s, DefaultPolicy().NicknameRules())
 }
+
+// NameValidation checks s against the name rules of DefaultPolicy.
 func NameValidation(s string) bool {
-	regexpObj := regexp.MustCompile(nameRegex)
-	if !regexpObj.MatchString(s) {
-		return false
-	}
-	return true
+	return passes(s, DefaultPolicy().NameRules())
 }
-func PasswordValidation(s string) bool {
-	regexPatterns := [4]string{passwordRegexLength, passwordRegexUpper, passwordRegexDigit, passwordRegexSpecial}
-	for i := 0; i < cap(regexPatterns); i++ {
-		regexPattern := regexPatterns[i]
-		regexpObj := regexp.MustCompile(regexPattern)
-		if !regexpObj.MatchString(s) {
+
+func passes(s string, rules []Rule) bool {
+	for _, r := range rules {
+		if !r.Check(s) {
 			return false
 		}
 	}
 	return true
 }
+
+// PasswordValidation checks s against DefaultPasswordPolicy.
+func PasswordValidation(s string) bool {
+	policy := DefaultPasswordPolicy()
+	return passes(s, policy.Rules())
+}
 func EmailValidation(s string) bool {
 	_, err := mail.ParseAddress(s)
 	if err != nil {
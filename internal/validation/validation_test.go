@@ -1,13 +1,30 @@
 package validation
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 )
 
 func TestNicknameValidation(t *testing.T) {
 	tests := map[string]bool{
 		"asdaASW":        true,
 		"asdaASW12313!!": false,
+		"oleksii_99":     true,
+		"олексій":        true,
+		"Zoë-K":          true,
+		"abc":            false,
+		"thirteenchars":  false,
+		"with space":     false,
+		"pаypal":         false, // Cyrillic а
+		"admin":          false,
+		"ADM1N":          false,
+		"аdmin":          false, // Cyrillic а
+		"Ádmin":          false,
 	}
 
 	for input, expected := range tests {
@@ -22,6 +39,21 @@ func TestNameValidation(t *testing.T) {
 	tests := map[string]bool{
 		"Oleksii":    true,
 		"Oleksii123": false,
+		"Олексій":    true,
+		"José":       true,
+		"Jean-Luc":   true,
+		"O'Brien":    true,
+		"D’Angelo":   true,
+		"Анна Марія": true,
+		"李":          false,
+		"李小龍":        true,
+		"-Jean":      false,
+		"Jean-":      false,
+		"Jean--Luc":  false,
+		"Jean  Luc":  false,
+		"J0hn":       false,
+		"John!":      false,
+		"":           false,
 	}
 
 	for input, expected := range tests {
@@ -34,8 +66,14 @@ func TestNameValidation(t *testing.T) {
 
 func TestPasswordValidation(t *testing.T) {
 	tests := map[string]bool{
-		"Qwerty1123@#": true,
-		"Aaaasssdd":    false,
+		"Qwerty1123@#":                 true,
+		"Aaaasssdd":                    false,
+		"correct horse battery staple": true,
+		"a much longer passphrase than sixteen characters": true,
+		"short1!":        false,
+		"abcdefgh":       false,
+		"12345678901234": false,
+		"Zx9#qL":         false,
 	}
 
 	for input, expected := range tests {
@@ -59,3 +97,248 @@ func TestEmailValidation(t *testing.T) {
 		}
 	}
 }
+
+func TestValidator(t *testing.T) {
+	var v Validator
+	policy := DefaultPolicy()
+	v.Required("firstname", "", policy.NameRules()...)
+	v.Required("nickname", "ok_nick", policy.NicknameRules()...)
+	policy.Password.RequireDigit = true
+	v.Required("password", "abc", policy.Password.Rules("abc")...)
+	v.Optional("lastname", "", policy.NameRules()...)
+	v.Optional("email", "nope", Email)
+
+	expected := Errors{
+		{"firstname", RuleRequired, "is required"},
+		{"password", "password_length", "must be 8 to 64 characters long"},
+		{"password", "password_digit", "must contain a digit"},
+		{"password", "password_entropy", "is too easy to guess; use a longer password or more kinds of characters"},
+		{"password", "password_personal", "must not contain your nickname or email"},
+		{"email", Email.ID, Email.Message},
+	}
+	errs, ok := v.Err().(Errors)
+	if !ok || len(errs) != len(expected) {
+		t.Fatalf("Err() = %v, expected %v", v.Err(), expected)
+	}
+	for i := range expected {
+		if errs[i] != expected[i] {
+			t.Errorf("Err()[%d] = %+v, expected %+v", i, errs[i], expected[i])
+		}
+	}
+
+	var valid Validator
+	valid.Required("nickname", "ok_nick", policy.NicknameRules()...)
+	if err := valid.Err(); err != nil {
+		t.Errorf("Err() of a valid request = %v, expected nil", err)
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := map[string]string{
+		"  Oleksii ":  "Oleksii",
+		"Jose\u0301":  "José",
+		"Zoe\u0308":   "Zoë",
+		"\tАнна\n":    "Анна",
+		"already NFC": "already NFC",
+	}
+
+	for input, expected := range tests {
+		result := NormalizeName(input)
+		if result != expected {
+			t.Errorf("NormalizeName(%q) = %q, expected %q", input, result, expected)
+		}
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"admin", "ADMIN", true},
+		{"admin", "аdmin", true},
+		{"admin", "Ádmin", true},
+		{"admin", "adm1n", true},
+		{"paypal", "pаypаl", true},
+		{"root", "r00t", true},
+		{"oleksii", "oleksiy", false},
+		{"admin", "admins", false},
+	}
+
+	for _, tt := range tests {
+		result := Skeleton(tt.a) == Skeleton(tt.b)
+		if result != tt.expected {
+			t.Errorf("Skeleton(%s) == Skeleton(%s) = %v, expected %v", tt.a, tt.b, result, tt.expected)
+		}
+	}
+}
+
+func TestPolicyRules(t *testing.T) {
+	policy := &Policy{
+		NameMinLength:     1,
+		NameMaxLength:     5,
+		NicknameMinLength: 2,
+		NicknameMaxLength: 4,
+		ReservedNicknames: []string{"boss"},
+	}
+	tests := []struct {
+		rules    []Rule
+		input    string
+		expected string
+	}{
+		{policy.NameRules(), "Li", ""},
+		{policy.NameRules(), "Q", ""},
+		{policy.NameRules(), "Oleksii", "name_length"},
+		{policy.NameRules(), "Li3", "name_characters"},
+		{policy.NicknameRules(), "ab", ""},
+		{policy.NicknameRules(), "a", "nickname_length"},
+		{policy.NicknameRules(), "a.b", "nickname_characters"},
+		{policy.NicknameRules(), "aбв", "nickname_mixed_scripts"},
+		{policy.NicknameRules(), "B0SS", "nickname_reserved"},
+		{policy.NicknameRules(), "admn", ""},
+	}
+
+	for _, tt := range tests {
+		var v Validator
+		v.Required("field", tt.input, tt.rules...)
+		var failed string
+		if errs, ok := v.Err().(Errors); ok {
+			failed = errs[0].Rule
+		}
+		if failed != tt.expected {
+			t.Errorf("rules(%s) failed %q, expected %q", tt.input, failed, tt.expected)
+		}
+	}
+}
+
+func TestEstimateEntropy(t *testing.T) {
+	tests := []struct {
+		input    string
+		min, max float64
+	}{
+		{"", 0, 0},
+		{"aaaaaaaa", 5, 12},
+		{"abcdefgh", 5, 12},
+		{"qwmzpxvk", 37, 38},
+		{"Qwerty1123@#", 60, 64},
+		{"correct horse battery staple", 140, 170},
+	}
+
+	for _, tt := range tests {
+		result := EstimateEntropy(tt.input)
+		if result < tt.min || result > tt.max {
+			t.Errorf("EstimateEntropy(%s) = %.1f, expected between %.0f and %.0f", tt.input, result, tt.min, tt.max)
+		}
+	}
+}
+
+func TestPasswordPolicyRules(t *testing.T) {
+	breached, err := LoadBreachedPasswords(writeCorpus(t, "breached.txt",
+		"# SHA-1 of password1\nE38AD214943DAAD1D64C102FAEC29DE4AFE9DA3D:2413945\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &PasswordPolicy{MinLength: 8, MaxLength: 20, RequireUpper: true, RequireSpecial: true, Breached: breached}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Good-Passw0rd", ""},
+		{"Sh0rt!", "password_length"},
+		{"no-upper-case", "password_upper"},
+		{"NoSpecialChars", "password_special"},
+		{"My-Oleksii-Pass", "password_personal"},
+		{"Pass-oleksii.p", "password_personal"},
+		{"password1", "password_upper"},
+	}
+	for _, tt := range tests {
+		var v Validator
+		v.Required("password", tt.input, policy.Rules("Oleksii", "oleksii.p@example.com")...)
+		var failed string
+		if errs, ok := v.Err().(Errors); ok {
+			failed = errs[0].Rule
+		}
+		if failed != tt.expected {
+			t.Errorf("Rules(%s) failed %q, expected %q", tt.input, failed, tt.expected)
+		}
+	}
+
+	// 40 Cyrillic letters fit MaxLength but take 80 bytes.
+	policy = &PasswordPolicy{MinLength: 8, MaxLength: 72, MaxBytes: 72}
+	for input, expected := range map[string]string{
+		strings.Repeat("ж", 40):  "password_bytes",
+		strings.Repeat("ж", 36):  "",
+		strings.Repeat("ab", 36): "",
+	} {
+		var v Validator
+		v.Required("password", input, policy.Rules()...)
+		var failed string
+		if errs, ok := v.Err().(Errors); ok {
+			failed = errs[0].Rule
+		}
+		if failed != expected {
+			t.Errorf("Rules(%s) failed %q, expected %q", input, failed, expected)
+		}
+	}
+
+	policy = &PasswordPolicy{MinLength: 1, MaxLength: 64, Breached: breached}
+	var v Validator
+	v.Required("password", "password1", policy.Rules()...)
+	if errs, ok := v.Err().(Errors); !ok || errs[0].Rule != "password_breached" {
+		t.Errorf("Rules(password1) = %v, expected password_breached", v.Err())
+	}
+}
+
+func writeCorpus(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	// SHA-1 of "password1" is E38AD214943DAAD1D64C102FAEC29DE4AFE9DA3D and
+	// of "hunter2" F3BBBD66A63D4BF1747940578EC3D0103530E21D.
+	file := writeCorpus(t, "hashes.txt", "e38ad214943daad1d64c102faec29de4afe9da3d\nF3BBBD66A63D4BF1747940578EC3D0103530E21D:17\n")
+	dir := filepath.Dir(writeCorpus(t, "E38AD.txt", "214943DAAD1D64C102FAEC29DE4AFE9DA3D:2413945\n0000000000000000000000000000000000A:1\n"))
+
+	for _, path := range []string{file, dir} {
+		b, err := LoadBreachedPasswords(path)
+		if err != nil {
+			t.Fatalf("LoadBreachedPasswords(%s) error = %v", path, err)
+		}
+		if !b.Contains("password1") {
+			t.Errorf("Contains(password1) with %s = false, expected true", path)
+		}
+		if b.Contains("Good-Passw0rd") {
+			t.Errorf("Contains(Good-Passw0rd) with %s = true, expected false", path)
+		}
+	}
+
+	if _, err := LoadBreachedPasswords(writeCorpus(t, "bad.txt", "not-a-hash\n")); err == nil {
+		t.Errorf("LoadBreachedPasswords(bad.txt) error = nil")
+	}
+}
+
+func TestBreachedPasswordsSkipsMalformedRangeLines(t *testing.T) {
+	// The hash of "password1" comes after a damaged line of its range.
+	dir := filepath.Dir(writeCorpus(t, "E38AD.txt", "0000000000000000000000000000000000A:1\nnot-a-hash\n214943DAAD1D64C102FAEC29DE4AFE9DA3D:2413945\n"))
+	b, err := LoadBreachedPasswords(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	for i := 0; i < 2; i++ {
+		if !b.Contains("password1") {
+			t.Errorf("Contains(password1) = false, expected the lines after the damaged one to be checked")
+		}
+	}
+	if len(hook.AllEntries()) != 1 || hook.LastEntry().Level != log.WarnLevel {
+		t.Errorf("logged %d entries, expected one warning for the damaged range", len(hook.AllEntries()))
+	}
+}
@@ -0,0 +1,86 @@
+package validation
+
+import (
+	"strings"
+)
+
+// Rule is one check of a field value. ID identifies the rule to clients,
+// Message explains it to people.
+type Rule struct {
+	ID      string
+	Message string
+	Check   func(string) bool
+}
+
+var Email = Rule{"email", "must be a valid email address", EmailValidation}
+
+// RuleRequired is the ID reported for a missing value.
+const RuleRequired = "required"
+
+// Violation is a failed rule of one field.
+type Violation struct {
+	Field   string
+	Rule    string
+	Message string
+}
+
+// Errors lists every violation found by a Validator.
+type Errors []Violation
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, v := range e {
+		parts[i] = v.Field + ": " + v.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Validator checks every field of a request and collects all violations
+// instead of stopping at the first one.
+//
+//	var v validation.Validator
+//	v.Required("nickname", nick, policy.NicknameRules()...)
+//	v.Optional("password", pass, policy.Password.Rules(nick)...)
+//	if err := v.Err(); err != nil { ... }
+type Validator struct {
+	errs Errors
+}
+
+// Required checks value against every rule. An empty value is reported
+// once as RuleRequired.
+func (v *Validator) Required(field, value string, rules ...Rule) {
+	if value == "" {
+		v.Add(field, RuleRequired, "is required")
+		return
+	}
+	v.check(field, value, rules)
+}
+
+// Optional checks value against every rule unless it is empty.
+func (v *Validator) Optional(field, value string, rules ...Rule) {
+	if value != "" {
+		v.check(field, value, rules)
+	}
+}
+
+// Add records a violation found outside of the rules, such as a check that
+// needs more than one field.
+func (v *Validator) Add(field, rule, message string) {
+	v.errs = append(v.errs, Violation{Field: field, Rule: rule, Message: message})
+}
+
+func (v *Validator) check(field, value string, rules []Rule) {
+	for _, r := range rules {
+		if !r.Check(value) {
+			v.Add(field, r.ID, r.Message)
+		}
+	}
+}
+
+// Err returns the violations as Errors, or nil when there are none.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
@@ -3,9 +3,16 @@ package main
 import (
 	"awesomeProject/api"
 	"awesomeProject/internal/cash"
+	"awesomeProject/internal/config"
 	"awesomeProject/internal/db"
+	"awesomeProject/internal/hash"
+	"awesomeProject/internal/mail"
+	"awesomeProject/internal/ratelimit"
 	"awesomeProject/internal/token"
+	"awesomeProject/users"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 
@@ -14,54 +21,317 @@ import (
 )
 
 func main() {
-	globalClient, err := db.ConnectToMongo()
+	migrateVotes := flag.Bool("migrate-votes", false, "move legacy UserRatingList vote lists into the votes collection, as done on every start, and exit")
+	rebuildLeaderboard := flag.Bool("rebuild-leaderboard", false, "repopulate the Redis leaderboard from MongoDB and exit")
+	purgeDeleted := flag.Bool("purge-deleted", false, "remove accounts deleted longer ago than the retention and exit")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	globalClient, err := db.ConnectToMongo(cfg.Mongo.URI)
 	if err != nil {
 		log.Panic(fmt.Errorf("mongo connect error: %s", err))
 	}
 	defer globalClient.Disconnect(context.TODO())
 
-	redisClient, err := cash.InitRedis()
+	redisClient := cash.InitRedis(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB)
+	defer redisClient.Close()
+
+	userRepo := users.NewMongoRepository(globalClient, cfg.Mongo.Database)
+	voteRepo := users.NewMongoVoteRepository(globalClient, cfg.Mongo.Database)
+	apiKeyRepo := users.NewMongoAPIKeyRepository(globalClient, cfg.Mongo.Database)
+	clientRepo := users.NewMongoOAuthClientRepository(globalClient, cfg.Mongo.Database)
+	if err := userRepo.EnsureIndexes(context.TODO()); err != nil {
+		var duplicates *users.DuplicatesError
+		if errors.As(err, &duplicates) {
+			log.Fatal(err)
+		}
+		log.Panic(err)
+	}
+	if lookalikes, err := userRepo.LookalikeNicknames(context.TODO()); err != nil {
+		log.Panic(err)
+	} else {
+		for _, d := range lookalikes {
+			log.Warnf("accounts with look-alike nicknames, consider renaming: %s", d)
+		}
+	}
+	if n, err := userRepo.MarkLegacyEmailsVerified(context.TODO()); err != nil {
+		log.Panic(err)
+	} else if n > 0 {
+		log.Infof("marked %d existing accounts as verified", n)
+	}
+	leaderboard := cash.NewLeaderboard(redisClient)
+	if removed, err := userRepo.MigrateLegacyDeletions(context.TODO()); err != nil {
+		log.Panic(err)
+	} else if len(removed) > 0 {
+		for _, nickname := range removed {
+			if err := leaderboard.Remove(context.TODO(), nickname); err != nil {
+				log.Errorf("remove %s from leaderboard: %s", nickname, err)
+			}
+		}
+		log.Infof("marked %d accounts deleted before soft deletes as deleted", len(removed))
+	}
+	if err := voteRepo.EnsureIndexes(context.TODO()); err != nil {
+		log.Panic(err)
+	}
+	if err := apiKeyRepo.EnsureIndexes(context.TODO()); err != nil {
+		log.Panic(err)
+	}
+	// Leftover keys of the old rating code shadow the current rating, so
+	// the migration runs on every start; it does nothing once done.
+	if n, err := voteRepo.MigrateRatingLists(context.TODO()); err != nil {
+		log.Panic(fmt.Errorf("migrate votes: %s", err))
+	} else if n > 0 || *migrateVotes {
+		log.Infof("migrated %d votes", n)
+	}
+	if *migrateVotes {
+		return
+	}
+	if *purgeDeleted {
+		n, err := api.PurgeDeletedUsers(context.TODO(), userRepo, voteRepo, cfg.Purge.Retention)
+		if err != nil {
+			log.Panic(fmt.Errorf("purge deleted users: %s", err))
+		}
+		log.Infof("purged %d deleted users", n)
+		return
+	}
+	go api.SchedulePurge(context.Background(), userRepo, voteRepo, cfg.Purge.Retention, cfg.Purge.Interval)
+	if *rebuildLeaderboard {
+		n, err := api.RebuildLeaderboard(context.TODO(), userRepo, leaderboard)
+		if err != nil {
+			log.Panic(fmt.Errorf("rebuild leaderboard: %s", err))
+		}
+		log.Infof("leaderboard rebuilt with %d users", n)
+		return
+	}
+
+	passwordHasher, err := hash.Default(cfg.PasswordHasher)
 	if err != nil {
-		log.Panic(fmt.Errorf("redis connect error: %s", err))
+		log.Fatal(err)
+	}
+	cache := cash.NewCache(redisClient, cfg.CacheTTL)
+	tokens := token.NewManager(cfg.JWT.Keys, cfg.JWT.Issuer, cfg.JWT.Audience, cfg.JWT.AccessTTL)
+	tokenStore := token.NewStore(redisClient, cfg.JWT.RefreshTTL)
+	apiKeys := token.NewAPIKeys(apiKeyRepo, userRepo)
+	mailer, err := newMailer(cfg.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+	verification := &api.EmailVerification{
+		Tokens:         token.NewOneTimeTokens(redisClient, cfg.JWT.Secret),
+		Mailer:         mailer,
+		Policy:         api.UnverifiedPolicy(cfg.Verification.Policy),
+		BaseURL:        cfg.PublicURL,
+		TokenTTL:       cfg.Verification.TokenTTL,
+		ResendInterval: cfg.Verification.ResendInterval,
+	}
+	passwordReset := &api.PasswordReset{
+		Tokens:          verification.Tokens,
+		Mailer:          mailer,
+		BaseURL:         cfg.PublicURL,
+		TokenTTL:        cfg.Reset.TokenTTL,
+		RequestInterval: cfg.Reset.RequestInterval,
+	}
+	twoFactor := &api.TwoFactor{
+		Tokens:       verification.Tokens,
+		Issuer:       cfg.TwoFactor.Issuer,
+		ChallengeTTL: cfg.TwoFactor.ChallengeTTL,
+	}
+	loginGuard := token.NewLoginGuard(redisClient, token.GuardPolicy{
+		AccountThreshold: cfg.Login.MaxFailures,
+		IPThreshold:      cfg.Login.MaxIPFailures,
+		Lockout:          cfg.Login.Lockout,
+		MaxLockout:       cfg.Login.MaxLockout,
+		Window:           cfg.Login.FailureWindow,
+	})
+	oauth := &api.OAuthProvider{
+		Clients: clientRepo,
+		Codes:   token.NewAuthorizationCodes(redisClient, tokenStore, cfg.OAuth.CodeTTL),
+		BaseURL: cfg.PublicURL,
+		Policy:  verification.Policy,
+	}
+	if tokens.Keys().Signing().Method.Alg() == "HS256" {
+		log.Warn("access tokens are signed with HS256: OAuth clients cannot verify id tokens, configure an asymmetric key")
+	}
+	verified := api.RequireVerifiedEmail(verification.Policy)
+
+	var limiter ratelimit.Limiter = ratelimit.NewMemoryLimiter()
+	if cfg.RateLimit.Store == "redis" {
+		limiter = ratelimit.NewFallback(ratelimit.NewRedisLimiter(redisClient), limiter)
+	}
+	rateLimit := func(policy string) echo.MiddlewareFunc {
+		limit, ok := cfg.RateLimit.Policies[policy]
+		if !ok {
+			return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+		}
+		return ratelimit.Middleware(limiter, policy, limit, ratelimit.ClientKey)
 	}
-	defer redisClient.Close()
 
 	e := echo.New()
+	e.HTTPErrorHandler = api.ErrorHandler
+	e.IPExtractor = ratelimit.IPExtractor(cfg.RateLimit.TrustedProxies)
+	e.Use(rateLimit("default"))
 	e.GET("/", api.Hello)
+	e.GET(api.JWKSPath, func(c echo.Context) error {
+		return api.JWKS(c, tokens)
+	})
+	e.GET("/.well-known/openid-configuration", func(c echo.Context) error {
+		return api.OIDCDiscovery(c, tokens, oauth)
+	})
+	e.GET(api.OAuthAuthorizePath, func(c echo.Context) error {
+		return api.Authorize(c, userRepo, passwordHasher, tokens, loginGuard, oauth)
+	})
+	e.POST(api.OAuthAuthorizePath, func(c echo.Context) error {
+		return api.Authorize(c, userRepo, passwordHasher, tokens, loginGuard, oauth)
+	}, rateLimit("login"))
+	e.POST(api.OAuthTokenPath, func(c echo.Context) error {
+		return api.OAuthToken(c, userRepo, tokens, tokenStore, oauth)
+	})
+	userInfo := func(c echo.Context) error {
+		return api.OAuthUserInfo(c, userRepo, tokens, tokenStore, oauth)
+	}
+	e.GET(api.OAuthUserInfoPath, userInfo)
+	e.POST(api.OAuthUserInfoPath, userInfo)
 	e.GET("/users/:page", func(c echo.Context) error {
-		return api.GetAllUsers(c, globalClient, redisClient)
+		return api.GetAllUsers(c, userRepo, cache, cfg.PageSize)
 	})
 	e.GET("/users/", func(c echo.Context) error {
-		return api.GetAllUsers(c, globalClient, redisClient)
+		return api.GetAllUsers(c, userRepo, cache, cfg.PageSize)
 	})
 
 	e.POST("/register/", func(c echo.Context) error {
-		return api.UserRegister(c, globalClient)
+		return api.UserRegister(c, userRepo, passwordHasher, leaderboard, cache, verification, &cfg.Validation)
+	}, rateLimit("register"))
+	e.GET("/verify-email/", func(c echo.Context) error {
+		return api.VerifyEmail(c, userRepo, verification)
 	})
+	e.POST("/verify-email/", func(c echo.Context) error {
+		return api.VerifyEmail(c, userRepo, verification)
+	})
+	e.POST("/verify-email/resend/", func(c echo.Context) error {
+		return api.ResendVerification(c, userRepo, verification)
+	}, rateLimit("recovery"))
+	e.POST("/password/forgot/", func(c echo.Context) error {
+		return api.ForgotPassword(c, userRepo, passwordReset)
+	}, rateLimit("recovery"))
+	e.POST("/password/reset/", func(c echo.Context) error {
+		return api.ResetPassword(c, userRepo, passwordHasher, tokenStore, passwordReset, &cfg.Validation)
+	}, rateLimit("recovery"))
 	e.POST("/log-in/", func(c echo.Context) error {
-		return api.Login(c, globalClient)
+		return api.Login(c, userRepo, passwordHasher, tokens, tokenStore, verification.Policy, twoFactor, loginGuard)
+	}, rateLimit("login"))
+	e.POST("/log-in/2fa/", func(c echo.Context) error {
+		return api.LoginTOTP(c, userRepo, tokens, tokenStore, twoFactor, loginGuard)
+	}, rateLimit("login"))
+	e.POST("/token/refresh/", func(c echo.Context) error {
+		return api.RefreshToken(c, userRepo, tokens, tokenStore)
+	})
+	e.POST("/log-out/", func(c echo.Context) error {
+		return api.Logout(c, tokens, tokenStore)
+	})
+	e.GET("/leaderboard/", func(c echo.Context) error {
+		return api.TopUsers(c, leaderboard)
+	})
+	e.GET("/leaderboard/:nickname/rank/", func(c echo.Context) error {
+		return api.UserRank(c, leaderboard)
+	})
+	e.GET("/leaderboard/:nickname/around/", func(c echo.Context) error {
+		return api.UsersAround(c, leaderboard)
 	})
 	e.GET("/rating/:nickname/", func(c echo.Context) error {
-		return api.GetRating(c, globalClient, redisClient)
+		return api.GetRating(c, userRepo, cache)
 	})
 
 	g := e.Group("/profile")
-	g.Use(token.JwtMiddleware)
+	g.Use(tokens.JwtMiddleware(tokenStore, apiKeys))
 	g.POST("/edit/", func(c echo.Context) error {
-		return api.UserEdit(c, globalClient)
-	})
+		return api.UserEdit(c, userRepo, passwordHasher, cache, &cfg.Validation)
+	}, verified)
+	g.POST("/2fa/enroll/", func(c echo.Context) error {
+		return api.EnrollTOTP(c, userRepo, twoFactor)
+	}, token.RequireLoginToken)
+	g.POST("/2fa/confirm/", func(c echo.Context) error {
+		return api.ConfirmTOTP(c, userRepo)
+	}, token.RequireLoginToken)
+	g.POST("/2fa/disable/", func(c echo.Context) error {
+		return api.DisableTOTP(c, userRepo, passwordHasher)
+	}, token.RequireLoginToken)
+	g.GET("/sessions/", func(c echo.Context) error {
+		return api.ListSessions(c, tokenStore)
+	}, token.RequireLoginToken)
+	g.POST("/sessions/revoke/", func(c echo.Context) error {
+		return api.RevokeSessions(c, tokenStore)
+	}, token.RequireLoginToken)
+	g.POST("/sessions/:session/revoke/", func(c echo.Context) error {
+		return api.RevokeSession(c, tokenStore)
+	}, token.RequireLoginToken)
+	g.GET("/api-keys/", func(c echo.Context) error {
+		return api.ListAPIKeys(c, apiKeyRepo)
+	}, token.RequireLoginToken)
+	g.POST("/api-keys/", func(c echo.Context) error {
+		return api.CreateAPIKey(c, apiKeyRepo)
+	}, token.RequireLoginToken)
+	g.POST("/api-keys/:key/revoke/", func(c echo.Context) error {
+		return api.RevokeAPIKey(c, apiKeyRepo)
+	}, token.RequireLoginToken)
 	g.GET("/:nickname/", func(c echo.Context) error {
-		return api.UserProfile(c, globalClient, redisClient)
-	})
+		return api.UserProfile(c, userRepo, cache)
+	}, token.RequirePermission(users.PermProfileView))
 	g.POST("/delete/:nickname/", func(c echo.Context) error {
-		return api.Delete(c, globalClient)
-	})
+		return api.Delete(c, userRepo, leaderboard, cache, tokenStore)
+	}, verified)
 	g.POST("/add-rating/:nickname/", func(c echo.Context) error {
-		return api.ChangeRating(c, globalClient, true)
-	})
+		return api.ChangeRating(c, userRepo, voteRepo, leaderboard, cache, true)
+	}, token.RequirePermission(users.PermRatingVote), verified, rateLimit("vote"))
 	g.POST("/sub-rating/:nickname/", func(c echo.Context) error {
-		return api.ChangeRating(c, globalClient, false)
-	})
+		return api.ChangeRating(c, userRepo, voteRepo, leaderboard, cache, false)
+	}, token.RequirePermission(users.PermRatingVote), verified, rateLimit("vote"))
+
+	admin := e.Group("/admin", tokens.JwtMiddleware(tokenStore, apiKeys))
+	admin.POST("/users/:nickname/unlock/", func(c echo.Context) error {
+		return api.UnlockUser(c, userRepo, loginGuard)
+	}, token.RequirePermission(users.PermUserUnlock))
+	admin.GET("/users/deleted/", func(c echo.Context) error {
+		return api.ListDeletedUsers(c, userRepo, cfg.PageSize)
+	}, token.RequirePermission(users.PermUserRestore))
+	admin.POST("/users/:nickname/restore/", func(c echo.Context) error {
+		return api.RestoreUser(c, userRepo, leaderboard, cache)
+	}, token.RequirePermission(users.PermUserRestore))
+	admin.GET("/users/:nickname/sessions/", func(c echo.Context) error {
+		return api.ListUserSessions(c, userRepo, tokenStore)
+	}, token.RequirePermission(users.PermSessionRevoke))
+	admin.POST("/users/:nickname/sessions/revoke/", func(c echo.Context) error {
+		return api.RevokeUserSessions(c, userRepo, tokenStore)
+	}, token.RequirePermission(users.PermSessionRevoke))
+	admin.POST("/users/:nickname/sessions/:session/revoke/", func(c echo.Context) error {
+		return api.RevokeUserSession(c, userRepo, tokenStore)
+	}, token.RequirePermission(users.PermSessionRevoke))
+	admin.GET("/users/:nickname/api-keys/", func(c echo.Context) error {
+		return api.ListUserAPIKeys(c, userRepo, apiKeyRepo)
+	}, token.RequirePermission(users.PermAPIKeyRevoke))
+	admin.POST("/users/:nickname/api-keys/:key/revoke/", func(c echo.Context) error {
+		return api.RevokeUserAPIKey(c, userRepo, apiKeyRepo)
+	}, token.RequirePermission(users.PermAPIKeyRevoke))
+	admin.GET("/cache/stats/", func(c echo.Context) error {
+		return api.CacheStats(c, cache)
+	}, token.RequirePermission(users.PermCacheView))
+	admin.GET("/oauth/clients/", func(c echo.Context) error {
+		return api.ListClients(c, clientRepo)
+	}, token.RequirePermission(users.PermOAuthClientManage))
+	admin.POST("/oauth/clients/", func(c echo.Context) error {
+		return api.RegisterClient(c, clientRepo)
+	}, token.RequirePermission(users.PermOAuthClientManage))
+	admin.POST("/oauth/clients/:client/delete/", func(c echo.Context) error {
+		return api.DeleteClient(c, clientRepo)
+	}, token.RequirePermission(users.PermOAuthClientManage))
 
-	e.Logger.Fatal(e.Start(fmt.Sprintf(":%s", os.Getenv("port"))))
+	e.Logger.Fatal(e.Start(fmt.Sprintf(":%s", cfg.Port)))
+}
+
+func newMailer(cfg config.MailConfig) (mail.Mailer, error) {
+	if cfg.Driver == "smtp" {
+		return mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPassword, cfg.From), nil
+	}
+	return mail.NewFileMailer(cfg.Dir, cfg.From)
 }
@@ -0,0 +1,45 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey is a personal access token. Only the SHA-256 digest of the secret
+// is stored; the secret itself is shown once when the key is created.
+type APIKey struct {
+	ID     primitive.ObjectID `bson:"_id"`
+	UserID primitive.ObjectID `bson:"user_id"`
+	Name   string             `bson:"name"`
+	// Prefix is the start of the secret, so owners can tell keys apart.
+	Prefix string       `bson:"prefix"`
+	Digest string       `bson:"digest"`
+	Scopes []Permission `bson:"scopes"`
+	// ExpiresAt is zero for keys that never expire.
+	ExpiresAt  time.Time `bson:"expires_at,omitempty"`
+	CreatedAt  time.Time `bson:"created_at"`
+	LastUsedAt time.Time `bson:"last_used_at,omitempty"`
+}
+
+// Expired reports whether the key can no longer be used at now.
+func (k *APIKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// APIKeyRepository stores API keys. Lookups without a result return
+// ErrAPIKeyNotFound.
+type APIKeyRepository interface {
+	Insert(ctx context.Context, key *APIKey) error
+	FindByDigest(ctx context.Context, digest string) (*APIKey, error)
+	// ListByUser returns the keys of a user, newest first.
+	ListByUser(ctx context.Context, userID primitive.ObjectID) ([]APIKey, error)
+	// Delete removes the key id of userID.
+	Delete(ctx context.Context, userID, id primitive.ObjectID) error
+	// Touch records that the key was used at at.
+	Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
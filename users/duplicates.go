@@ -0,0 +1,50 @@
+package users
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxReportedDuplicates bounds how many colliding values of each field a
+// DuplicatesError lists.
+const maxReportedDuplicates = 20
+
+// Duplicate is a value of a unique field that several accounts share.
+type Duplicate struct {
+	Field    string
+	Value    interface{}
+	Accounts []DuplicateAccount
+}
+
+// String lists the accounts, as in "nickname alex: Alex (id1), alex (id2)".
+func (d Duplicate) String() string {
+	accounts := make([]string, len(d.Accounts))
+	for i, a := range d.Accounts {
+		accounts[i] = fmt.Sprintf("%s (%s)", a.Nickname, a.ID.Hex())
+	}
+	return fmt.Sprintf("%s %v: %s", d.Field, d.Value, strings.Join(accounts, ", "))
+}
+
+type DuplicateAccount struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	Nickname string             `bson:"nickname"`
+}
+
+// DuplicatesError is returned by MongoRepository.EnsureIndexes when accounts
+// stored before uniqueness was enforced collide, so the unique indexes
+// cannot be built.
+type DuplicatesError struct {
+	Duplicates []Duplicate
+}
+
+func (e *DuplicatesError) Error() string {
+	var b strings.Builder
+	b.WriteString("cannot make user fields unique, several accounts share:")
+	for _, d := range e.Duplicates {
+		fmt.Fprintf(&b, "\n  %s", d)
+	}
+	b.WriteString("\nrename, merge or delete all but one account of each and restart")
+	return b.String()
+}
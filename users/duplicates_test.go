@@ -0,0 +1,35 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDuplicatesError(t *testing.T) {
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	err := fmt.Errorf("start: %w", &DuplicatesError{Duplicates: []Duplicate{
+		{Field: nicknameField, Value: "oleksii", Accounts: []DuplicateAccount{{first, "oleksii"}, {second, "oleksii"}}},
+		{Field: emailField, Value: "shared@example.com", Accounts: []DuplicateAccount{{first, "ann"}, {second, "bob"}}},
+		{Field: emailField, Value: nil, Accounts: []DuplicateAccount{{first, "old1"}, {second, "old2"}}},
+	}})
+
+	var duplicates *DuplicatesError
+	if !errors.As(err, &duplicates) || len(duplicates.Duplicates) != 3 {
+		t.Fatalf("errors.As(%v) = %v, expected the duplicates", err, duplicates)
+	}
+	message := err.Error()
+	for _, expected := range []string{
+		"nickname oleksii: oleksii (" + first.Hex() + "), oleksii (" + second.Hex() + ")",
+		"email shared@example.com: ann (" + first.Hex() + "), bob (" + second.Hex() + ")",
+		"email <nil>: old1",
+		"rename, merge or delete",
+	} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Error() = %q, expected it to contain %q", message, expected)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package users
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryAPIKeyRepository keeps API keys in a map.
+type MemoryAPIKeyRepository struct {
+	mu   sync.Mutex
+	keys map[primitive.ObjectID]APIKey
+}
+
+func NewMemoryAPIKeyRepository() *MemoryAPIKeyRepository {
+	return &MemoryAPIKeyRepository{keys: make(map[primitive.ObjectID]APIKey)}
+}
+
+func (r *MemoryAPIKeyRepository) Insert(_ context.Context, key *APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if key.ID.IsZero() {
+		key.ID = primitive.NewObjectID()
+	}
+	r.keys[key.ID] = *key
+	return nil
+}
+
+func (r *MemoryAPIKeyRepository) FindByDigest(_ context.Context, digest string) (*APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range r.keys {
+		if k.Digest == digest {
+			return &k, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+func (r *MemoryAPIKeyRepository) ListByUser(_ context.Context, userID primitive.ObjectID) ([]APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := []APIKey{}
+	for _, k := range r.keys {
+		if k.UserID == userID {
+			list = append(list, k)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+func (r *MemoryAPIKeyRepository) Delete(_ context.Context, userID, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k, ok := r.keys[id]
+	if !ok || k.UserID != userID {
+		return ErrAPIKeyNotFound
+	}
+	delete(r.keys, id)
+	return nil
+}
+
+func (r *MemoryAPIKeyRepository) Touch(_ context.Context, id primitive.ObjectID, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k, ok := r.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	k.LastUsedAt = at
+	r.keys[id] = k
+	return nil
+}
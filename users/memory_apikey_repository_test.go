@@ -0,0 +1,72 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMemoryAPIKeyRepository(t *testing.T) {
+	keys := NewMemoryAPIKeyRepository()
+	ctx := context.Background()
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+	now := time.Now()
+
+	older := &APIKey{UserID: owner, Name: "ci", Digest: "d1", CreatedAt: now.Add(-time.Hour)}
+	newer := &APIKey{UserID: owner, Name: "backup", Digest: "d2", CreatedAt: now}
+	for _, k := range []*APIKey{older, newer, {UserID: other, Digest: "d3", CreatedAt: now}} {
+		if err := keys.Insert(ctx, k); err != nil || k.ID.IsZero() {
+			t.Fatalf("Insert() = %v, id %s", err, k.ID.Hex())
+		}
+	}
+
+	list, err := keys.ListByUser(ctx, owner)
+	if err != nil || len(list) != 2 || list[0].ID != newer.ID {
+		t.Errorf("ListByUser() = %+v, %v, expected backup then ci", list, err)
+	}
+	if found, err := keys.FindByDigest(ctx, "d1"); err != nil || found.ID != older.ID {
+		t.Errorf("FindByDigest(d1) = %+v, %v", found, err)
+	}
+	if _, err := keys.FindByDigest(ctx, "unknown"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("FindByDigest(unknown) error = %v, expected %v", err, ErrAPIKeyNotFound)
+	}
+
+	if err := keys.Touch(ctx, older.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := keys.FindByDigest(ctx, "d1"); !found.LastUsedAt.Equal(now) {
+		t.Errorf("LastUsedAt after Touch() = %v, expected %v", found.LastUsedAt, now)
+	}
+
+	if err := keys.Delete(ctx, other, older.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Delete(key of another user) error = %v, expected %v", err, ErrAPIKeyNotFound)
+	}
+	if err := keys.Delete(ctx, owner, older.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := keys.FindByDigest(ctx, "d1"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("FindByDigest() after Delete error = %v", err)
+	}
+}
+
+func TestAPIKeyExpired(t *testing.T) {
+	now := time.Now()
+	tests := map[string]struct {
+		expiresAt time.Time
+		expected  bool
+	}{
+		"never":   {time.Time{}, false},
+		"future":  {now.Add(time.Hour), false},
+		"now":     {now, true},
+		"expired": {now.Add(-time.Hour), true},
+	}
+	for name, tt := range tests {
+		k := &APIKey{ExpiresAt: tt.expiresAt}
+		if result := k.Expired(now); result != tt.expected {
+			t.Errorf("Expired(%s) = %v, expected %v", name, result, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package users
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// MemoryOAuthClientRepository keeps OAuth clients in a map.
+type MemoryOAuthClientRepository struct {
+	mu      sync.Mutex
+	clients map[string]OAuthClient
+}
+
+func NewMemoryOAuthClientRepository() *MemoryOAuthClientRepository {
+	return &MemoryOAuthClientRepository{clients: make(map[string]OAuthClient)}
+}
+
+func (r *MemoryOAuthClientRepository) Insert(_ context.Context, client *OAuthClient) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[client.ID] = *client
+	return nil
+}
+
+func (r *MemoryOAuthClientRepository) FindByID(_ context.Context, id string) (*OAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, ok := r.clients[id]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+	return &client, nil
+}
+
+func (r *MemoryOAuthClientRepository) List(_ context.Context) ([]OAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]OAuthClient, 0, len(r.clients))
+	for _, c := range r.clients {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+func (r *MemoryOAuthClientRepository) Delete(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.clients[id]; !ok {
+		return ErrClientNotFound
+	}
+	delete(r.clients, id)
+	return nil
+}
@@ -0,0 +1,44 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryOAuthClientRepository(t *testing.T) {
+	clients := NewMemoryOAuthClientRepository()
+	ctx := context.Background()
+	now := time.Now()
+
+	web := &OAuthClient{ID: "web", Name: "Web", SecretDigest: "digest", CreatedAt: now.Add(-time.Hour)}
+	cli := &OAuthClient{ID: "cli", Name: "CLI", CreatedAt: now}
+	for _, c := range []*OAuthClient{cli, web} {
+		if err := clients.Insert(ctx, c); err != nil {
+			t.Fatalf("Insert(%s) error = %v", c.ID, err)
+		}
+	}
+
+	list, err := clients.List(ctx)
+	if err != nil || len(list) != 2 || list[0].ID != "web" {
+		t.Errorf("List() = %+v, %v, expected web then cli", list, err)
+	}
+	found, err := clients.FindByID(ctx, "cli")
+	if err != nil || found.Name != "CLI" || !found.Public() {
+		t.Errorf("FindByID(cli) = %+v, %v, expected the public CLI client", found, err)
+	}
+	if web.Public() {
+		t.Errorf("Public() of a client with a secret = true")
+	}
+
+	if err := clients.Delete(ctx, "web"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := clients.FindByID(ctx, "web"); !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("FindByID(deleted) error = %v, expected %v", err, ErrClientNotFound)
+	}
+	if err := clients.Delete(ctx, "web"); !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("Delete(deleted) error = %v, expected %v", err, ErrClientNotFound)
+	}
+}
@@ -0,0 +1,227 @@
+package users
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRepository keeps users in a map. It is meant for tests and local
+// runs without MongoDB.
+type MemoryRepository struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]User
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{users: make(map[primitive.ObjectID]User)}
+}
+
+func (r *MemoryRepository) FindByNickname(_ context.Context, nickname string) (*User, error) {
+	return r.find(func(u *User) bool { return u.Nickname == nickname })
+}
+
+func (r *MemoryRepository) FindLookalike(_ context.Context, nickname string) (*User, error) {
+	key := NicknameKey(nickname)
+	return r.find(func(u *User) bool { return u.NicknameKey == key })
+}
+
+func (r *MemoryRepository) FindByEmail(_ context.Context, email string) (*User, error) {
+	return r.find(func(u *User) bool { return u.Email == email })
+}
+
+func (r *MemoryRepository) FindByID(_ context.Context, id primitive.ObjectID) (*User, error) {
+	return r.find(func(u *User) bool { return u.ID == id })
+}
+
+func (r *MemoryRepository) Insert(_ context.Context, user *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user.NicknameKey = NicknameKey(user.Nickname)
+	for _, u := range r.users {
+		if u.NicknameKey == user.NicknameKey || u.Email == user.Email {
+			return ErrUserExists
+		}
+	}
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *MemoryRepository) Update(_ context.Context, user *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.users[user.ID]
+	if !ok || stored.Deleted() {
+		return ErrUserNotFound
+	}
+	// Look-alike nicknames registered before NicknameKey may keep sharing
+	// it; only a rename onto another account's key is refused.
+	user.NicknameKey = NicknameKey(user.Nickname)
+	renamed := user.NicknameKey != stored.NicknameKey
+	for id, u := range r.users {
+		if id != user.ID && (renamed && u.NicknameKey == user.NicknameKey || u.Email == user.Email) {
+			return ErrUserExists
+		}
+	}
+	updated := *user
+	updated.Rating = stored.Rating
+	updated.VotedAt = stored.VotedAt
+	updated.TOTPSecret = stored.TOTPSecret
+	updated.TOTPEnabled = stored.TOTPEnabled
+	updated.TOTPLastStep = stored.TOTPLastStep
+	updated.RecoveryCodes = stored.RecoveryCodes
+	r.users[user.ID] = updated
+	return nil
+}
+
+func (r *MemoryRepository) SetTOTP(_ context.Context, id primitive.ObjectID, secret string, enabled bool, recoveryCodes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep = secret, enabled, 0
+	u.RecoveryCodes = append([]string(nil), recoveryCodes...)
+	r.users[id] = u
+	return nil
+}
+
+func (r *MemoryRepository) UseTOTPStep(_ context.Context, id primitive.ObjectID, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if step <= u.TOTPLastStep {
+		return ErrCodeUsed
+	}
+	u.TOTPLastStep = step
+	r.users[id] = u
+	return nil
+}
+
+func (r *MemoryRepository) UseRecoveryCode(_ context.Context, id primitive.ObjectID, digest string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	for i, code := range u.RecoveryCodes {
+		if code == digest {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+			r.users[id] = u
+			return nil
+		}
+	}
+	return ErrCodeUsed
+}
+
+func (r *MemoryRepository) SoftDelete(_ context.Context, nickname string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.lookup(func(u *User) bool { return u.Nickname == nickname })
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.DeletedAt = time.Now()
+	r.users[u.ID] = u
+	return nil
+}
+
+func (r *MemoryRepository) Restore(_ context.Context, nickname string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, u := range r.users {
+		if u.Nickname == nickname && u.Deleted() {
+			u.DeletedAt = time.Time{}
+			r.users[id] = u
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+func (r *MemoryRepository) ListDeleted(_ context.Context, skip, limit int64) ([]User, error) {
+	r.mu.RLock()
+	var result []User
+	for _, u := range r.users {
+		if u.Deleted() {
+			result = append(result, u)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].DeletedAt.After(result[j].DeletedAt) })
+	return page(result, skip, limit), nil
+}
+
+func (r *MemoryRepository) PurgeDeleted(_ context.Context, cutoff time.Time) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var purged []string
+	for id, u := range r.users {
+		if u.Deleted() && u.DeletedAt.Before(cutoff) {
+			purged = append(purged, u.Nickname)
+			delete(r.users, id)
+		}
+	}
+	return purged, nil
+}
+
+func (r *MemoryRepository) ListByRating(_ context.Context, skip, limit int64) ([]User, error) {
+	r.mu.RLock()
+	result := make([]User, 0, len(r.users))
+	for _, u := range r.users {
+		if !u.Deleted() {
+			result = append(result, u)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Rating != result[j].Rating {
+			return result[i].Rating > result[j].Rating
+		}
+		return result[i].Nickname < result[j].Nickname
+	})
+	return page(result, skip, limit), nil
+}
+
+func page(users []User, skip, limit int64) []User {
+	if skip >= int64(len(users)) {
+		return nil
+	}
+	users = users[skip:]
+	if limit > 0 && limit < int64(len(users)) {
+		users = users[:limit]
+	}
+	return users
+}
+
+func (r *MemoryRepository) find(match func(u *User) bool) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if u, ok := r.lookup(match); ok {
+		return &u, nil
+	}
+	return nil, ErrUserNotFound
+}
+
+// lookup finds a user that is not deleted. It expects r.mu to be held.
+func (r *MemoryRepository) lookup(match func(u *User) bool) (User, bool) {
+	for _, u := range r.users {
+		if !u.Deleted() && match(&u) {
+			return u, true
+		}
+	}
+	return User{}, false
+}
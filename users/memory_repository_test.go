@@ -0,0 +1,212 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryRepositoryInsert(t *testing.T) {
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+
+	if err := repo.Insert(ctx, &User{Nickname: "oleksii", Email: "o@example.com"}); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+
+	tests := []struct {
+		nickname, email string
+		expected        error
+	}{
+		{"oleksii", "new@example.com", ErrUserExists},
+		{"other", "o@example.com", ErrUserExists},
+		{"other", "new@example.com", nil},
+	}
+	for _, tt := range tests {
+		u := &User{Nickname: tt.nickname, Email: tt.email}
+		err := repo.Insert(ctx, u)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("Insert(%s, %s) = %v, expected %v", tt.nickname, tt.email, err, tt.expected)
+		}
+		if err == nil && u.ID.IsZero() {
+			t.Errorf("Insert(%s, %s) did not assign an ID", tt.nickname, tt.email)
+		}
+	}
+}
+
+func TestMemoryRepositoryLookalikeNicknames(t *testing.T) {
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+	if err := repo.Insert(ctx, &User{Nickname: "ace", Email: "ace@example.com"}); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	other := &User{Nickname: "bob", Email: "bob@example.com"}
+	if err := repo.Insert(ctx, other); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+
+	tests := []struct {
+		nickname string
+		expected error
+	}{
+		{"ace", ErrUserExists},
+		{"Ace", ErrUserExists},
+		{"ACE", ErrUserExists},
+		{"асе", ErrUserExists}, // Cyrillic а, с and е
+		{"acé", ErrUserExists},
+		{"aces", nil},
+	}
+	for i, tt := range tests {
+		if _, err := repo.FindLookalike(ctx, tt.nickname); (err == nil) != (tt.expected != nil) {
+			t.Errorf("FindLookalike(%s) = %v, expected a match %v", tt.nickname, err, tt.expected != nil)
+		}
+		if tt.expected != nil {
+			renamed := *other
+			renamed.Nickname = tt.nickname
+			if err := repo.Update(ctx, &renamed); !errors.Is(err, tt.expected) {
+				t.Errorf("Update(bob -> %s) = %v, expected %v", tt.nickname, err, tt.expected)
+			}
+		}
+		u := &User{Nickname: tt.nickname, Email: fmt.Sprintf("%d@example.com", i)}
+		if err := repo.Insert(ctx, u); !errors.Is(err, tt.expected) {
+			t.Errorf("Insert(%s) = %v, expected %v", tt.nickname, err, tt.expected)
+		}
+	}
+}
+
+func TestMemoryRepositoryFind(t *testing.T) {
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+	user := &User{Nickname: "oleksii", Email: "o@example.com"}
+	if err := repo.Insert(ctx, user); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+
+	if u, err := repo.FindByNickname(ctx, "oleksii"); err != nil || u.ID != user.ID {
+		t.Errorf("FindByNickname(oleksii) = %v, %v", u, err)
+	}
+	if u, err := repo.FindByEmail(ctx, "o@example.com"); err != nil || u.ID != user.ID {
+		t.Errorf("FindByEmail(o@example.com) = %v, %v", u, err)
+	}
+	if u, err := repo.FindByID(ctx, user.ID); err != nil || u.Nickname != "oleksii" {
+		t.Errorf("FindByID(%s) = %v, %v", user.ID.Hex(), u, err)
+	}
+	if _, err := repo.FindByNickname(ctx, "missing"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("FindByNickname(missing) = %v, expected %v", err, ErrUserNotFound)
+	}
+}
+
+func TestMemoryRepositoryListByRating(t *testing.T) {
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+	for i, nick := range []string{"low", "tie", "high", "mid"} {
+		rating := map[string]int{"low": -1, "high": 5, "mid": 2, "tie": 2}[nick]
+		u := &User{Nickname: nick, Email: string(rune('a'+i)) + "@example.com", Rating: rating}
+		if err := repo.Insert(ctx, u); err != nil {
+			t.Fatalf("Insert(%s) error = %v", nick, err)
+		}
+	}
+
+	tests := map[[2]int64][]string{
+		{0, 2}: {"high", "mid"},
+		{2, 2}: {"tie", "low"},
+		{4, 2}: nil,
+	}
+	for input, expected := range tests {
+		list, err := repo.ListByRating(ctx, input[0], input[1])
+		if err != nil {
+			t.Fatalf("ListByRating(%v) error = %v", input, err)
+		}
+		if len(list) != len(expected) {
+			t.Errorf("ListByRating(%v) returned %d users, expected %d", input, len(list), len(expected))
+			continue
+		}
+		for i := range list {
+			if list[i].Nickname != expected[i] {
+				t.Errorf("ListByRating(%v)[%d] = %s, expected %s", input, i, list[i].Nickname, expected[i])
+			}
+		}
+	}
+}
+
+func TestMemoryRepositorySoftDelete(t *testing.T) {
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+	for _, nick := range []string{"gone", "kept"} {
+		if err := repo.Insert(ctx, &User{Nickname: nick, Email: nick + "@example.com"}); err != nil {
+			t.Fatalf("Insert(%s) error = %v", nick, err)
+		}
+	}
+	gone, _ := repo.FindByNickname(ctx, "gone")
+	if err := repo.SoftDelete(ctx, "gone"); err != nil {
+		t.Fatalf("SoftDelete() error = %v", err)
+	}
+
+	if _, err := repo.FindByNickname(ctx, "gone"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("FindByNickname(gone) = %v, expected %v", err, ErrUserNotFound)
+	}
+	if _, err := repo.FindByEmail(ctx, "gone@example.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("FindByEmail(gone@example.com) = %v, expected %v", err, ErrUserNotFound)
+	}
+	if _, err := repo.FindByID(ctx, gone.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("FindByID(%s) = %v, expected %v", gone.ID.Hex(), err, ErrUserNotFound)
+	}
+	if err := repo.Update(ctx, gone); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Update(gone) = %v, expected %v", err, ErrUserNotFound)
+	}
+	if err := repo.SoftDelete(ctx, "gone"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("second SoftDelete(gone) = %v, expected %v", err, ErrUserNotFound)
+	}
+	if err := repo.Insert(ctx, &User{Nickname: "gone", Email: "new@example.com"}); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Insert(gone) = %v, expected the nickname to stay taken", err)
+	}
+	if list, _ := repo.ListByRating(ctx, 0, 10); len(list) != 1 || list[0].Nickname != "kept" {
+		t.Errorf("ListByRating() = %v, expected only kept", list)
+	}
+	if list, _ := repo.ListDeleted(ctx, 0, 10); len(list) != 1 || list[0].Nickname != "gone" {
+		t.Errorf("ListDeleted() = %v, expected only gone", list)
+	}
+
+	if err := repo.Restore(ctx, "kept"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Restore(kept) = %v, expected %v", err, ErrUserNotFound)
+	}
+	if err := repo.Restore(ctx, "gone"); err != nil {
+		t.Fatalf("Restore(gone) error = %v", err)
+	}
+	if _, err := repo.FindByNickname(ctx, "gone"); err != nil {
+		t.Errorf("FindByNickname(gone) after Restore = %v", err)
+	}
+}
+
+func TestMemoryRepositoryPurgeDeleted(t *testing.T) {
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+	for _, nick := range []string{"old", "recent", "active"} {
+		if err := repo.Insert(ctx, &User{Nickname: nick, Email: nick + "@example.com"}); err != nil {
+			t.Fatalf("Insert(%s) error = %v", nick, err)
+		}
+	}
+	_ = repo.SoftDelete(ctx, "old")
+	cutoff := time.Now()
+	time.Sleep(time.Millisecond)
+	_ = repo.SoftDelete(ctx, "recent")
+
+	purged, err := repo.PurgeDeleted(ctx, cutoff)
+	if err != nil {
+		t.Fatalf("PurgeDeleted() error = %v", err)
+	}
+	if len(purged) != 1 || purged[0] != "old" {
+		t.Errorf("PurgeDeleted() = %v, expected [old]", purged)
+	}
+	if err := repo.Restore(ctx, "old"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Restore(old) after purge = %v, expected %v", err, ErrUserNotFound)
+	}
+	if err := repo.Insert(ctx, &User{Nickname: "old", Email: "old@example.com"}); err != nil {
+		t.Errorf("Insert(old) after purge = %v, expected the nickname to be free", err)
+	}
+	if err := repo.Restore(ctx, "recent"); err != nil {
+		t.Errorf("Restore(recent) = %v", err)
+	}
+}
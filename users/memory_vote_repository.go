@@ -0,0 +1,83 @@
+package users
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryVoteRepository keeps votes in memory and updates ratings in the
+// given MemoryRepository.
+type MemoryVoteRepository struct {
+	mu    sync.Mutex
+	users *MemoryRepository
+	votes map[[2]string]Vote
+}
+
+func NewMemoryVoteRepository(users *MemoryRepository) *MemoryVoteRepository {
+	return &MemoryVoteRepository{users: users, votes: make(map[[2]string]Vote)}
+}
+
+func (r *MemoryVoteRepository) Vote(_ context.Context, voter, target string, value int, cooldown time.Duration) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+
+	voterUser, ok := r.users.lookup(func(u *User) bool { return u.Nickname == voter })
+	if !ok {
+		return 0, ErrUserNotFound
+	}
+	targetUser, ok := r.users.lookup(func(u *User) bool { return u.Nickname == target })
+	if !ok {
+		return 0, ErrUserNotFound
+	}
+	now := time.Now()
+	if voterUser.VotedAt.After(now.Add(-cooldown)) {
+		return 0, ErrVoteCooldown
+	}
+
+	key := [2]string{voter, target}
+	existing, ok := r.votes[key]
+	switch {
+	case ok && existing.Value == value:
+		return 0, ErrAlreadyVoted
+	case ok:
+		delete(r.votes, key)
+	default:
+		r.votes[key] = Vote{ID: primitive.NewObjectID(), Voter: voter, Target: target, Value: value, CreatedAt: now}
+	}
+
+	voterUser.VotedAt = now
+	r.users.users[voterUser.ID] = voterUser
+	targetUser.Rating += value
+	r.users.users[targetUser.ID] = targetUser
+	return targetUser.Rating, nil
+}
+
+func (r *MemoryVoteRepository) FindVote(_ context.Context, voter, target string) (*Vote, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	vote, ok := r.votes[[2]string{voter, target}]
+	if !ok {
+		return nil, ErrVoteNotFound
+	}
+	return &vote, nil
+}
+
+func (r *MemoryVoteRepository) DeleteForUsers(_ context.Context, nicknames []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	gone := make(map[string]bool, len(nicknames))
+	for _, n := range nicknames {
+		gone[n] = true
+	}
+	for key := range r.votes {
+		if gone[key[0]] || gone[key[1]] {
+			delete(r.votes, key)
+		}
+	}
+	return nil
+}
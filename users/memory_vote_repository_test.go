@@ -0,0 +1,133 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newVoteFixture(t *testing.T, nicknames ...string) (*MemoryRepository, *MemoryVoteRepository) {
+	t.Helper()
+	repo := NewMemoryRepository()
+	for _, nick := range nicknames {
+		if err := repo.Insert(context.Background(), &User{Nickname: nick, Email: nick + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo, NewMemoryVoteRepository(repo)
+}
+
+func TestMemoryVoteRepositoryVote(t *testing.T) {
+	_, votes := newVoteFixture(t, "voter", "target")
+	ctx := context.Background()
+
+	tests := []struct {
+		value    int
+		rating   int
+		expected error
+	}{
+		{1, 1, nil},
+		{1, 0, ErrAlreadyVoted},
+		{-1, 0, nil},
+		{-1, -1, nil},
+		{-1, 0, ErrAlreadyVoted},
+	}
+	for i, tt := range tests {
+		rating, err := votes.Vote(ctx, "voter", "target", tt.value, 0)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("step %d: Vote(%d) error = %v, expected %v", i, tt.value, err, tt.expected)
+		}
+		if err == nil && rating != tt.rating {
+			t.Errorf("step %d: Vote(%d) = %d, expected %d", i, tt.value, rating, tt.rating)
+		}
+	}
+	if vote, err := votes.FindVote(ctx, "voter", "target"); err != nil || vote.Value != -1 {
+		t.Errorf("FindVote() = %+v, %v", vote, err)
+	}
+}
+
+func TestMemoryVoteRepositoryCooldown(t *testing.T) {
+	repo, votes := newVoteFixture(t, "voter", "target")
+	ctx := context.Background()
+
+	if _, err := votes.Vote(ctx, "voter", "target", 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := votes.Vote(ctx, "voter", "target", -1, time.Hour); !errors.Is(err, ErrVoteCooldown) {
+		t.Errorf("second Vote() error = %v, expected %v", err, ErrVoteCooldown)
+	}
+
+	voter, _ := repo.FindByNickname(ctx, "voter")
+	voter.FirstName = "Edited"
+	_ = repo.Update(ctx, voter)
+	target, _ := repo.FindByNickname(ctx, "target")
+	target.Rating = 100
+	_ = repo.Update(ctx, target)
+	if target, _ = repo.FindByNickname(ctx, "target"); target.Rating != 1 {
+		t.Errorf("Update() overwrote rating: %d, expected 1", target.Rating)
+	}
+}
+
+func TestMemoryVoteRepositoryConcurrent(t *testing.T) {
+	repo, votes := newVoteFixture(t, "target", "a", "b", "c", "d", "e")
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for _, voter := range []string{"a", "b", "c", "d", "e"} {
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(voter string) {
+				defer wg.Done()
+				_, _ = votes.Vote(ctx, voter, "target", 1, 0)
+			}(voter)
+		}
+	}
+	wg.Wait()
+
+	target, _ := repo.FindByNickname(ctx, "target")
+	if target.Rating != 5 {
+		t.Errorf("rating after concurrent votes = %d, expected 5", target.Rating)
+	}
+}
+
+func TestMemoryVoteRepositoryDeletedUsers(t *testing.T) {
+	repo, votes := newVoteFixture(t, "voter", "target", "gone")
+	ctx := context.Background()
+	if _, err := votes.Vote(ctx, "gone", "target", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	_ = repo.SoftDelete(ctx, "gone")
+
+	for _, pair := range [][2]string{{"voter", "gone"}, {"gone", "target"}} {
+		if _, err := votes.Vote(ctx, pair[0], pair[1], 1, 0); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Vote(%s, %s) = %v, expected %v", pair[0], pair[1], err, ErrUserNotFound)
+		}
+	}
+
+	if err := votes.DeleteForUsers(ctx, []string{"gone"}); err != nil {
+		t.Fatalf("DeleteForUsers() error = %v", err)
+	}
+	if _, err := votes.FindVote(ctx, "gone", "target"); !errors.Is(err, ErrVoteNotFound) {
+		t.Errorf("FindVote(gone, target) = %v, expected %v", err, ErrVoteNotFound)
+	}
+}
+
+func TestLegacyVotes(t *testing.T) {
+	u := &User{
+		Nickname:       "target",
+		UserRatingList: `[{"VotedNickname":"a","VotedRating":1,"VotedDate":"2024-01-02T03:04:05Z"},{"VotedNickname":"b","VotedRating":-1,"VotedDate":"2024-01-02T03:04:05Z"}]`,
+	}
+	votes, err := legacyVotes(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(votes) != 2 || votes[0].Voter != "a" || votes[0].Value != 1 || votes[1].Voter != "b" || votes[1].Target != "target" {
+		t.Errorf("legacyVotes() = %+v", votes)
+	}
+
+	if _, err := legacyVotes(&User{UserRatingList: "not json"}); err == nil {
+		t.Errorf("legacyVotes(invalid) error = nil")
+	}
+}
@@ -0,0 +1,87 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const apiKeysTableName = "api_keys"
+
+type MongoAPIKeyRepository struct {
+	keys *mongo.Collection
+}
+
+func NewMongoAPIKeyRepository(client *mongo.Client, database string) *MongoAPIKeyRepository {
+	return &MongoAPIKeyRepository{keys: client.Database(database).Collection(apiKeysTableName)}
+}
+
+func (r *MongoAPIKeyRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.keys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "digest", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("create api key indexes: %w", err)
+	}
+	return nil
+}
+
+func (r *MongoAPIKeyRepository) Insert(ctx context.Context, key *APIKey) error {
+	if key.ID.IsZero() {
+		key.ID = primitive.NewObjectID()
+	}
+	if _, err := r.keys.InsertOne(ctx, key); err != nil {
+		return fmt.Errorf("insert api key: %w", err)
+	}
+	return nil
+}
+
+func (r *MongoAPIKeyRepository) FindByDigest(ctx context.Context, digest string) (*APIKey, error) {
+	var key APIKey
+	err := r.keys.FindOne(ctx, bson.M{"digest": digest}).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find api key: %w", err)
+	}
+	return &key, nil
+}
+
+func (r *MongoAPIKeyRepository) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]APIKey, error) {
+	cursor, err := r.keys.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("find api keys: %w", err)
+	}
+	list := []APIKey{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, fmt.Errorf("decode api keys: %w", err)
+	}
+	return list, nil
+}
+
+func (r *MongoAPIKeyRepository) Delete(ctx context.Context, userID, id primitive.ObjectID) error {
+	res, err := r.keys.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("delete api key: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *MongoAPIKeyRepository) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := r.keys.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": at}})
+	if err != nil {
+		return fmt.Errorf("touch api key: %w", err)
+	}
+	return nil
+}
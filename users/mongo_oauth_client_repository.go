@@ -0,0 +1,63 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const oauthClientsTableName = "oauth_clients"
+
+type MongoOAuthClientRepository struct {
+	clients *mongo.Collection
+}
+
+func NewMongoOAuthClientRepository(client *mongo.Client, database string) *MongoOAuthClientRepository {
+	return &MongoOAuthClientRepository{clients: client.Database(database).Collection(oauthClientsTableName)}
+}
+
+func (r *MongoOAuthClientRepository) Insert(ctx context.Context, client *OAuthClient) error {
+	if _, err := r.clients.InsertOne(ctx, client); err != nil {
+		return fmt.Errorf("insert oauth client: %w", err)
+	}
+	return nil
+}
+
+func (r *MongoOAuthClientRepository) FindByID(ctx context.Context, id string) (*OAuthClient, error) {
+	var client OAuthClient
+	err := r.clients.FindOne(ctx, bson.M{"_id": id}).Decode(&client)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find oauth client: %w", err)
+	}
+	return &client, nil
+}
+
+func (r *MongoOAuthClientRepository) List(ctx context.Context) ([]OAuthClient, error) {
+	cursor, err := r.clients.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("find oauth clients: %w", err)
+	}
+	list := []OAuthClient{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, fmt.Errorf("decode oauth clients: %w", err)
+	}
+	return list, nil
+}
+
+func (r *MongoOAuthClientRepository) Delete(ctx context.Context, id string) error {
+	res, err := r.clients.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("delete oauth client: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
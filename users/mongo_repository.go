@@ -0,0 +1,483 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	tableName = "users"
+
+	nicknameField    = "nickname"
+	nicknameKeyField = "nicknamekey"
+	emailField       = "email"
+	ratingField      = "rating"
+	deletedAtField   = "deletedat"
+	votedAtField     = "votedat"
+	verifiedField    = "emailverified"
+
+	totpSecretField    = "totpsecret"
+	totpEnabledField   = "totpenabled"
+	totpLastStepField  = "totplaststep"
+	recoveryCodesField = "recoverycodes"
+
+	legacyRatingListField = "userratinglist"
+
+	// The rating code before the votes collection $set these capitalized
+	// keys next to the lowercase ones written at registration. The driver
+	// decodes either spelling into the same field, so leftovers shadow the
+	// values maintained today.
+	baselineRatingListField = "UserRatingList"
+	baselineRatingField     = "Rating"
+	baselineVotedAtField    = "VotedAt"
+	// baselineDeletedAtField is where the old Delete handler stored the time
+	// of deletion.
+	baselineDeletedAtField = "deleted_ad"
+)
+
+// ratingOrder sorts by rating and breaks ties by the unique nickname, so
+// that skip and limit page through equal ratings without gaps or repeats.
+var ratingOrder = bson.D{{Key: ratingField, Value: -1}, {Key: nicknameField, Value: 1}}
+
+type MongoRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoRepository(client *mongo.Client, database string) *MongoRepository {
+	return &MongoRepository{collection: client.Database(database).Collection(tableName)}
+}
+
+// EnsureIndexes makes nickname and email unique, so concurrent
+// registrations of the same account are rejected by the database and Insert
+// reports ErrUserExists. Collections holding duplicates from before then
+// fail with a *DuplicatesError naming the accounts to fix. NicknameKey is
+// only indexed for FindLookalike: the old nickname rules allowed look-alike
+// pairs such as "Alex" and "alex", which LookalikeNicknames reports instead.
+// Accounts from before NicknameKey get theirs first.
+func (r *MongoRepository) EnsureIndexes(ctx context.Context) error {
+	if err := r.fillNicknameKeys(ctx); err != nil {
+		return err
+	}
+	var duplicates []Duplicate
+	for _, field := range []string{nicknameField, emailField} {
+		found, err := r.findDuplicates(ctx, field)
+		if err != nil {
+			return err
+		}
+		duplicates = append(duplicates, found...)
+	}
+	if len(duplicates) > 0 {
+		return &DuplicatesError{Duplicates: duplicates}
+	}
+
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: nicknameField, Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: nicknameKeyField, Value: 1}}},
+		{Keys: bson.D{{Key: emailField, Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: ratingOrder},
+		{Keys: bson.D{{Key: deletedAtField, Value: -1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("create user indexes: %w", err)
+	}
+	return nil
+}
+
+// LookalikeNicknames returns the accounts registered before NicknameKey
+// whose nicknames look alike. They keep working; new registrations and
+// renames cannot add more.
+func (r *MongoRepository) LookalikeNicknames(ctx context.Context) ([]Duplicate, error) {
+	return r.findDuplicates(ctx, nicknameKeyField)
+}
+
+// fillNicknameKeys stores the NicknameKey of accounts that have none.
+func (r *MongoRepository) fillNicknameKeys(ctx context.Context) error {
+	cursor, err := r.collection.Find(ctx, bson.M{nicknameKeyField: bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{nicknameField: 1}))
+	if err != nil {
+		return fmt.Errorf("find users without nickname key: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user User
+		if err := cursor.Decode(&user); err != nil {
+			return fmt.Errorf("user decode error: %w", err)
+		}
+		_, err := r.collection.UpdateOne(ctx, bson.M{"_id": user.ID},
+			bson.M{"$set": bson.M{nicknameKeyField: NicknameKey(user.Nickname)}})
+		if err != nil {
+			return fmt.Errorf("set nickname key of %s: %w", user.Nickname, err)
+		}
+	}
+	return cursor.Err()
+}
+
+// findDuplicates returns the values of field that more than one account has,
+// deleted ones included since the unique indexes cover them too.
+func (r *MongoRepository) findDuplicates(ctx context.Context, field string) ([]Duplicate, error) {
+	cursor, err := r.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$" + field,
+			"count":    bson.M{"$sum": 1},
+			"accounts": bson.M{"$push": bson.M{"_id": "$_id", nicknameField: "$" + nicknameField}},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$limit", Value: maxReportedDuplicates}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find duplicate %s: %w", field, err)
+	}
+	var groups []struct {
+		Value    interface{}        `bson:"_id"`
+		Accounts []DuplicateAccount `bson:"accounts"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("find duplicate %s: %w", field, err)
+	}
+	duplicates := make([]Duplicate, len(groups))
+	for i, g := range groups {
+		duplicates[i] = Duplicate{Field: field, Value: g.Value, Accounts: g.Accounts}
+	}
+	return duplicates, nil
+}
+
+// MarkLegacyEmailsVerified marks accounts created before email verification
+// existed as verified, so their owners are not locked out by the policy for
+// unverified users. New accounts always store the field, which makes this
+// safe to run on every start.
+func (r *MongoRepository) MarkLegacyEmailsVerified(ctx context.Context) (int64, error) {
+	res, err := r.collection.UpdateMany(ctx,
+		bson.M{verifiedField: bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{verifiedField: true}})
+	if err != nil {
+		return 0, fmt.Errorf("mark legacy emails verified: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// MigrateLegacyDeletions moves the deletion time the old Delete handler
+// stored in deleted_ad to deletedat, so those accounts are deleted for every
+// lookup, and returns their nicknames. It is safe to run on every start.
+func (r *MongoRepository) MigrateLegacyDeletions(ctx context.Context) ([]string, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{baselineDeletedAtField: bson.M{"$exists": true}})
+	if err != nil {
+		return nil, fmt.Errorf("find legacy deletions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var nicknames []string
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return nicknames, fmt.Errorf("user decode error: %w", err)
+		}
+		update, deleted := legacyDeletionUpdate(doc)
+		if _, err := r.collection.UpdateOne(ctx, bson.M{"_id": doc["_id"]}, update); err != nil {
+			return nicknames, fmt.Errorf("migrate deletion of %v: %w", doc[nicknameField], err)
+		}
+		if nickname, ok := doc[nicknameField].(string); ok && deleted {
+			nicknames = append(nicknames, nickname)
+		}
+	}
+	return nicknames, cursor.Err()
+}
+
+// legacyDeletionUpdate returns the update that folds deleted_ad of a user
+// document into deletedat, and whether the account counts as deleted. An
+// existing later deletion time is kept.
+func legacyDeletionUpdate(doc bson.M) (bson.M, bool) {
+	update := bson.M{"$unset": bson.M{baselineDeletedAtField: ""}}
+	legacy, _ := doc[baselineDeletedAtField].(primitive.DateTime)
+	current, _ := doc[deletedAtField].(primitive.DateTime)
+	zero := primitive.NewDateTimeFromTime(time.Time{})
+	if legacy > zero && legacy > current {
+		update["$set"] = bson.M{deletedAtField: legacy}
+	}
+	return update, legacy > zero || current > zero
+}
+
+func (r *MongoRepository) FindByNickname(ctx context.Context, nickname string) (*User, error) {
+	return r.findOne(ctx, active(bson.M{nicknameField: nickname}))
+}
+
+func (r *MongoRepository) FindLookalike(ctx context.Context, nickname string) (*User, error) {
+	return r.findOne(ctx, active(bson.M{nicknameKeyField: NicknameKey(nickname)}))
+}
+
+func (r *MongoRepository) FindByEmail(ctx context.Context, email string) (*User, error) {
+	return r.findOne(ctx, active(bson.M{emailField: email}))
+}
+
+func (r *MongoRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	return r.findOne(ctx, active(bson.M{"_id": id}))
+}
+
+func (r *MongoRepository) Insert(ctx context.Context, user *User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	user.NicknameKey = NicknameKey(user.Nickname)
+	_, err := r.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrUserExists
+	}
+	if err != nil {
+		return fmt.Errorf("insert error: %w", err)
+	}
+	return nil
+}
+
+func (r *MongoRepository) Update(ctx context.Context, user *User) error {
+	user.NicknameKey = NicknameKey(user.Nickname)
+	if err := r.checkRename(ctx, user); err != nil {
+		return err
+	}
+	raw, err := bson.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("encode user: %w", err)
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return fmt.Errorf("encode user: %w", err)
+	}
+	for _, owned := range []string{"_id", ratingField, votedAtField, legacyRatingListField,
+		totpSecretField, totpEnabledField, totpLastStepField, recoveryCodesField} {
+		delete(fields, owned)
+	}
+	res, err := r.collection.UpdateOne(ctx, active(bson.M{"_id": user.ID}), bson.M{"$set": fields})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrUserExists
+	}
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// checkRename fails with ErrUserExists when user takes a nickname that looks
+// like another account's. Accounts that already shared their key before
+// NicknameKey existed can still be updated as long as they keep it.
+func (r *MongoRepository) checkRename(ctx context.Context, user *User) error {
+	err := r.collection.FindOne(ctx, bson.M{"_id": bson.M{"$ne": user.ID}, nicknameKeyField: user.NicknameKey}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("find look-alike nickname: %w", err)
+	}
+	var stored User
+	err = r.collection.FindOne(ctx, bson.M{"_id": user.ID}, options.FindOne().SetProjection(bson.M{nicknameKeyField: 1})).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("find one error: %w", err)
+	}
+	if stored.NicknameKey != user.NicknameKey {
+		return ErrUserExists
+	}
+	return nil
+}
+
+func (r *MongoRepository) SetTOTP(ctx context.Context, id primitive.ObjectID, secret string, enabled bool, recoveryCodes []string) error {
+	if recoveryCodes == nil {
+		recoveryCodes = []string{}
+	}
+	res, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		totpSecretField:    secret,
+		totpEnabledField:   enabled,
+		totpLastStepField:  0,
+		recoveryCodesField: recoveryCodes,
+	}})
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *MongoRepository) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	res, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, totpLastStepField: bson.M{"$not": bson.M{"$gte": step}}},
+		bson.M{"$set": bson.M{totpLastStepField: step}})
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return r.missingOr(ctx, id, ErrCodeUsed)
+	}
+	return nil
+}
+
+func (r *MongoRepository) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, digest string) error {
+	res, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, recoveryCodesField: digest},
+		bson.M{"$pull": bson.M{recoveryCodesField: digest}})
+	if err != nil {
+		return fmt.Errorf("update error: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return r.missingOr(ctx, id, ErrCodeUsed)
+	}
+	return nil
+}
+
+// missingOr tells a conditional update that matched nothing because the
+// user does not exist apart from one whose condition failed.
+func (r *MongoRepository) missingOr(ctx context.Context, id primitive.ObjectID, err error) error {
+	if _, findErr := r.FindByID(ctx, id); findErr != nil {
+		return findErr
+	}
+	return err
+}
+
+func (r *MongoRepository) SoftDelete(ctx context.Context, nickname string) error {
+	update := bson.M{
+		"$set": bson.M{
+			deletedAtField: time.Now(),
+		},
+	}
+	res, err := r.collection.UpdateOne(ctx, active(bson.M{nicknameField: nickname}), update)
+	if err != nil {
+		return fmt.Errorf("delete error: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *MongoRepository) ListByRating(ctx context.Context, skip, limit int64) ([]User, error) {
+	queryOptions := options.Find().SetSort(ratingOrder)
+	queryOptions.SetLimit(limit)
+	queryOptions.SetSkip(skip)
+	cursor, err := r.collection.Find(ctx, active(bson.M{}), queryOptions)
+	if err != nil {
+		return nil, fmt.Errorf("find error: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var result []User
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, fmt.Errorf("user decode error: %w", err)
+	}
+	return result, nil
+}
+
+func (r *MongoRepository) Restore(ctx context.Context, nickname string) error {
+	res, err := r.collection.UpdateOne(ctx,
+		deleted(bson.M{nicknameField: nickname}),
+		bson.M{"$set": bson.M{deletedAtField: time.Time{}}})
+	if err != nil {
+		return fmt.Errorf("restore error: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *MongoRepository) ListDeleted(ctx context.Context, skip, limit int64) ([]User, error) {
+	queryOptions := options.Find().SetSort(bson.D{{Key: deletedAtField, Value: -1}}).SetSkip(skip).SetLimit(limit)
+	cursor, err := r.collection.Find(ctx, deleted(bson.M{}), queryOptions)
+	if err != nil {
+		return nil, fmt.Errorf("find error: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var result []User
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, fmt.Errorf("user decode error: %w", err)
+	}
+	return result, nil
+}
+
+func (r *MongoRepository) PurgeDeleted(ctx context.Context, cutoff time.Time) ([]string, error) {
+	filter := bson.M{deletedAtField: bson.M{"$gt": time.Time{}, "$lt": cutoff}}
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{nicknameField: 1}))
+	if err != nil {
+		return nil, fmt.Errorf("find error: %w", err)
+	}
+	var found []User
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, fmt.Errorf("user decode error: %w", err)
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	ids := make([]primitive.ObjectID, len(found))
+	for i, u := range found {
+		ids[i] = u.ID
+	}
+	// Accounts restored since the Find no longer match the deletedat
+	// condition; they are kept and left out of the result.
+	filter["_id"] = bson.M{"$in": ids}
+	res, err := r.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("purge error: %w", err)
+	}
+	if res.DeletedCount == int64(len(ids)) {
+		return nicknamesOf(found, nil), nil
+	}
+	cursor, err = r.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("find error: %w", err)
+	}
+	var kept []User
+	if err := cursor.All(ctx, &kept); err != nil {
+		return nil, fmt.Errorf("user decode error: %w", err)
+	}
+	return nicknamesOf(found, kept), nil
+}
+
+// nicknamesOf returns the nicknames of the users that are not in except.
+func nicknamesOf(list, except []User) []string {
+	skip := make(map[primitive.ObjectID]bool, len(except))
+	for _, u := range except {
+		skip[u.ID] = true
+	}
+	var nicknames []string
+	for _, u := range list {
+		if !skip[u.ID] {
+			nicknames = append(nicknames, u.Nickname)
+		}
+	}
+	return nicknames
+}
+
+// active restricts filter to users that are not soft-deleted. Live users
+// store the zero time or, if created before soft deletes, nothing at all.
+func active(filter bson.M) bson.M {
+	filter[deletedAtField] = bson.M{"$not": bson.M{"$gt": time.Time{}}}
+	return filter
+}
+
+func deleted(filter bson.M) bson.M {
+	filter[deletedAtField] = bson.M{"$gt": time.Time{}}
+	return filter
+}
+
+func (r *MongoRepository) findOne(ctx context.Context, filter bson.M) (*User, error) {
+	var userResult User
+	err := r.collection.FindOne(ctx, filter).Decode(&userResult)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find one error: %w", err)
+	}
+	return &userResult, nil
+}
@@ -0,0 +1,138 @@
+package users
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestLegacyDeletionUpdate(t *testing.T) {
+	deletedAt := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	later := deletedAt.Add(time.Hour)
+	// decoded round-trips a document through BSON as the driver hands it
+	// to the migration.
+	decoded := func(d bson.D) bson.M {
+		data, err := bson.Marshal(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc bson.M
+		if err := bson.Unmarshal(data, &doc); err != nil {
+			t.Fatal(err)
+		}
+		return doc
+	}
+
+	tests := []struct {
+		name        string
+		doc         bson.D
+		expectedSet interface{}
+		deleted     bool
+	}{
+		{"deleted by the old handler", bson.D{
+			{Key: "nickname", Value: "gone"}, {Key: "deletedat", Value: time.Time{}}, {Key: "deleted_ad", Value: deletedAt},
+		}, primitive.NewDateTimeFromTime(deletedAt), true},
+		{"no deletedat yet", bson.D{
+			{Key: "nickname", Value: "gone"}, {Key: "deleted_ad", Value: deletedAt},
+		}, primitive.NewDateTimeFromTime(deletedAt), true},
+		{"deleted again since", bson.D{
+			{Key: "nickname", Value: "gone"}, {Key: "deletedat", Value: later}, {Key: "deleted_ad", Value: deletedAt},
+		}, nil, true},
+		{"zero legacy time", bson.D{
+			{Key: "nickname", Value: "live"}, {Key: "deletedat", Value: time.Time{}}, {Key: "deleted_ad", Value: time.Time{}},
+		}, nil, false},
+	}
+	for _, tt := range tests {
+		update, deleted := legacyDeletionUpdate(decoded(tt.doc))
+		set, _ := update["$set"].(bson.M)
+		if set[deletedAtField] != tt.expectedSet || deleted != tt.deleted {
+			t.Errorf("legacyDeletionUpdate(%s) = %v, %v, expected deletedat %v and deleted %v", tt.name, update, deleted, tt.expectedSet, tt.deleted)
+		}
+		if unset, _ := update["$unset"].(bson.M); unset == nil || unset[baselineDeletedAtField] == nil {
+			t.Errorf("legacyDeletionUpdate(%s) does not unset %s", tt.name, baselineDeletedAtField)
+		}
+	}
+}
+
+func TestEnsureIndexesAllowsLookalikeNicknames(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Alex and alex", func(mt *mtest.T) {
+		repo := &MongoRepository{collection: mt.Coll}
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+		alex, lower := primitive.NewObjectID(), primitive.NewObjectID()
+		mt.AddMockResponses(
+			// users without a nickname key
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: alex}, {Key: nicknameField, Value: "Alex"}},
+				bson.D{{Key: "_id", Value: lower}, {Key: nicknameField, Value: "alex"}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			// no duplicate nicknames or emails
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+			mtest.CreateSuccessResponse(),
+		)
+		if err := repo.EnsureIndexes(context.Background()); err != nil {
+			mt.Fatalf("EnsureIndexes() error = %v", err)
+		}
+
+		var indexes []bson.Raw
+		for e := mt.GetStartedEvent(); e != nil; e = mt.GetStartedEvent() {
+			if e.CommandName == "createIndexes" {
+				values, _ := e.Command.Lookup("indexes").Array().Values()
+				for _, v := range values {
+					indexes = append(indexes, v.Document())
+				}
+			}
+		}
+		if len(indexes) == 0 {
+			mt.Fatal("EnsureIndexes() created no indexes")
+		}
+		for _, index := range indexes {
+			_, keyed := index.Lookup("key").Document().LookupErr(nicknameKeyField)
+			unique, _ := index.Lookup("unique").BooleanOK()
+			if keyed == nil && unique {
+				mt.Errorf("EnsureIndexes() made %s unique", nicknameKeyField)
+			}
+		}
+	})
+}
+
+func TestPurgeDeletedSkipsRestoredAccounts(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("restored between find and delete", func(mt *mtest.T) {
+		repo := &MongoRepository{collection: mt.Coll}
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+		gone, restored := primitive.NewObjectID(), primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: gone}, {Key: nicknameField, Value: "gone"}},
+				bson.D{{Key: "_id", Value: restored}, {Key: nicknameField, Value: "restored"}}),
+			// restored matched no longer, so only one is deleted
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: restored}}),
+		)
+		purged, err := repo.PurgeDeleted(context.Background(), time.Now())
+		if err != nil || len(purged) != 1 || purged[0] != "gone" {
+			mt.Fatalf("PurgeDeleted() = %v, %v, expected [gone]", purged, err)
+		}
+
+		for e := mt.GetStartedEvent(); e != nil; e = mt.GetStartedEvent() {
+			if e.CommandName != "delete" {
+				continue
+			}
+			values, _ := e.Command.Lookup("deletes").Array().Values()
+			filter := values[0].Document().Lookup("q").Document()
+			ids, _ := filter.Lookup("_id", "$in").Array().Values()
+			if _, err := filter.LookupErr(deletedAtField); err != nil || len(ids) != 2 {
+				mt.Errorf("delete filter = %s, expected the found ids and the deletedat condition", filter)
+			}
+		}
+	})
+}
@@ -0,0 +1,216 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const votesTableName = "votes"
+
+// MongoVoteRepository applies each vote in a transaction: the vote document
+// and the $inc of the target rating are committed together, and the unique
+// (voter, target) index rejects concurrent duplicates.
+type MongoVoteRepository struct {
+	client *mongo.Client
+	votes  *mongo.Collection
+	users  *mongo.Collection
+}
+
+func NewMongoVoteRepository(client *mongo.Client, database string) *MongoVoteRepository {
+	db := client.Database(database)
+	return &MongoVoteRepository{
+		client: client,
+		votes:  db.Collection(votesTableName),
+		users:  db.Collection(tableName),
+	}
+}
+
+func (r *MongoVoteRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.votes.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "voter", Value: 1}, {Key: "target", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "target", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("create vote indexes: %w", err)
+	}
+	return nil
+}
+
+func (r *MongoVoteRepository) Vote(ctx context.Context, voter, target string, value int, cooldown time.Duration) (int, error) {
+	session, err := r.client.StartSession()
+	if err != nil {
+		return 0, fmt.Errorf("start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	rating, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		now := time.Now()
+		res, err := r.users.UpdateOne(sc,
+			active(bson.M{nicknameField: voter, votedAtField: bson.M{"$lte": now.Add(-cooldown)}}),
+			bson.M{"$set": bson.M{votedAtField: now}})
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			return nil, ErrVoteCooldown
+		}
+
+		deleted, err := r.votes.DeleteOne(sc, bson.M{"voter": voter, "target": target, "value": -value})
+		if err != nil {
+			return nil, err
+		}
+		if deleted.DeletedCount == 0 {
+			_, err = r.votes.InsertOne(sc, Vote{ID: primitive.NewObjectID(), Voter: voter, Target: target, Value: value, CreatedAt: now})
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, ErrAlreadyVoted
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var updated User
+		err = r.users.FindOneAndUpdate(sc,
+			active(bson.M{nicknameField: target}),
+			bson.M{"$inc": bson.M{ratingField: value}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return updated.Rating, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rating.(int), nil
+}
+
+func (r *MongoVoteRepository) FindVote(ctx context.Context, voter, target string) (*Vote, error) {
+	var vote Vote
+	err := r.votes.FindOne(ctx, bson.M{"voter": voter, "target": target}).Decode(&vote)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrVoteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find vote: %w", err)
+	}
+	return &vote, nil
+}
+
+func (r *MongoVoteRepository) DeleteForUsers(ctx context.Context, nicknames []string) error {
+	if len(nicknames) == 0 {
+		return nil
+	}
+	_, err := r.votes.DeleteMany(ctx, bson.M{"$or": bson.A{
+		bson.M{"voter": bson.M{"$in": nicknames}},
+		bson.M{"target": bson.M{"$in": nicknames}},
+	}})
+	if err != nil {
+		return fmt.Errorf("delete votes: %w", err)
+	}
+	return nil
+}
+
+// MigrateRatingLists moves the JSON vote lists of accounts from before the
+// votes collection into it and folds the capitalized Rating and VotedAt keys
+// of that time into the fields used now, removing the old keys. Ratings are
+// not recomputed since they already include those votes. It is safe to run
+// more than once.
+func (r *MongoVoteRepository) MigrateRatingLists(ctx context.Context) (int, error) {
+	cursor, err := r.users.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{baselineRatingListField: bson.M{"$exists": true}},
+		bson.M{baselineRatingField: bson.M{"$exists": true}},
+		bson.M{baselineVotedAtField: bson.M{"$exists": true}},
+		bson.M{legacyRatingListField: bson.M{"$nin": bson.A{"", nil}}},
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("find users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return migrated, fmt.Errorf("user decode error: %w", err)
+		}
+		votes, update, err := baselineVoteFields(doc)
+		if err != nil {
+			return migrated, err
+		}
+		for _, vote := range votes {
+			_, err := r.votes.InsertOne(ctx, vote)
+			if err != nil && !mongo.IsDuplicateKeyError(err) {
+				return migrated, fmt.Errorf("insert vote: %w", err)
+			}
+			if err == nil {
+				migrated++
+			}
+		}
+		_, err = r.users.UpdateOne(ctx, bson.M{"_id": doc["_id"]}, update)
+		if err != nil {
+			return migrated, fmt.Errorf("clear vote list of %v: %w", doc[nicknameField], err)
+		}
+	}
+	return migrated, cursor.Err()
+}
+
+// baselineVoteFields returns the votes stored in the vote list of a user
+// document and the update that folds the old keys into the current ones.
+//
+// The old code kept the rating in Rating only, so the lowercase rating holds
+// what votes added since; both are summed. VotedAt wins if it is later.
+func baselineVoteFields(doc bson.M) ([]Vote, bson.M, error) {
+	nickname, _ := doc[nicknameField].(string)
+	list, _ := doc[baselineRatingListField].(string)
+	if list == "" {
+		list, _ = doc[legacyRatingListField].(string)
+	}
+	votes, err := legacyVotes(&User{Nickname: nickname, UserRatingList: list})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	update := bson.M{"$unset": bson.M{
+		baselineRatingListField: "",
+		baselineRatingField:     "",
+		baselineVotedAtField:    "",
+		legacyRatingListField:   "",
+	}}
+	if rating := bsonInt(doc[baselineRatingField]); rating != 0 {
+		update["$inc"] = bson.M{ratingField: rating}
+	}
+	if votedAt, ok := doc[baselineVotedAtField].(primitive.DateTime); ok {
+		current, _ := doc[votedAtField].(primitive.DateTime)
+		if votedAt > current {
+			update["$set"] = bson.M{votedAtField: votedAt}
+		}
+	}
+	return votes, update, nil
+}
+
+func bsonInt(v interface{}) int {
+	switch n := v.(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,41 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrClientNotFound = errors.New("oauth client not found")
+
+// OAuthClient is an application allowed to log users in through the OpenID
+// Connect provider.
+type OAuthClient struct {
+	// ID is the client_id, generated at registration.
+	ID   string `bson:"_id"`
+	Name string `bson:"name"`
+	// SecretDigest is the SHA-256 of the client secret. It is empty for
+	// public clients, such as native and single-page apps, which cannot
+	// keep a secret and rely on PKCE alone.
+	SecretDigest string `bson:"secret_digest,omitempty"`
+	// RedirectURIs are compared to the redirect_uri of a request exactly.
+	RedirectURIs []string `bson:"redirect_uris"`
+	// Scopes the client may request.
+	Scopes    []string  `bson:"scopes"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// Public reports whether the client has no secret.
+func (c *OAuthClient) Public() bool {
+	return c.SecretDigest == ""
+}
+
+// OAuthClientRepository stores OAuth clients. Lookups without a result
+// return ErrClientNotFound.
+type OAuthClientRepository interface {
+	Insert(ctx context.Context, client *OAuthClient) error
+	FindByID(ctx context.Context, id string) (*OAuthClient, error)
+	// List returns every client, oldest first.
+	List(ctx context.Context) ([]OAuthClient, error)
+	Delete(ctx context.Context, id string) error
+}
@@ -0,0 +1,65 @@
+package users
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+type Permission string
+
+const (
+	PermProfileView       Permission = "profile:view"
+	PermProfileEditSelf   Permission = "profile:edit:self"
+	PermProfileEditAny    Permission = "profile:edit:any"
+	PermProfileDeleteSelf Permission = "profile:delete:self"
+	PermUserDelete        Permission = "user:delete"
+	PermUserUnlock        Permission = "user:unlock"
+	PermUserRestore       Permission = "user:restore"
+	PermSessionRevoke     Permission = "session:revoke"
+	PermAPIKeyRevoke      Permission = "apikey:revoke"
+	PermOAuthClientManage Permission = "oauth:clients"
+	PermCacheView         Permission = "cache:view"
+	PermRatingVote        Permission = "rating:vote"
+)
+
+var userPermissions = []Permission{
+	PermProfileView,
+	PermProfileEditSelf,
+	PermProfileDeleteSelf,
+	PermRatingVote,
+}
+
+var rolePermissions = map[string][]Permission{
+	RoleUser:      userPermissions,
+	RoleModerator: append([]Permission{PermProfileEditAny}, userPermissions...),
+	RoleAdmin:     append([]Permission{PermProfileEditAny, PermUserDelete, PermUserUnlock, PermUserRestore, PermSessionRevoke, PermAPIKeyRevoke, PermOAuthClientManage, PermCacheView}, userPermissions...),
+}
+
+// roleRanks orders the roles by power, so that nobody can act on an account
+// above their own.
+var roleRanks = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// Outranks reports whether role is above other. Empty and unknown roles rank
+// as RoleUser.
+func Outranks(role, other string) bool {
+	return roleRanks[role] > roleRanks[other]
+}
+
+// HasPermission reports whether role grants p. Accounts created before roles
+// were enforced have an empty role and are treated as RoleUser.
+func HasPermission(role string, p Permission) bool {
+	if role == "" {
+		role = RoleUser
+	}
+	for _, granted := range rolePermissions[role] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package users
+
+import "testing"
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		role     string
+		perm     Permission
+		expected bool
+	}{
+		{RoleUser, PermProfileEditSelf, true},
+		{RoleUser, PermRatingVote, true},
+		{RoleUser, PermProfileEditAny, false},
+		{RoleUser, PermUserDelete, false},
+		{"", PermRatingVote, true},
+		{"", PermUserDelete, false},
+		{RoleModerator, PermProfileEditAny, true},
+		{RoleModerator, PermUserDelete, false},
+		{RoleAdmin, PermUserDelete, true},
+		{"superuser", PermProfileView, false},
+	}
+	for _, tt := range tests {
+		result := HasPermission(tt.role, tt.perm)
+		if result != tt.expected {
+			t.Errorf("HasPermission(%s, %s) = %v, expected %v", tt.role, tt.perm, result, tt.expected)
+		}
+	}
+}
+
+func TestOutranks(t *testing.T) {
+	tests := []struct {
+		role, other string
+		expected    bool
+	}{
+		{RoleAdmin, RoleModerator, true},
+		{RoleModerator, RoleUser, true},
+		{RoleModerator, "", true},
+		{RoleModerator, RoleModerator, false},
+		{RoleModerator, RoleAdmin, false},
+		{"superuser", RoleUser, false},
+	}
+	for _, tt := range tests {
+		if result := Outranks(tt.role, tt.other); result != tt.expected {
+			t.Errorf("Outranks(%s, %s) = %v, expected %v", tt.role, tt.other, result, tt.expected)
+		}
+	}
+}
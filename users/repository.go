@@ -0,0 +1,54 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists   = errors.New("user with this email or username exists")
+	ErrCodeUsed     = errors.New("code already used")
+)
+
+// UserRepository is the storage used by the api handlers. Implementations
+// return ErrUserNotFound when a lookup has no result and ErrUserExists when
+// an insert collides with an existing email or with a nickname of the same
+// NicknameKey, or an update renames a user onto one. Soft-deleted users
+// are invisible to every method except Restore, ListDeleted and
+// PurgeDeleted; their nickname and email stay taken until they are purged.
+type UserRepository interface {
+	FindByNickname(ctx context.Context, nickname string) (*User, error)
+	// FindLookalike returns the user whose nickname has the same
+	// NicknameKey as nickname.
+	FindLookalike(ctx context.Context, nickname string) (*User, error)
+	FindByEmail(ctx context.Context, email string) (*User, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*User, error)
+	Insert(ctx context.Context, user *User) error
+	// Update saves the user's profile fields. Rating and VotedAt are left as
+	// stored; they are only changed through VoteRepository. The 2FA fields
+	// are left as well and only change through the methods below.
+	Update(ctx context.Context, user *User) error
+	// SetTOTP replaces the 2FA state of a user and resets its last used step.
+	SetTOTP(ctx context.Context, id primitive.ObjectID, secret string, enabled bool, recoveryCodes []string) error
+	// UseTOTPStep records step as used. It fails with ErrCodeUsed unless step
+	// is later than the last recorded one, which stops code replays.
+	UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) error
+	// UseRecoveryCode removes the recovery code digest, or fails with
+	// ErrCodeUsed when the user does not have it.
+	UseRecoveryCode(ctx context.Context, id primitive.ObjectID, digest string) error
+	SoftDelete(ctx context.Context, nickname string) error
+	// ListByRating pages through live users, highest rating first and
+	// equal ratings by nickname.
+	ListByRating(ctx context.Context, skip, limit int64) ([]User, error)
+	// Restore undoes SoftDelete.
+	Restore(ctx context.Context, nickname string) error
+	// ListDeleted returns soft-deleted users, most recently deleted first.
+	ListDeleted(ctx context.Context, skip, limit int64) ([]User, error)
+	// PurgeDeleted removes users soft-deleted before cutoff for good and
+	// returns their nicknames.
+	PurgeDeleted(ctx context.Context, cutoff time.Time) ([]string, error)
+}
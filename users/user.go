@@ -3,22 +3,52 @@ package users
 import (
 	"time"
 
+	"awesomeProject/internal/validation"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type User struct {
-	ID             primitive.ObjectID `bson:"_id"`
-	FirstName      string
-	LastName       string
-	Nickname       string
-	Email          string
-	Password       string
-	Information    string
-	Role           string
-	Rating         int
+	ID        primitive.ObjectID `bson:"_id"`
+	FirstName string
+	LastName  string
+	Nickname  string
+	// NicknameKey is NicknameKey(Nickname). Repositories set it on Insert
+	// and Update and refuse new nicknames whose key is taken, so nicknames
+	// that differ only in case or look-alike letters cannot both be
+	// registered. Pairs from before the check keep sharing their key.
+	NicknameKey string
+	Email       string
+	// EmailVerified is false until the owner follows the link sent to Email.
+	EmailVerified bool
+	Password      string
+	Information   string
+	Role          string
+	// TOTPSecret is the authenticator secret. It is set at enrolment and
+	// only used for logins once TOTPEnabled is true.
+	TOTPSecret   string
+	TOTPEnabled  bool
+	TOTPLastStep int64
+	// RecoveryCodes holds digests of the unused 2FA recovery codes.
+	RecoveryCodes []string
+	Rating        int
+	// UserRatingList is the JSON vote list used before votes got their own
+	// collection. It is only read by MongoVoteRepository.MigrateRatingLists.
 	UserRatingList string
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
 	DeletedAt      time.Time
 	VotedAt        time.Time
 }
+
+// Deleted reports whether the account was soft-deleted. Repositories hide
+// deleted accounts from every lookup except the admin ones.
+func (u *User) Deleted() bool {
+	return !u.DeletedAt.IsZero()
+}
+
+// NicknameKey returns the form in which nicknames are unique: "Ace", "ace"
+// and "асе" spelled with Cyrillic letters all share one key.
+func NicknameKey(nickname string) string {
+	return validation.Skeleton(validation.NormalizeName(nickname))
+}
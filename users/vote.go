@@ -0,0 +1,64 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrAlreadyVoted = errors.New("user can't vote twice for one person")
+	ErrVoteCooldown = errors.New("you can vote once per cooldown period")
+	ErrVoteNotFound = errors.New("vote not found")
+)
+
+// Vote is one voter's current opinion about a target, stored in its own
+// collection with a unique (voter, target) index.
+type Vote struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Voter     string             `bson:"voter"`
+	Target    string             `bson:"target"`
+	Value     int                `bson:"value"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// VoteRepository owns User.Rating and User.VotedAt; UserRepository.Update
+// never writes them.
+type VoteRepository interface {
+	// Vote applies value (+1 or -1) from voter to target and returns the
+	// target's new rating. A vote opposite to an existing one cancels it; a
+	// repeated vote fails with ErrAlreadyVoted. The voter may vote once per
+	// cooldown, otherwise ErrVoteCooldown is returned.
+	Vote(ctx context.Context, voter, target string, value int, cooldown time.Duration) (int, error)
+	FindVote(ctx context.Context, voter, target string) (*Vote, error)
+	// DeleteForUsers removes the votes cast by or for the given users.
+	// Ratings are left as they are.
+	DeleteForUsers(ctx context.Context, nicknames []string) error
+}
+
+// legacyVotes decodes the JSON vote list that used to be stored in
+// User.UserRatingList.
+func legacyVotes(u *User) ([]Vote, error) {
+	if u.UserRatingList == "" {
+		return nil, nil
+	}
+	var list []UserRatingList
+	if err := json.Unmarshal([]byte(u.UserRatingList), &list); err != nil {
+		return nil, fmt.Errorf("decode vote list of %s: %w", u.Nickname, err)
+	}
+	votes := make([]Vote, 0, len(list))
+	for _, item := range list {
+		votes = append(votes, Vote{
+			ID:        primitive.NewObjectID(),
+			Voter:     item.VotedNickname,
+			Target:    u.Nickname,
+			Value:     item.VotedRating,
+			CreatedAt: item.VotedDate,
+		})
+	}
+	return votes, nil
+}
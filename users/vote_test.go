@@ -0,0 +1,89 @@
+package users
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// baselineUser is a user document as the rating code before the votes
+// collection left it: lowercase fields from registration and capitalized
+// ones from its $set after each vote.
+func baselineUser(t *testing.T, lowercaseRating, rating int, votedAt time.Time, list []UserRatingList) bson.M {
+	t.Helper()
+	raw, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := bson.Marshal(bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "nickname", Value: "target"},
+		{Key: "rating", Value: lowercaseRating},
+		{Key: "userratinglist", Value: ""},
+		{Key: "votedat", Value: time.Time{}},
+		{Key: "VotedAt", Value: votedAt},
+		{Key: "Rating", Value: rating},
+		{Key: "UserRatingList", Value: string(raw)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestBaselineVoteFields(t *testing.T) {
+	votedAt := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	list := []UserRatingList{
+		{VotedNickname: "alice", VotedRating: 1, VotedDate: votedAt},
+		{VotedNickname: "bob", VotedRating: -1, VotedDate: votedAt},
+		{VotedNickname: "carol", VotedRating: 1, VotedDate: votedAt},
+	}
+
+	tests := []struct {
+		name                    string
+		lowercaseRating, rating int
+		expectedInc             int
+	}{
+		{"before any new vote", 0, 1, 1},
+		{"after new votes", 2, 1, 1},
+		{"no baseline rating", 3, 0, 0},
+	}
+	for _, tt := range tests {
+		votes, update, err := baselineVoteFields(baselineUser(t, tt.lowercaseRating, tt.rating, votedAt, list))
+		if err != nil {
+			t.Fatalf("baselineVoteFields(%s) error = %v", tt.name, err)
+		}
+		if len(votes) != 3 || votes[1].Voter != "bob" || votes[1].Target != "target" || votes[1].Value != -1 {
+			t.Errorf("baselineVoteFields(%s) votes = %+v", tt.name, votes)
+		}
+		inc, _ := update["$inc"].(bson.M)
+		if got := inc[ratingField]; tt.expectedInc == 0 && got != nil || tt.expectedInc != 0 && got != tt.expectedInc {
+			t.Errorf("baselineVoteFields(%s) $inc = %v, expected rating +%d", tt.name, inc, tt.expectedInc)
+		}
+		unset, _ := update["$unset"].(bson.M)
+		for _, key := range []string{"UserRatingList", "Rating", "VotedAt", "userratinglist"} {
+			if _, ok := unset[key]; !ok {
+				t.Errorf("baselineVoteFields(%s) does not unset %s", tt.name, key)
+			}
+		}
+		set, _ := update["$set"].(bson.M)
+		if set[votedAtField] != primitive.NewDateTimeFromTime(votedAt) {
+			t.Errorf("baselineVoteFields(%s) $set = %v, expected votedat %v", tt.name, set, votedAt)
+		}
+	}
+}
+
+func TestBaselineVoteFieldsInvalidList(t *testing.T) {
+	doc := baselineUser(t, 0, 1, time.Now(), nil)
+	doc[baselineRatingListField] = "not json"
+	if _, _, err := baselineVoteFields(doc); err == nil {
+		t.Errorf("baselineVoteFields(broken list) error = nil")
+	}
+}